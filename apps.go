@@ -2,8 +2,38 @@ package main
 
 import (
 	"github.com/hello/sanders/core"
+	"os"
+	"path/filepath"
 )
 
+// configPath returns the location of the sanders config file. It can be
+// overridden with SANDERS_CONFIG.
+func configPath() string {
+	if path := os.Getenv("SANDERS_CONFIG"); path != "" {
+		return path
+	}
+	return filepath.Join(os.Getenv("HOME"), ".sanders", "config.json")
+}
+
+// loadConfig returns the config file when it exists, and the built-in
+// suripuApps and environments otherwise.
+func loadConfig(path string) (*core.Config, string, error) {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return &core.Config{
+			Apps:              suripuApps,
+			Environments:      core.DefaultEnvironments,
+			UserDataTemplates: core.DefaultUserDataTemplates,
+		}, "built-in", nil
+	}
+
+	config, err := core.LoadConfig(path)
+	if err != nil {
+		return nil, path, err
+	}
+	return config, path, nil
+}
+
+// suripuApps is the fallback used when no config file is present.
 var suripuApps = []core.SuripuApp{
 	{
 		Name:                  "suripu-app",
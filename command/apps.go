@@ -0,0 +1,102 @@
+package command
+
+import (
+	"flag"
+	"fmt"
+	"github.com/hello/sanders/core"
+	"github.com/mitchellh/cli"
+	"os"
+	"strings"
+)
+
+type AppsListCommand struct {
+	Ui     cli.ColoredUi
+	Apps   []core.SuripuApp
+	Envs   core.Environments
+	Source string
+}
+
+func (c *AppsListCommand) Help() string {
+	helpText := `Usage: sanders apps list`
+	return strings.TrimSpace(helpText)
+}
+
+func (c *AppsListCommand) Run(args []string) int {
+	c.Ui.Output(fmt.Sprintf("Apps loaded from: %s\n", c.Source))
+	c.Ui.Info(fmt.Sprintf("%-16s\t%-12s\t%-10s\t%s\t%s\t%s", "Name:", "SG:", "Type:", "Capacity:", "Java:", "Package path:"))
+	for _, app := range c.Apps {
+		line := fmt.Sprintf("%-16s\t%-12s\t%-10s\t%d\t\t%d\t%s", app.Name, app.SecurityGroup, app.InstanceType, app.TargetDesiredCapacity, app.JavaVersion, app.PackagePath)
+		if app.UsesPacker {
+			line += " (packer)"
+		}
+		if app.Spot != nil {
+			line += fmt.Sprintf(" (spot: %s)", app.Spot.Price)
+		}
+		c.Ui.Output(line)
+	}
+
+	c.Ui.Output("")
+	c.Ui.Info(fmt.Sprintf("%-10s	%-36s	%-16s	%s", "Env:", "ASGs:", "Package prefix:", "Approval:"))
+	for _, env := range c.Envs {
+		approval := "no"
+		if env.RequiresApproval {
+			approval = "required"
+		}
+		c.Ui.Output(fmt.Sprintf("%-10s	%-36s	%-16s	%s", env.Name, strings.Join(env.GroupTemplates, ", "), env.PackagePrefix, approval))
+	}
+	return 0
+}
+
+func (c *AppsListCommand) Synopsis() string {
+	return "Lists the apps sanders knows about"
+}
+
+type AppsValidateCommand struct {
+	Ui         cli.ColoredUi
+	ConfigPath string
+}
+
+func (c *AppsValidateCommand) Help() string {
+	helpText := `Usage: sanders apps validate [path]
+
+	Validates the app registry config file. Defaults to the file sanders
+	loads on startup.`
+	return strings.TrimSpace(helpText)
+}
+
+func (c *AppsValidateCommand) Run(args []string) int {
+	cmdFlags := flag.NewFlagSet("apps validate", flag.ContinueOnError)
+	cmdFlags.Usage = func() { c.Ui.Output(c.Help()) }
+	if err := cmdFlags.Parse(args); err != nil {
+		c.Ui.Error(fmt.Sprintf("%s", err))
+		return 1
+	}
+
+	path := c.ConfigPath
+	if cmdFlags.NArg() > 0 {
+		path = cmdFlags.Arg(0)
+	}
+
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		c.Ui.Warn(fmt.Sprintf("%s does not exist, sanders will use its built-in apps.", path))
+		return 1
+	}
+
+	config, err := core.LoadConfig(path)
+	if err != nil {
+		c.Ui.Error(err.Error())
+		return 1
+	}
+
+	if _, err := NewNotifiers(c.Ui, config.Notifiers, ""); err != nil {
+		c.Ui.Error(fmt.Sprintf("%s is invalid:\n\t%s", path, err))
+		return 1
+	}
+
+	c.Ui.Info(fmt.Sprintf("%s is valid (%d apps, %d environments)", path, len(config.Apps), len(config.Environments)))
+	return 0
+}
+
+func (c *AppsValidateCommand) Synopsis() string {
+	return "Validates the app registry config file"
+}
@@ -0,0 +1,190 @@
+package command
+
+import (
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/autoscaling/autoscalingiface"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/hello/sanders/core"
+	"github.com/mitchellh/cli"
+	"strings"
+)
+
+// otherGroup returns the name of the ASG in groups that isn't asgName.
+func otherGroup(groups []*autoscaling.Group, asgName string) string {
+	for _, asg := range groups {
+		if *asg.AutoScalingGroupName != asgName {
+			return *asg.AutoScalingGroupName
+		}
+	}
+	return ""
+}
+
+// servingLC returns the launch configuration of the ASG in groups that isn't
+// asgName when it has instances, the version being replaced by a deploy.
+func servingLC(groups []*autoscaling.Group, asgName string) string {
+	for _, asg := range groups {
+		if *asg.AutoScalingGroupName != asgName && *asg.DesiredCapacity > 0 {
+			return core.GroupLaunchRef(asg)
+		}
+	}
+	return ""
+}
+
+// describeGroup returns the named ASG.
+func describeGroup(service autoscalingiface.AutoScalingAPI, asgName string) (*autoscaling.Group, error) {
+	resp, err := service.DescribeAutoScalingGroups(&autoscaling.DescribeAutoScalingGroupsInput{
+		AutoScalingGroupNames: []*string{aws.String(asgName)},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.AutoScalingGroups) == 0 {
+		return nil, errors.New(fmt.Sprintf("ASG not found: %s", asgName))
+	}
+	return resp.AutoScalingGroups[0], nil
+}
+
+// asgCapacity returns the current desired capacity of the ASG.
+func asgCapacity(service autoscalingiface.AutoScalingAPI, asgName string) (int64, error) {
+	asg, err := describeGroup(service, asgName)
+	if err != nil {
+		return 0, err
+	}
+	return *asg.DesiredCapacity, nil
+}
+
+// scaleASG points the ASG at lcName, a launch configuration or a launch
+// template version, and sets its capacity. Max size is kept at twice the
+// desired capacity so scaling events have room.
+func scaleASG(service autoscalingiface.AutoScalingAPI, asgName, lcName string, desiredCapacity int64) error {
+	maxSize := desiredCapacity * 2
+	updateReq := &autoscaling.UpdateAutoScalingGroupInput{
+		DesiredCapacity:      aws.Int64(desiredCapacity),
+		AutoScalingGroupName: aws.String(asgName),
+		MinSize:              aws.Int64(desiredCapacity),
+		MaxSize:              aws.Int64(maxSize),
+	}
+	if templateName, version, ok := core.ParseLaunchTemplateRef(lcName); ok {
+		updateReq.LaunchTemplate = &autoscaling.LaunchTemplateSpecification{
+			LaunchTemplateName: aws.String(templateName),
+			Version:            aws.String(version),
+		}
+	} else {
+		updateReq.LaunchConfigurationName = aws.String(lcName)
+	}
+
+	_, err := service.UpdateAutoScalingGroup(updateReq)
+	return err
+}
+
+// sunsetASG scales the ASG down to zero instances.
+func sunsetASG(service autoscalingiface.AutoScalingAPI, asgName string) error {
+	numServers := int64(0)
+	updateReq := &autoscaling.UpdateAutoScalingGroupInput{
+		DesiredCapacity:      &numServers,
+		AutoScalingGroupName: &asgName,
+		MinSize:              &numServers,
+		MaxSize:              &numServers,
+	}
+
+	_, err := service.UpdateAutoScalingGroup(updateReq)
+	return err
+}
+
+// deployTags are the tags set on an ASG when a new LC is deployed to it.
+// checksum is the SHA-256 of the package the LC installs, when known.
+func deployTags(asgName string, app *core.SuripuApp, env *core.Environment, lcName, checksum string) []core.Tag {
+	tags := []core.Tag{
+		{
+			AsgName:   asgName,
+			TagName:   "Launch Configuration",
+			TagValue:  lcName,
+			Propagate: true,
+		},
+		{
+			AsgName:   asgName,
+			TagName:   "Name",
+			TagValue:  env.InstanceName(app),
+			Propagate: true,
+		},
+		{
+			AsgName:   asgName,
+			TagName:   "Env",
+			TagValue:  env.Name,
+			Propagate: true,
+		},
+		{
+			AsgName:   asgName,
+			TagName:   "Service",
+			TagValue:  app.Name,
+			Propagate: true,
+		},
+	}
+	if checksum != "" {
+		tags = append(tags, core.Tag{
+			AsgName:   asgName,
+			TagName:   core.PackageChecksumTag,
+			TagValue:  checksum,
+			Propagate: true,
+		})
+	}
+	return tags
+}
+
+// packageChecksum returns the SHA-256 recorded for the package lcName
+// installs, empty when there is none. It only warns on errors, the checksum
+// is informational.
+func packageChecksum(ui cli.ColoredUi, clients *core.Clients, app *core.SuripuApp, env *core.Environment, lcName string) string {
+	if app.UsesPacker {
+		return ""
+	}
+
+	version := env.VersionFromLC(app, lcName)
+	if name, number, ok := core.ParseLaunchTemplateRef(lcName); ok {
+		resp, err := clients.EC2.DescribeLaunchTemplateVersions(&ec2.DescribeLaunchTemplateVersionsInput{
+			LaunchTemplateName: aws.String(name),
+			Versions:           []*string{aws.String(number)},
+		})
+		if err != nil || len(resp.LaunchTemplateVersions) == 0 {
+			ui.Warn(fmt.Sprintf("Couldn't find the version of %s to tag its package checksum: %v", lcName, err))
+			return ""
+		}
+		version = aws.StringValue(resp.LaunchTemplateVersions[0].VersionDescription)
+	}
+	version = strings.TrimSuffix(version, "-emergency")
+
+	checksum, err := core.PackageChecksum(clients.S3, app, env, version)
+	if err != nil {
+		ui.Warn(fmt.Sprintf("Couldn't read the package checksum of %s: %s", lcName, err))
+		return ""
+	}
+	return checksum
+}
+
+func updateASGTags(service autoscalingiface.AutoScalingAPI, tagsToUpdate []core.Tag) (*autoscaling.CreateOrUpdateTagsOutput, error) {
+
+	tags := make([]*autoscaling.Tag, 0)
+
+	for _, tag := range tagsToUpdate {
+		awsTag := &autoscaling.Tag{ // Required
+			Key:               aws.String(tag.TagName), // Required
+			PropagateAtLaunch: aws.Bool(tag.Propagate),
+			ResourceId:        aws.String(tag.AsgName),
+			ResourceType:      aws.String("auto-scaling-group"),
+			Value:             aws.String(tag.TagValue),
+		}
+
+		tags = append(tags, awsTag)
+	}
+
+	//Tag the ASG so version number can be passed to instance
+	params := &autoscaling.CreateOrUpdateTagsInput{
+		Tags: tags,
+	}
+	resp, err := service.CreateOrUpdateTags(params)
+
+	return resp, err
+}
@@ -0,0 +1,231 @@
+package command
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/hello/sanders/core"
+	"github.com/mitchellh/cli"
+	"strings"
+)
+
+type BaseAmiListCommand struct {
+	Ui   cli.ColoredUi
+	Aws  *core.Clients
+	Apps []core.SuripuApp
+	Envs core.Environments
+}
+
+func (c *BaseAmiListCommand) Help() string {
+	helpText := `Usage: sanders base-ami list [-app name] [-env prod] [-format text]
+
+	Lists the base AMI the launch configuration, or launch template version,
+	of each running ASG uses, and whether it is still the latest base AMI
+	configured for the app. Apps using packer AMIs have no base AMI and are
+	left out.
+
+	-app	Only list this app.
+	` + outputFlagsHelp
+	return strings.TrimSpace(helpText)
+}
+
+// BaseAmiUsage is the base AMI an ASG launches instances from.
+type BaseAmiUsage struct {
+	App       string `json:"app"`
+	Group     string `json:"group"`
+	Launch    string `json:"launch"`
+	ImageId   string `json:"image_id"`
+	ImageName string `json:"image_name"`
+	Created   string `json:"created,omitempty"`
+	Latest    string `json:"latest,omitempty"`
+	Stale     bool   `json:"stale"`
+}
+
+// BaseAmiUsageList is what base-ami list prints.
+type BaseAmiUsageList []BaseAmiUsage
+
+func (l BaseAmiUsageList) Header() []string {
+	return []string{"App", "ASG", "Launch", "Image", "Name", "Created", "Latest", "Stale"}
+}
+
+func (l BaseAmiUsageList) Rows() [][]string {
+	rows := make([][]string, 0)
+	for _, usage := range l {
+		rows = append(rows, []string{usage.App, usage.Group, usage.Launch, usage.ImageId, usage.ImageName, usage.Created, usage.Latest, fmt.Sprintf("%t", usage.Stale)})
+	}
+	return rows
+}
+
+func (c *BaseAmiListCommand) Run(args []string) int {
+	cmdFlags := flag.NewFlagSet("base-ami list", flag.ContinueOnError)
+	cmdFlags.Usage = func() { c.Ui.Output(c.Help()) }
+	appName := cmdFlags.String("app", "", "app to list")
+	envName := envFlag(cmdFlags, "prod")
+	output := addOutputFlags(cmdFlags)
+	if err := cmdFlags.Parse(args); err != nil {
+		c.Ui.Error(fmt.Sprintf("%s", err))
+		return 1
+	}
+	if err := output.validate(); err != nil {
+		c.Ui.Error(err.Error())
+		return 1
+	}
+
+	env, err := c.Envs.Get(*envName)
+	if err != nil {
+		c.Ui.Error(err.Error())
+		return 1
+	}
+
+	groupApps := make(map[string]*core.SuripuApp)
+	groupNames := make([]*string, 0)
+	for idx := range c.Apps {
+		app := &c.Apps[idx]
+		if *appName != "" && app.Name != *appName {
+			continue
+		}
+		if app.UsesPacker {
+			continue
+		}
+		for _, groupName := range env.GroupNames(app) {
+			groupApps[*groupName] = app
+			groupNames = append(groupNames, groupName)
+		}
+	}
+	if len(groupNames) == 0 {
+		c.Ui.Error(fmt.Sprintf("No app using a base AMI matches %q", *appName))
+		return 1
+	}
+
+	asgResp, err := c.Aws.AutoScaling.DescribeAutoScalingGroups(&autoscaling.DescribeAutoScalingGroupsInput{
+		AutoScalingGroupNames: groupNames,
+	})
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("%s", err))
+		return 1
+	}
+
+	images := make(map[string]*ec2.Image)
+	latest := make(map[string]string)
+	usages := make(BaseAmiUsageList, 0)
+	for _, asg := range asgResp.AutoScalingGroups {
+		if len(asg.Instances) == 0 {
+			continue
+		}
+		app := groupApps[*asg.AutoScalingGroupName]
+		launchRef := core.GroupLaunchRef(asg)
+
+		imageId, err := c.launchImageId(launchRef)
+		if err != nil {
+			c.Ui.Error(fmt.Sprintf("%s", err))
+			return 1
+		}
+
+		usage := BaseAmiUsage{
+			App:     app.Name,
+			Group:   *asg.AutoScalingGroupName,
+			Launch:  launchRef,
+			ImageId: imageId,
+		}
+		if _, ok := images[imageId]; !ok {
+			images[imageId] = c.image(imageId)
+		}
+		if image := images[imageId]; image != nil {
+			usage.ImageName = aws.StringValue(image.Name)
+			usage.Created = aws.StringValue(image.CreationDate)
+		} else {
+			usage.ImageName = "(deregistered)"
+		}
+
+		settings := env.BaseAmiFor(app)
+		if _, ok := latest[settings.String()]; !ok {
+			base, err := core.ResolveBaseAmi(c.Aws.EC2, settings)
+			if err != nil && output.text() {
+				c.Ui.Warn(fmt.Sprintf("%s: %s", app.Name, err))
+			}
+			if base != nil {
+				latest[settings.String()] = *base.ImageId
+			} else {
+				latest[settings.String()] = ""
+			}
+		}
+		usage.Latest = latest[settings.String()]
+		usage.Stale = usage.Latest != "" && usage.Latest != usage.ImageId
+
+		usages = append(usages, usage)
+	}
+
+	err = output.render(c.Ui, usages, func() {
+		if len(usages) == 0 {
+			c.Ui.Warn(fmt.Sprintf("No running ASG found in %s.", env.Name))
+			return
+		}
+
+		stale := 0
+		c.Ui.Info(fmt.Sprintf("%-28s\t%-36s\t%-12s\t%-24s\t%s", "ASG:", "Launch:", "Image:", "Name:", "Latest:"))
+		for _, usage := range usages {
+			line := fmt.Sprintf("%-28s\t%-36s\t%-12s\t%-24s\t%s", usage.Group, usage.Launch, usage.ImageId, usage.ImageName, usage.Latest)
+			if usage.Stale {
+				stale++
+				c.Ui.Warn(line)
+			} else {
+				c.Ui.Output(line)
+			}
+		}
+		if stale > 0 {
+			c.Ui.Warn(fmt.Sprintf("%d ASG(s) run an older base AMI, deploy again to pick up the latest.", stale))
+		}
+	})
+	if err != nil {
+		c.Ui.Error(err.Error())
+		return 1
+	}
+	return 0
+}
+
+// launchImageId returns the AMI of a launch configuration or launch
+// template version.
+func (c *BaseAmiListCommand) launchImageId(launchRef string) (string, error) {
+	if name, version, ok := core.ParseLaunchTemplateRef(launchRef); ok {
+		resp, err := c.Aws.EC2.DescribeLaunchTemplateVersions(&ec2.DescribeLaunchTemplateVersionsInput{
+			LaunchTemplateName: aws.String(name),
+			Versions:           []*string{aws.String(version)},
+		})
+		if err != nil {
+			return "", err
+		}
+		if len(resp.LaunchTemplateVersions) == 0 || resp.LaunchTemplateVersions[0].LaunchTemplateData == nil {
+			return "", errors.New(fmt.Sprintf("Launch template version not found: %s", launchRef))
+		}
+		return aws.StringValue(resp.LaunchTemplateVersions[0].LaunchTemplateData.ImageId), nil
+	}
+
+	resp, err := c.Aws.AutoScaling.DescribeLaunchConfigurations(&autoscaling.DescribeLaunchConfigurationsInput{
+		LaunchConfigurationNames: []*string{aws.String(launchRef)},
+	})
+	if err != nil {
+		return "", err
+	}
+	if len(resp.LaunchConfigurations) == 0 {
+		return "", errors.New(fmt.Sprintf("Launch configuration not found: %s", launchRef))
+	}
+	return aws.StringValue(resp.LaunchConfigurations[0].ImageId), nil
+}
+
+// image describes imageId, nil once it has been deregistered.
+func (c *BaseAmiListCommand) image(imageId string) *ec2.Image {
+	resp, err := c.Aws.EC2.DescribeImages(&ec2.DescribeImagesInput{
+		ImageIds: []*string{aws.String(imageId)},
+	})
+	if err != nil || len(resp.Images) == 0 {
+		return nil
+	}
+	return resp.Images[0]
+}
+
+func (c *BaseAmiListCommand) Synopsis() string {
+	return "Lists the base AMI each running ASG uses"
+}
@@ -0,0 +1,294 @@
+package command
+
+import (
+	"flag"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/hello/sanders/core"
+	"github.com/mitchellh/cli"
+	"strings"
+	"time"
+)
+
+type CanaryCommand struct {
+	Ui          cli.ColoredUi
+	Aws         *core.Clients
+	Notifier    BasicNotifier
+	AmiSelector core.AmiSelector
+	KeyService  core.KeyService
+	Apps        []core.SuripuApp
+	Envs        core.Environments
+	Lock        *Locking
+}
+
+func (c *CanaryCommand) Help() string {
+	helpText := `Usage: sanders canary [-env canary] [-app name] [-version version] [-base-ami ami] [-yes] [-timeout 15m]
+
+	Kills the instance behind the <app>-canary ELB and replaces it with a
+	new one running the selected version. This is NOT HA. Apps using launch
+	templates get a new template version instead of a launch configuration.
+
+	-env		Environment to replace instances in (default canary).
+	-app		App to deploy. Prompts if omitted.
+	-version	Package version to use, or a pattern like 8.8.* to pick from.
+			Prompts if omitted.
+	-base-ami	Base AMI id, or name pattern to take the latest of, instead
+			of the one of the app and environment.
+	-yes		Don't ask for confirmation.
+	-timeout	How long to wait for the new instance to be InService
+			(default 15m).
+	` + planFlagsHelp
+	return strings.TrimSpace(helpText)
+}
+
+func (c *CanaryCommand) Run(args []string) int {
+	cmdFlags := flag.NewFlagSet("canary", flag.ContinueOnError)
+	cmdFlags.Usage = func() { c.Ui.Output(c.Help()) }
+	envName := envFlag(cmdFlags, "canary")
+	appName := cmdFlags.String("app", "", "app to deploy to canary")
+	version := cmdFlags.String("version", "", "package version")
+	baseAmi := cmdFlags.String("base-ami", "", "base AMI id or name pattern")
+	yes := cmdFlags.Bool("yes", false, "don't ask for confirmation")
+	timeout := cmdFlags.Duration("timeout", 15*time.Minute, "time to wait for the new instance to be InService")
+	planFlags := addPlanFlags(cmdFlags)
+	if err := cmdFlags.Parse(args); err != nil {
+		c.Ui.Error(fmt.Sprintf("%s", err))
+		return 1
+	}
+
+	env, err := c.Envs.Get(*envName)
+	if err != nil {
+		c.Ui.Error(err.Error())
+		return 1
+	}
+
+	service := c.Aws.AutoScaling
+	lbs := c.Aws.LoadBalancers()
+
+	appSelector := core.NewAppSelector(c.Ui, *appName)
+	selectedApp, err := appSelector.Choose(c.Apps)
+	if err != nil {
+		c.Ui.Error(err.Error())
+		return 1
+	}
+
+	elbName := env.ElbName(selectedApp)
+
+	health, err := lbs.Health(elbName)
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("%s", err))
+		return 1
+	}
+
+	oldInstances := make([]*string, 0)
+	for _, state := range health {
+		oldInstances = append(oldInstances, aws.String(state.InstanceId))
+	}
+
+	asgName := *env.GroupNames(selectedApp)[0]
+	if len(oldInstances) > 0 {
+		asgInstancesResp, err := service.DescribeAutoScalingInstances(&autoscaling.DescribeAutoScalingInstancesInput{
+			InstanceIds: oldInstances,
+		})
+		if err != nil {
+			c.Ui.Error(fmt.Sprintf("%s", err))
+			return 1
+		}
+		if len(asgInstancesResp.AutoScalingInstances) > 0 {
+			asgName = *asgInstancesResp.AutoScalingInstances[0].AutoScalingGroupName
+		}
+	} else {
+		c.Ui.Warn(fmt.Sprintf("No instance currently behind ELB %s", elbName))
+	}
+
+	selectedAmi, err := c.AmiSelector.Select(*selectedApp, env, *version, *baseAmi)
+	if err != nil {
+		c.Ui.Error(err.Error())
+		return 1
+	}
+
+	c.Ui.Info(fmt.Sprintf("You selected %s\n", selectedAmi.Name))
+	c.Ui.Info(fmt.Sprintf("Version Number: %s\n", selectedAmi.Version))
+
+	launchConfigName := env.LaunchConfigName(selectedApp, selectedAmi.Version)
+	keyName := fmt.Sprintf("%s-%d", launchConfigName, time.Now().Unix())
+
+	var template *templateVersion
+	var createLCParams *autoscaling.CreateLaunchConfigurationInput
+	if selectedApp.LaunchTemplate {
+		template, err = newTemplateVersion(c.Aws.EC2, env, selectedApp, selectedAmi, selectedAmi.Version, keyName)
+		if err != nil {
+			c.Ui.Error(err.Error())
+			return 1
+		}
+		launchConfigName = template.ref()
+	} else {
+		createLCParams = &autoscaling.CreateLaunchConfigurationInput{
+			LaunchConfigurationName:  aws.String(launchConfigName),
+			AssociatePublicIpAddress: aws.Bool(true),
+			IamInstanceProfile:       aws.String(selectedApp.InstanceProfile),
+			ImageId:                  aws.String(selectedAmi.Id),
+			InstanceMonitoring: &autoscaling.InstanceMonitoring{
+				Enabled: aws.Bool(true),
+			},
+			InstanceType: aws.String(selectedApp.InstanceType),
+			KeyName:      aws.String(keyName),
+			SecurityGroups: []*string{
+				aws.String(selectedApp.SecurityGroup),
+			},
+			UserData: aws.String(selectedAmi.UserData),
+		}
+	}
+
+	asg, err := describeGroup(service, asgName)
+	if err != nil {
+		c.Ui.Error(err.Error())
+		return 1
+	}
+
+	desiredCapacity := env.DesiredCapacity(selectedApp)
+
+	plan := core.NewPlan("canary", selectedApp.Name, env.Name)
+	plan.Add(core.Change{
+		Resource: "key_pair",
+		Name:     keyName,
+		Action:   core.ActionCreate,
+	})
+	if template != nil {
+		template.addTo(plan)
+	} else {
+		plan.Add(withBaseAmi(launchConfigurationChange(createLCParams), selectedAmi))
+	}
+	plan.Add(scaleChange(asg, launchConfigName, desiredCapacity))
+	for _, instanceId := range oldInstances {
+		plan.Add(core.Change{
+			Resource: "instance",
+			Name:     *instanceId,
+			Action:   core.ActionTerminate,
+			Before:   map[string]string{"elb": elbName},
+		})
+	}
+	stop, err := planFlags.show(c.Ui, plan)
+	if err != nil {
+		c.Ui.Error(err.Error())
+		return 1
+	}
+	if stop {
+		return 0
+	}
+
+	unlock, err := c.Lock.acquire(c.Ui, selectedApp, env, "canary", *timeout)
+	if err != nil {
+		c.Ui.Error(err.Error())
+		return 1
+	}
+	defer unlock()
+	c.Ui.Warn("There will be downtime between killing the old instance and the new one being InService.")
+
+	ok, err := approve(c.Ui, env, *yes, "'ok' if you agree, anything else to cancel: ")
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("%s", err))
+		return 1
+	}
+
+	if !ok {
+		c.Ui.Warn("Cancelled.")
+		return 0
+	}
+
+	deployAction := NewAsgAction("canary", selectedApp, env, asgName, launchConfigName, int64(len(oldInstances)), desiredCapacity)
+	deployAction.PreviousLC = core.GroupLaunchRef(asg)
+	c.Notifier.Notify(deployAction)
+
+	keyUploadResults, err := c.KeyService.Upload(keyName, *selectedApp, env.Name)
+	if err != nil {
+		c.Notifier.Notify(deployAction.Failed(err))
+		c.Ui.Error(err.Error())
+		return 1
+	}
+
+	c.Ui.Info(fmt.Sprintf("Created KeyPair: %s. \n", keyUploadResults.KeyName))
+
+	if template != nil {
+		launchConfigName, err = template.create(c.Aws.EC2)
+		if err != nil {
+			c.Notifier.Notify(deployAction.Failed(err))
+			c.Ui.Error(fmt.Sprintf("Failed to create version of launch template: %s", template.name))
+			c.Ui.Error(err.Error())
+			c.Cleanup(keyUploadResults)
+			return 1
+		}
+		deployAction.LC = launchConfigName
+		c.Ui.Info(fmt.Sprintf("Launch template version %s created.", launchConfigName))
+	} else {
+		_, err = service.CreateLaunchConfiguration(createLCParams)
+		if err != nil {
+			c.Notifier.Notify(deployAction.Failed(err))
+			c.Ui.Error(fmt.Sprintf("Failed to create Launch Configuration: %s", launchConfigName))
+			c.Ui.Error(fmt.Sprintln(err.Error()))
+			c.Cleanup(keyUploadResults)
+			return 1
+		}
+		c.Ui.Info(fmt.Sprintf("Launch Configuration %s created.", launchConfigName))
+	}
+
+	if err := scaleASG(service, asgName, launchConfigName, desiredCapacity); err != nil {
+		c.Notifier.Notify(deployAction.Failed(err))
+		c.Ui.Error(fmt.Sprintf("%s", err))
+		return 1
+	}
+
+	if _, err := updateASGTags(service, deployTags(asgName, selectedApp, env, launchConfigName, packageChecksum(c.Ui, c.Aws, selectedApp, env, launchConfigName))); err != nil {
+		c.Notifier.Notify(deployAction.Failed(err))
+		c.Ui.Error(fmt.Sprintf("%s", err))
+		return 1
+	}
+
+	for _, instanceId := range oldInstances {
+		_, err := service.TerminateInstanceInAutoScalingGroup(&autoscaling.TerminateInstanceInAutoScalingGroupInput{
+			InstanceId:                     instanceId,
+			ShouldDecrementDesiredCapacity: aws.Bool(false),
+		})
+		if err != nil {
+			c.Notifier.Notify(deployAction.Failed(err))
+			c.Ui.Error(fmt.Sprintf("%s", err))
+			return 1
+		}
+		c.Ui.Info(fmt.Sprintf("Terminating instance %s", *instanceId))
+	}
+
+	waitElbName, err := healthElbName(service, selectedApp, env)
+	if err != nil {
+		c.Notifier.Notify(deployAction.Failed(err))
+		c.Ui.Error(err.Error())
+		return 1
+	}
+	c.Ui.Info(fmt.Sprintf("Waiting for %d instances of %s to be InService", desiredCapacity, asgName))
+	if err := waitAndNotify(c.Ui, c.Notifier, deployAction, service, lbs, asgName, waitElbName, launchConfigName, desiredCapacity, *timeout); err != nil {
+		c.Ui.Error(err.Error())
+		return 1
+	}
+	c.Ui.Info(fmt.Sprintf("Canary %s is InService", launchConfigName))
+	return 0
+}
+
+func (c *CanaryCommand) Cleanup(uploadRes *core.KeyUploadResult) bool {
+
+	c.Ui.Info("")
+	c.Ui.Info(fmt.Sprintf("Cleaning up created KeyPair: %s", uploadRes.KeyName))
+
+	err := c.KeyService.CleanUp(uploadRes)
+	if err != nil {
+		c.Ui.Error(err.Error())
+		return false
+	}
+
+	c.Ui.Info(fmt.Sprintf("Successfully deleted S3 object: %s", uploadRes.Key))
+
+	return true
+}
+
+func (c *CanaryCommand) Synopsis() string {
+	return "Replaces the canary instance with a new version (NOT HA)."
+}
@@ -1,10 +1,12 @@
 package command
 
 import (
+	"flag"
 	"fmt"
 	"github.com/hello/sanders/core"
 	"github.com/mitchellh/cli"
 	"strings"
+	"time"
 )
 
 type CancelCommand struct {
@@ -14,32 +16,123 @@ type CancelCommand struct {
 	KeyService   core.KeyService
 	Apps         []core.SuripuApp
 	FleetManager *core.FleetManager
+	Lock         *Locking
 }
 
 func (c *CancelCommand) Help() string {
-	helpText := `Usage: sanders cancel-spot`
+	helpText := `Usage: sanders cancel-spot [-request id] [-list] [-format text] [-dry-run]
+	-request	Spot fleet request to cancel. Lists them and prompts if omitted.
+	-list		List the spot fleet requests and exit.
+	` + outputFlagsHelp + ` With json, the plan is
+			printed as JSON.
+	` + dryRunFlagHelp
 	return strings.TrimSpace(helpText)
 }
 
+// SpotFleetList is what cancel-spot lists, one row per instance in tables.
+type SpotFleetList []core.SpotFleet
+
+func (l SpotFleetList) Header() []string {
+	return []string{"Request", "Status", "Capacity", "Created", "Instance", "Price", "AZ", "Key"}
+}
+
+func (l SpotFleetList) Rows() [][]string {
+	rows := make([][]string, 0)
+	for _, fleet := range l {
+		capacity := fmt.Sprintf("%0.f/%d", fleet.FulfilledCapacity, fleet.TargetCapacity)
+		created := fleet.Created.Local().Format(time.RFC822)
+		if len(fleet.Instances) == 0 {
+			rows = append(rows, []string{fleet.RequestId, fleet.Status, capacity, created})
+			continue
+		}
+		for _, instance := range fleet.Instances {
+			rows = append(rows, []string{fleet.RequestId, fleet.Status, capacity, created, instance.InstanceId, instance.Price, instance.Az, instance.KeyName})
+		}
+	}
+	return rows
+}
+
 func (c *CancelCommand) Run(args []string) int {
-	err := c.FleetManager.Describe()
+	cmdFlags := flag.NewFlagSet("cancel-spot", flag.ContinueOnError)
+	cmdFlags.Usage = func() { c.Ui.Output(c.Help()) }
+	requestFlag := cmdFlags.String("request", "", "spot fleet request id to cancel")
+	list := cmdFlags.Bool("list", false, "list spot fleet requests and exit")
+	output := addOutputFlags(cmdFlags)
+	planFlags := addDryRunFlag(cmdFlags, output)
+	if err := cmdFlags.Parse(args); err != nil {
+		c.Ui.Error(fmt.Sprintf("%s", err))
+		return 1
+	}
+	if err := output.validate(); err != nil {
+		c.Ui.Error(err.Error())
+		return 1
+	}
+
+	if *list {
+		fleets, err := c.FleetManager.List()
+		if err != nil {
+			c.Ui.Error(fmt.Sprintf("Failed to describe Spot Fleet request: %s", err))
+			return 1
+		}
+		err = output.render(c.Ui, SpotFleetList(fleets), func() {
+			c.FleetManager.Print(fleets)
+		})
+		if err != nil {
+			c.Ui.Error(err.Error())
+			return 1
+		}
+		return 0
+	}
+
+	requestId := *requestFlag
+	if requestId == "" {
+		err := c.FleetManager.Describe()
+		if err != nil {
+			// Message from an error.
+			c.Ui.Error(fmt.Sprintf("Failed to describe Spot Fleet request: %s", err))
+			return 1
+		}
+
+		answer, err := c.Ui.Ask("Which spot request?")
+		if err != nil {
+			c.Ui.Error(err.Error())
+			return 1
+		}
+		requestId = answer
+	}
+
+	if strings.TrimSpace(requestId) == "" {
+		c.Ui.Error("Empty requestId")
+		return 1
+	}
+
+	plan := core.NewPlan("cancel-spot", "", "")
+	plan.Add(core.Change{
+		Resource: "spot_fleet_request",
+		Name:     requestId,
+		Action:   core.ActionDelete,
+		Before:   map[string]string{"terminate_instances": "true"},
+	})
+	stop, err := planFlags.show(c.Ui, plan)
 	if err != nil {
-		// Message from an error.
-		c.Ui.Error(fmt.Sprintf("Failed to describe Spot Fleet request: %s", err))
+		c.Ui.Error(err.Error())
 		return 1
 	}
+	if stop {
+		return 0
+	}
 
-	requestId, err := c.Ui.Ask("Which spot request?")
+	unlock, err := c.Lock.acquireNamed(c.Ui, "spot-fleet", requestId, "cancel-spot", 0)
 	if err != nil {
 		c.Ui.Error(err.Error())
 		return 1
 	}
+	defer unlock()
 
-	if strings.TrimSpace(requestId) == "" {
-		c.Ui.Error("Empty requestId")
+	if err := c.FleetManager.Cancel(requestId); err != nil {
+		c.Ui.Error(fmt.Sprintf("Failed to cancel Spot Fleet request %s: %s", requestId, err))
 		return 1
 	}
-	c.FleetManager.Cancel(requestId)
 	c.Ui.Output(fmt.Sprintf("Spot Fleet request %s was successfully cancelled", requestId))
 
 	return 0
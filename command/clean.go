@@ -1,102 +1,462 @@
 package command
 
 import (
+	"errors"
+	"flag"
 	"fmt"
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/hello/sanders/core"
 	"github.com/mitchellh/cli"
 	"sort"
 	"strings"
+	"time"
 )
 
 type CleanCommand struct {
-	Ui       cli.ColoredUi
-	Notifier BasicNotifier
-	Apps     []core.SuripuApp
+	Ui         cli.ColoredUi
+	Aws        *core.Clients
+	KeyService core.KeyService
+	Notifier   BasicNotifier
+	Apps       []core.SuripuApp
+	Envs       core.Environments
+	Lock       *Locking
 }
 
 func (c *CleanCommand) Help() string {
-	helpText := `Usage: clean`
+	helpText := `Usage: sanders clean [-app name] [-env name] [-keep 5] [-older-than 720h] [-yes]
+
+	Deletes the launch configurations and launch template versions of every
+	app that no ASG uses, except the latest ones of each app in each
+	environment and the default version of each template. The key pairs
+	created with them, and their private keys on S3, are deleted too.
+
+	-app		Only clean this app.
+	-env		Only clean this environment.
+	-keep		How many of the latest launch configurations and template versions to keep per app and environment (default 5).
+	-older-than	Only delete what was created longer ago than this, e.g. 720h.
+	-yes		Don't ask for confirmation.
+	` + planFlagsHelp
 	return strings.TrimSpace(helpText)
 }
 
-func (c *CleanCommand) Run(args []string) int {
+// cleanPolicy decides what clean deletes.
+type cleanPolicy struct {
+	app       string
+	env       string
+	keep      int
+	olderThan time.Duration
+	inUse     map[string]bool
+}
 
-	config := &aws.Config{
-		Region: aws.String("us-east-1"),
+// covers is true when app in env is being cleaned.
+func (p *cleanPolicy) covers(app *core.SuripuApp, env *core.Environment) bool {
+	return (p.app == "" || p.app == app.Name) && (p.env == "" || p.env == env.Name)
+}
+
+// expired is true when something created at created, the idx-th latest of
+// its app and environment, can be deleted.
+func (p *cleanPolicy) expired(idx int, ref string, created time.Time) bool {
+	if idx < p.keep || p.inUse[ref] {
+		return false
 	}
+	return p.olderThan == 0 || time.Since(created) > p.olderThan
+}
 
-	asgService := autoscaling.New(session.New(), config)
+// oldLC is a launch configuration clean deletes.
+type oldLC struct {
+	app *core.SuripuApp
+	env *core.Environment
+	lc  *autoscaling.LaunchConfiguration
+}
 
-	lcParams := &autoscaling.DescribeLaunchConfigurationsInput{
-		MaxRecords: aws.Int64(100),
-	}
+// oldTemplates are the versions of a launch template clean deletes.
+type oldTemplates struct {
+	app      *core.SuripuApp
+	env      *core.Environment
+	template string
+	versions []*ec2.LaunchTemplateVersion
+}
 
-	pageNum := 0
-	allLcs := make([]*autoscaling.LaunchConfiguration, 0)
+// cleanRun is the notification and failures of cleaning an app in an
+// environment.
+type cleanRun struct {
+	action   *DeployAction
+	failures []string
+}
 
-	pageErr := asgService.DescribeLaunchConfigurationsPages(lcParams, func(page *autoscaling.DescribeLaunchConfigurationsOutput, lastPage bool) bool {
-		pageNum++
-		if len(page.LaunchConfigurations) == 0 {
-			return false
-		}
+func (c *CleanCommand) Run(args []string) int {
 
-		for _, lc := range page.LaunchConfigurations {
-			for _, app := range c.Apps {
-				if strings.HasPrefix(*lc.LaunchConfigurationName, app.Name) {
-					allLcs = append(allLcs, lc)
-				}
-			}
+	cmdFlags := flag.NewFlagSet("clean", flag.ContinueOnError)
+	cmdFlags.Usage = func() { c.Ui.Output(c.Help()) }
+	appName := cmdFlags.String("app", "", "app to clean")
+	envName := cmdFlags.String("env", "", "environment to clean")
+	keep := cmdFlags.Int("keep", 5, "latest launch configurations to keep per app and environment")
+	olderThan := cmdFlags.Duration("older-than", 0, "only delete what is older than this")
+	yes := cmdFlags.Bool("yes", false, "don't ask for confirmation")
+	planFlags := addPlanFlags(cmdFlags)
+	if err := cmdFlags.Parse(args); err != nil {
+		c.Ui.Error(fmt.Sprintf("%s", err))
+		return 1
+	}
 
+	if *keep < 0 {
+		c.Ui.Error("-keep can't be negative")
+		return 1
+	}
+	if *envName != "" {
+		if _, err := c.Envs.Get(*envName); err != nil {
+			c.Ui.Error(err.Error())
+			return 1
 		}
-		return pageNum <= 1 //Allow for page * max records
-	})
+	}
+	if *appName != "" {
+		if _, err := core.NewAppSelector(c.Ui, *appName).Choose(c.Apps); err != nil {
+			c.Ui.Error(err.Error())
+			return 1
+		}
+	}
 
-	if pageErr != nil {
-		c.Ui.Error(fmt.Sprintf("%s", pageErr))
+	inUse, err := c.launchRefsInUse()
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("%s", err))
 		return 1
 	}
-	sort.Sort(core.ByLCTime(allLcs))
+	policy := &cleanPolicy{
+		app:       *appName,
+		env:       *envName,
+		keep:      *keep,
+		olderThan: *olderThan,
+		inUse:     inUse,
+	}
 
-	lcToDelete := make([]*string, 0)
-	for i, lcName := range allLcs {
-		if i < 50 {
-			c.Ui.Warn(*lcName.LaunchConfigurationName)
-			lcToDelete = append(lcToDelete, lcName.LaunchConfigurationName)
+	oldLCs, err := c.oldLaunchConfigurations(policy)
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("%s", err))
+		return 1
+	}
+	oldVersions, err := c.oldTemplateVersions(policy)
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("%s", err))
+		return 1
+	}
+
+	plan := core.NewPlan("clean", *appName, *envName)
+	for _, old := range oldLCs {
+		plan.Add(core.Change{
+			Resource: "launch_configuration",
+			Name:     *old.lc.LaunchConfigurationName,
+			Action:   core.ActionDelete,
+			Before: map[string]string{
+				"image_id":     aws.StringValue(old.lc.ImageId),
+				"created_time": aws.TimeValue(old.lc.CreatedTime).String(),
+			},
+		})
+		c.addKeyChanges(plan, old.app, old.env, aws.StringValue(old.lc.KeyName))
+	}
+	for _, old := range oldVersions {
+		for _, version := range old.versions {
+			plan.Add(core.Change{
+				Resource: "launch_template_version",
+				Name:     core.LaunchTemplateRef(old.template, *version.VersionNumber),
+				Action:   core.ActionDelete,
+				Before: map[string]string{
+					"version_description": aws.StringValue(version.VersionDescription),
+					"created_time":        aws.TimeValue(version.CreateTime).String(),
+				},
+			})
+			c.addKeyChanges(plan, old.app, old.env, templateKeyName(version))
 		}
 	}
 
-	ok, err := c.Ui.Ask("Each above LCs will be deleted. Type ok to confirm.")
+	stop, err := planFlags.show(c.Ui, plan)
+	if err != nil {
+		c.Ui.Error(err.Error())
+		return 1
+	}
+	if stop {
+		return 0
+	}
+	if len(plan.Changes) == 0 {
+		c.Ui.Info("Nothing to clean.")
+		return 0
+	}
+
+	ok, err := askOk(c.Ui, *yes, "Each above LCs, launch template versions and key pairs will be deleted. Type ok to confirm.")
 	if err != nil {
 		c.Ui.Error(fmt.Sprintf("%v", err))
 		return 1
 	}
-	if ok != "ok" {
+	if !ok {
 		c.Ui.Error("Didn't get ok. Bailing.")
 		return 1
 	}
 
-	for _, lcName := range lcToDelete {
-		params := &autoscaling.DeleteLaunchConfigurationInput{
-			LaunchConfigurationName: lcName,
+	// Lock every app and environment being cleaned, then look again at what
+	// the ASGs use: a deploy may have picked an old LC in the meantime.
+	unlocks := make([]func(), 0)
+	defer func() {
+		for _, unlock := range unlocks {
+			unlock()
+		}
+	}()
+	locked := make(map[string]bool)
+	lock := func(app *core.SuripuApp, env *core.Environment) error {
+		id := app.Name + "/" + env.Name
+		if locked[id] {
+			return nil
+		}
+		unlock, err := c.Lock.acquire(c.Ui, app, env, "clean", 0)
+		if err != nil {
+			return err
+		}
+		locked[id] = true
+		unlocks = append(unlocks, unlock)
+		return nil
+	}
+	for _, old := range oldLCs {
+		if err := lock(old.app, old.env); err != nil {
+			c.Ui.Error(err.Error())
+			return 1
+		}
+	}
+	for _, old := range oldVersions {
+		if err := lock(old.app, old.env); err != nil {
+			c.Ui.Error(err.Error())
+			return 1
+		}
+	}
+	inUse, err = c.launchRefsInUse()
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("%s", err))
+		return 1
+	}
+
+	// One action per app and environment so the history keeps what was
+	// cleaned where.
+	runs := make(map[string]*cleanRun)
+	runOf := func(app *core.SuripuApp, env *core.Environment) *cleanRun {
+		id := app.Name + "/" + env.Name
+		if _, found := runs[id]; !found {
+			action := NewDeployAction("clean", app.Name, "", 0)
+			action.Env = env.Name
+			runs[id] = &cleanRun{action: action}
+			c.Notifier.Notify(action)
+		}
+		return runs[id]
+	}
+
+	for _, old := range oldLCs {
+		run := runOf(old.app, old.env)
+		lcName := *old.lc.LaunchConfigurationName
+		if inUse[lcName] {
+			c.Ui.Warn(fmt.Sprintf("%s is in use now, skipped", lcName))
+			continue
 		}
-		_, err := asgService.DeleteLaunchConfiguration(params)
+		_, err := c.Aws.AutoScaling.DeleteLaunchConfiguration(&autoscaling.DeleteLaunchConfigurationInput{
+			LaunchConfigurationName: aws.String(lcName),
+		})
+		if err != nil {
+			c.Ui.Error(err.Error())
+			run.failures = append(run.failures, err.Error())
+			continue
+		}
+		c.Ui.Info(fmt.Sprintf("%s deleted", lcName))
+		run.failures = append(run.failures, c.deleteKey(old.app, old.env, aws.StringValue(old.lc.KeyName))...)
+	}
 
+	for _, old := range oldVersions {
+		run := runOf(old.app, old.env)
+
+		numbers := make([]*string, 0)
+		for _, version := range old.versions {
+			if ref := core.LaunchTemplateRef(old.template, *version.VersionNumber); inUse[ref] {
+				c.Ui.Warn(fmt.Sprintf("%s is in use now, skipped", ref))
+				continue
+			}
+			numbers = append(numbers, aws.String(fmt.Sprintf("%d", *version.VersionNumber)))
+		}
+		if len(numbers) == 0 {
+			continue
+		}
+		resp, err := c.Aws.EC2.DeleteLaunchTemplateVersions(&ec2.DeleteLaunchTemplateVersionsInput{
+			LaunchTemplateName: aws.String(old.template),
+			Versions:           numbers,
+		})
 		if err != nil {
-			// Print the error, cast err to awserr.Error to get the Code and
-			// Message from an error.
 			c.Ui.Error(err.Error())
+			run.failures = append(run.failures, err.Error())
+			continue
+		}
+		for _, deleted := range resp.SuccessfullyDeletedLaunchTemplateVersions {
+			c.Ui.Info(fmt.Sprintf("%s deleted", core.LaunchTemplateRef(old.template, *deleted.VersionNumber)))
+			for _, version := range old.versions {
+				if *version.VersionNumber == *deleted.VersionNumber {
+					run.failures = append(run.failures, c.deleteKey(old.app, old.env, templateKeyName(version))...)
+				}
+			}
+		}
+		for _, failed := range resp.UnsuccessfullyDeletedLaunchTemplateVersions {
+			message := fmt.Sprintf("%s: %s", core.LaunchTemplateRef(old.template, *failed.VersionNumber), aws.StringValue(failed.ResponseError.Message))
+			c.Ui.Error(message)
+			run.failures = append(run.failures, message)
+		}
+	}
 
+	failed := false
+	for _, run := range runs {
+		if len(run.failures) > 0 {
+			failed = true
+			c.Notifier.Notify(run.action.Failed(errors.New(strings.Join(run.failures, "; "))))
 		} else {
-			c.Ui.Info(fmt.Sprintf("%s deleted", *lcName))
+			c.Notifier.Notify(run.action.Succeeded())
 		}
 	}
-
+	if failed {
+		return 1
+	}
 	return 0
 }
 
+// launchRefsInUse returns the launch configurations and template versions
+// of every ASG.
+func (c *CleanCommand) launchRefsInUse() (map[string]bool, error) {
+	inUse := make(map[string]bool)
+	err := c.Aws.AutoScaling.DescribeAutoScalingGroupsPages(&autoscaling.DescribeAutoScalingGroupsInput{}, func(page *autoscaling.DescribeAutoScalingGroupsOutput, lastPage bool) bool {
+		for _, asg := range page.AutoScalingGroups {
+			inUse[core.GroupLaunchRef(asg)] = true
+		}
+		return true
+	})
+	return inUse, err
+}
+
+// oldLaunchConfigurations lists the launch configurations the policy lets
+// clean delete. Launch configurations that don't parse as
+// <app>-<env>-<version> for a known app and environment are left alone.
+func (c *CleanCommand) oldLaunchConfigurations(policy *cleanPolicy) ([]*oldLC, error) {
+	owners := make(map[string]*oldLC)
+	owned := make(map[string][]*autoscaling.LaunchConfiguration)
+	err := c.Aws.AutoScaling.DescribeLaunchConfigurationsPages(&autoscaling.DescribeLaunchConfigurationsInput{
+		MaxRecords: aws.Int64(100),
+	}, func(page *autoscaling.DescribeLaunchConfigurationsOutput, lastPage bool) bool {
+		for _, lc := range page.LaunchConfigurations {
+			app, env, ok := c.Envs.LCOwner(c.Apps, *lc.LaunchConfigurationName)
+			if !ok || !policy.covers(app, env) {
+				continue
+			}
+			id := app.Name + "/" + env.Name
+			owners[id] = &oldLC{app: app, env: env}
+			owned[id] = append(owned[id], lc)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(owned))
+	for id := range owned {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	olds := make([]*oldLC, 0)
+	for _, id := range ids {
+		lcs := owned[id]
+		sort.Sort(sort.Reverse(core.ByLCTime(lcs)))
+		for idx, lc := range lcs {
+			if policy.expired(idx, *lc.LaunchConfigurationName, aws.TimeValue(lc.CreatedTime)) {
+				olds = append(olds, &oldLC{app: owners[id].app, env: owners[id].env, lc: lc})
+			}
+		}
+	}
+	return olds, nil
+}
+
+// oldTemplateVersions lists the versions of the launch template of every app
+// in every environment the policy lets clean delete. The default version is
+// always kept.
+func (c *CleanCommand) oldTemplateVersions(policy *cleanPolicy) ([]*oldTemplates, error) {
+	olds := make([]*oldTemplates, 0)
+	for envIdx := range c.Envs {
+		env := &c.Envs[envIdx]
+		for appIdx := range c.Apps {
+			app := &c.Apps[appIdx]
+			if !policy.covers(app, env) {
+				continue
+			}
+			name := env.LaunchTemplateName(app)
+			versions, err := core.LaunchTemplateVersions(c.Aws.EC2, name)
+			if err != nil {
+				return nil, err
+			}
+			sort.Sort(sort.Reverse(core.ByTemplateVersion(versions)))
+
+			old := &oldTemplates{app: app, env: env, template: name, versions: make([]*ec2.LaunchTemplateVersion, 0)}
+			for idx, version := range versions {
+				if aws.BoolValue(version.DefaultVersion) {
+					continue
+				}
+				if policy.expired(idx, core.LaunchTemplateRef(name, *version.VersionNumber), aws.TimeValue(version.CreateTime)) {
+					old.versions = append(old.versions, version)
+				}
+			}
+			if len(old.versions) > 0 {
+				olds = append(olds, old)
+			}
+		}
+	}
+	return olds, nil
+}
+
+// createdKey is true for key pairs create made for app in env, named after
+// the launch configuration. The key_name shared by every instance of an app
+// is never one of them.
+func createdKey(app *core.SuripuApp, env *core.Environment, keyName string) bool {
+	return keyName != "" && keyName != app.KeyName && strings.HasPrefix(keyName, env.LaunchConfigName(app, ""))
+}
+
+func templateKeyName(version *ec2.LaunchTemplateVersion) string {
+	if version.LaunchTemplateData == nil {
+		return ""
+	}
+	return aws.StringValue(version.LaunchTemplateData.KeyName)
+}
+
+// addKeyChanges adds the deletion of keyName and its private key to plan
+// when create made them.
+func (c *CleanCommand) addKeyChanges(plan *core.Plan, app *core.SuripuApp, env *core.Environment, keyName string) {
+	if !createdKey(app, env, keyName) {
+		return
+	}
+	plan.Add(core.Change{
+		Resource: "key_pair",
+		Name:     keyName,
+		Action:   core.ActionDelete,
+	})
+	plan.Add(core.Change{
+		Resource: "s3_object",
+		Name:     core.KeyObjectPath(env.Name, app.Name, keyName),
+		Action:   core.ActionDelete,
+	})
+}
+
+// deleteKey deletes keyName and its private key when create made them, and
+// returns what failed.
+func (c *CleanCommand) deleteKey(app *core.SuripuApp, env *core.Environment, keyName string) []string {
+	if !createdKey(app, env, keyName) {
+		return nil
+	}
+	if err := c.KeyService.Delete(keyName, *app, env.Name); err != nil {
+		c.Ui.Error(err.Error())
+		return []string{err.Error()}
+	}
+	c.Ui.Info(fmt.Sprintf("Key pair %s deleted", keyName))
+	return nil
+}
+
 func (c *CleanCommand) Synopsis() string {
-	return "Deletes (10) oldest launch configurations that are not attached to an ASG."
+	return "Deletes old launch configurations, template versions and key pairs not attached to an ASG."
 }
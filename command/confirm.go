@@ -1,107 +1,82 @@
 package command
 
 import (
+	"errors"
+	"flag"
 	"fmt"
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/autoscaling/autoscalingiface"
 	"github.com/hello/sanders/core"
 	"github.com/mitchellh/cli"
-	"strconv"
+	"sort"
 	"strings"
+	"time"
 )
 
 type ConfirmCommand struct {
 	Ui       cli.ColoredUi
+	Aws      *core.Clients
 	Notifier BasicNotifier
 	Apps     []core.SuripuApp
+	Envs     core.Environments
+	Lock     *Locking
 }
 
 func (c *ConfirmCommand) Help() string {
-	helpText := `Usage: hello up`
+	helpText := `Usage: sanders confirm [-env prod] [-version version] [-lc name] [-yes] [-wait] [-auto-rollback] [-timeout 15m]
+	-env		Environment to confirm in (default prod).
+	-version	Version to confirm (ex 8.8.8). Prompts if omitted.
+	-lc		Launch configuration, or launch template version (name:version),
+			to confirm. Skips the version and LC prompts.
+	-yes		Don't ask for confirmation.
+	-auto-rollback	Wait for the new instances to be InService and roll back if they aren't.
+	-wait		Wait for the new instances to be InService on the ELB.
+	-timeout	How long to wait (default 15m).
+	` + planFlagsHelp
 	return strings.TrimSpace(helpText)
 }
 
 func (c *ConfirmCommand) Run(args []string) int {
-	plan := `
-
-Plan:
-+++ ASG: %s
-+++ LC: %s
-+++ # of servers to deploy: %d
-
-`
-	config := &aws.Config{
-		Region: aws.String("us-east-1"),
+	cmdFlags := flag.NewFlagSet("confirm", flag.ContinueOnError)
+	cmdFlags.Usage = func() { c.Ui.Output(c.Help()) }
+	envName := envFlag(cmdFlags, "prod")
+	versionFlag := cmdFlags.String("version", "", "version to confirm")
+	lcFlag := cmdFlags.String("lc", "", "launch configuration to confirm")
+	yes := cmdFlags.Bool("yes", false, "don't ask for confirmation")
+	autoRollback := cmdFlags.Bool("auto-rollback", false, "roll back if the new instances never get InService")
+	wait := cmdFlags.Bool("wait", false, "wait for the new instances to be InService")
+	timeout := cmdFlags.Duration("timeout", 15*time.Minute, "time to wait for instances to be InService")
+	planFlags := addPlanFlags(cmdFlags)
+	if err := cmdFlags.Parse(args); err != nil {
+		c.Ui.Error(fmt.Sprintf("%s", err))
+		return 1
 	}
-	service := autoscaling.New(session.New(), config)
 
-	version, err := c.Ui.Ask("Which version do you want to confirm (ex 8.8.8): ")
+	env, err := c.Envs.Get(*envName)
 	if err != nil {
-		c.Ui.Error(fmt.Sprintf("Error reading version #: %s", err))
+		c.Ui.Error(err.Error())
 		return 1
 	}
 
-	c.Ui.Info(fmt.Sprintf("--> : %s", version))
-
-	possibleLCs := make([]*string, len(c.Apps))
-
-	var appNameMap map[string]core.SuripuApp
-	appNameMap = make(map[string]core.SuripuApp)
-
-	for idx, app := range c.Apps {
-		str := fmt.Sprintf("%s-prod-%s", app.Name, version)
-		possibleLCs[idx] = &str
-		appNameMap[app.Name] = app
-	}
-
-	max := int64(len(c.Apps))
-	describeLCReq := &autoscaling.DescribeLaunchConfigurationsInput{
-		LaunchConfigurationNames: possibleLCs,
-		MaxRecords:               &max,
-	}
+	service := c.Aws.AutoScaling
+	lbs := c.Aws.LoadBalancers()
 
-	lcsResp, err := service.DescribeLaunchConfigurations(describeLCReq)
+	lcName, err := c.chooseLC(service, env, *versionFlag, *lcFlag)
 	if err != nil {
-		c.Ui.Error(fmt.Sprintf("%s", err))
-		return 1
-	}
-
-	if len(lcsResp.LaunchConfigurations) == 0 {
-		c.Ui.Error(fmt.Sprintf("No launch configuration found for version: %s", version))
+		c.Ui.Error(err.Error())
 		return 1
 	}
+	c.Ui.Info(fmt.Sprintf("--> proceeding with LC : %s", lcName))
 
-	c.Ui.Output("")
-	c.Ui.Output(fmt.Sprintf("Found the following matching Launch Configurations for version: %s:\n", version))
-	for idx, stuff := range lcsResp.LaunchConfigurations {
-		c.Ui.Info(fmt.Sprintf("[%d] %s", idx, *stuff.LaunchConfigurationName))
-	}
-
-	c.Ui.Output("")
-	app, err := c.Ui.Ask("Launch configuration (LC) #: ")
-	appIdx, _ := strconv.Atoi(app)
-
-	if err != nil || appIdx >= len(lcsResp.LaunchConfigurations) {
-		c.Ui.Error(fmt.Sprintf("Error reading app #: %s", err))
+	selectedApp, err := env.AppForLC(c.Apps, lcName)
+	if err != nil {
+		c.Ui.Error(err.Error())
 		return 1
 	}
 
-	lcName := *lcsResp.LaunchConfigurations[appIdx].LaunchConfigurationName
-	c.Ui.Info(fmt.Sprintf("--> proceeding with LC : %s", lcName))
-
-	parts := strings.Split(lcName, "-prod-")
-
-	selectedApp := appNameMap[parts[0]]
-
-	groupnames := make([]*string, 2)
-	one := fmt.Sprintf("%s-prod", selectedApp.Name)
-	two := fmt.Sprintf("%s-prod-green", selectedApp.Name)
-	groupnames[0] = &one
-	groupnames[1] = &two
-
 	describeASGreq := &autoscaling.DescribeAutoScalingGroupsInput{
-		AutoScalingGroupNames: groupnames,
+		AutoScalingGroupNames: env.GroupNames(selectedApp),
 	}
 
 	describeASGResp, err := service.DescribeAutoScalingGroups(describeASGreq)
@@ -110,52 +85,88 @@ Plan:
 		return 1
 	}
 
-	desiredCapacity := selectedApp.TargetDesiredCapacity
+	desiredCapacity := env.DesiredCapacity(selectedApp)
 
 	for _, asg := range describeASGResp.AutoScalingGroups {
 		asgName := *asg.AutoScalingGroupName
-		if *asg.LaunchConfigurationName == lcName && *asg.DesiredCapacity != desiredCapacity {
+		if core.GroupLaunchRef(asg) == lcName && *asg.DesiredCapacity != desiredCapacity {
+			// Fail before changing anything when there is nothing to roll
+			// back to.
+			restoreAsg := ""
+			if *autoRollback {
+				restoreAsg, err = rollbackTarget(describeASGResp.AutoScalingGroups, asgName)
+				if err != nil {
+					c.Ui.Error(err.Error())
+					return 1
+				}
+			}
 
-			c.Ui.Warn(fmt.Sprintf("--- # of servers to deploy: %d", *asg.DesiredCapacity))
-			c.Ui.Info(fmt.Sprintf("+++ # of servers to deploy: %d", desiredCapacity))
+			plan := core.NewPlan("confirm", selectedApp.Name, env.Name)
+			plan.Add(scaleChange(asg, lcName, desiredCapacity))
+			stop, err := planFlags.show(c.Ui, plan)
+			if err != nil {
+				c.Ui.Error(err.Error())
+				return 1
+			}
+			if stop {
+				return 0
+			}
 
+			unlock, err := c.Lock.acquire(c.Ui, selectedApp, env, "confirm", *timeout)
 			if err != nil {
-				c.Ui.Error(fmt.Sprintf("%s", err))
+				c.Ui.Error(err.Error())
 				return 1
 			}
+			defer unlock()
 
-			ok, err := c.Ui.Ask("'ok' if you agree, anything else to cancel: ")
+			ok, err := approve(c.Ui, env, *yes, "'ok' if you agree, anything else to cancel: ")
 			if err != nil {
 				c.Ui.Error(fmt.Sprintf("%s", err))
 				return 1
 			}
 
-			if ok != "ok" {
+			if !ok {
 				c.Ui.Warn("Cancelled.")
 				return 0
 			}
 
-			maxSize := desiredCapacity * 2
-			updateReq := &autoscaling.UpdateAutoScalingGroupInput{
-				DesiredCapacity:         &desiredCapacity,
-				AutoScalingGroupName:    &asgName,
-				LaunchConfigurationName: &lcName,
-				MinSize:                 &desiredCapacity,
-				MaxSize:                 &maxSize,
-			}
-
-			c.Ui.Info("Executing plan:")
-			c.Ui.Info(fmt.Sprintf(plan, asgName, lcName, *updateReq.DesiredCapacity))
-			_, err = service.UpdateAutoScalingGroup(updateReq)
+			deployAction := NewAsgAction("confirm", selectedApp, env, asgName, lcName, *asg.DesiredCapacity, desiredCapacity)
+			deployAction.PreviousLC = servingLC(describeASGResp.AutoScalingGroups, asgName)
+			c.Ui.Info("Executing plan...")
+			c.Notifier.Notify(deployAction)
+			err = scaleASG(service, asgName, lcName, desiredCapacity)
 			if err != nil {
+				c.Notifier.Notify(deployAction.Failed(err))
 				c.Ui.Error(fmt.Sprintf("%s", err))
 				return 1
 			}
-			deployAction := NewDeployAction("confirm", asgName, lcName, desiredCapacity)
-			c.Notifier.Notify(deployAction)
 			// fmt.Println(*updateReq.AutoScalingGroupName)
 
 			c.Ui.Info("Update autoscaling group request acknowledged")
+
+			if *autoRollback {
+				err := waitOrRollback(c.Ui, c.Notifier, deployAction, service, lbs, selectedApp, env, asgName, lcName, restoreAsg, desiredCapacity, *timeout)
+				if err != nil {
+					c.Ui.Error(err.Error())
+					return 1
+				}
+			} else if *wait {
+				elbName, err := healthElbName(service, selectedApp, env)
+				if err != nil {
+					c.Notifier.Notify(deployAction.Failed(err))
+					c.Ui.Error(err.Error())
+					return 1
+				}
+				c.Ui.Info(fmt.Sprintf("Waiting for %d instances of %s to be InService", desiredCapacity, asgName))
+				err = waitAndNotify(c.Ui, c.Notifier, deployAction, service, lbs, asgName, elbName, lcName, desiredCapacity, *timeout)
+				if err != nil {
+					c.Ui.Error(err.Error())
+					return 1
+				}
+			} else {
+				c.Notifier.Notify(deployAction.Succeeded())
+				c.Ui.Info("Run: `sanders status` to monitor servers being attached to ELB")
+			}
 			return 0
 		}
 	}
@@ -164,6 +175,84 @@ Plan:
 	return 0
 }
 
+// chooseLC returns the launch configuration to confirm, either the one given
+// with -lc or one matching the version across all apps in env.
+func (c *ConfirmCommand) chooseLC(service autoscalingiface.AutoScalingAPI, env *core.Environment, version, lcName string) (string, error) {
+	if lcName != "" {
+		return lcName, nil
+	}
+
+	if version == "" {
+		answer, err := c.Ui.Ask("Which version do you want to confirm (ex 8.8.8): ")
+		if err != nil {
+			return "", errors.New(fmt.Sprintf("Error reading version #: %s", err))
+		}
+		version = answer
+	}
+
+	c.Ui.Info(fmt.Sprintf("--> : %s", version))
+
+	possibleLCs := make([]*string, len(c.Apps))
+
+	for idx := range c.Apps {
+		str := env.LaunchConfigName(&c.Apps[idx], version)
+		possibleLCs[idx] = &str
+	}
+
+	max := int64(len(c.Apps))
+	describeLCReq := &autoscaling.DescribeLaunchConfigurationsInput{
+		LaunchConfigurationNames: possibleLCs,
+		MaxRecords:               &max,
+	}
+
+	lcsResp, err := service.DescribeLaunchConfigurations(describeLCReq)
+	if err != nil {
+		return "", err
+	}
+
+	candidates := make([]string, 0)
+	for _, stuff := range lcsResp.LaunchConfigurations {
+		candidates = append(candidates, *stuff.LaunchConfigurationName)
+	}
+
+	// Launch template versions carry the app version in their description.
+	for idx := range c.Apps {
+		versions, err := core.LaunchTemplateVersions(c.Aws.EC2, env.LaunchTemplateName(&c.Apps[idx]))
+		if err != nil {
+			return "", err
+		}
+		sort.Sort(sort.Reverse(core.ByTemplateVersion(versions)))
+		for _, templateVersion := range versions {
+			if aws.StringValue(templateVersion.VersionDescription) == version {
+				candidates = append(candidates, core.LaunchTemplateRef(*templateVersion.LaunchTemplateName, *templateVersion.VersionNumber))
+				break
+			}
+		}
+	}
+
+	if len(candidates) == 0 {
+		return "", errors.New(fmt.Sprintf("No launch configuration found for version: %s", version))
+	}
+
+	c.Ui.Output("")
+	c.Ui.Output(fmt.Sprintf("Found the following matching Launch Configurations for version: %s:\n", version))
+	for idx, candidate := range candidates {
+		c.Ui.Info(fmt.Sprintf("[%d] %s", idx, candidate))
+	}
+
+	c.Ui.Output("")
+	app, err := c.Ui.Ask("Launch configuration (LC) #: ")
+	if err != nil {
+		return "", err
+	}
+	appIdx, err := core.ParseSelection(app, len(candidates))
+	if err != nil {
+		return "", err
+	}
+
+	return candidates[appIdx], nil
+}
+
 func (c *ConfirmCommand) Synopsis() string {
 	return "confirms the given version is good and increase number of instances"
 }
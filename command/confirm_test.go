@@ -0,0 +1,73 @@
+package command
+
+import (
+	"github.com/hello/sanders/core"
+	"github.com/hello/sanders/fakes"
+	"github.com/mitchellh/cli"
+	"strings"
+	"testing"
+)
+
+func newConfirmCommand(cloud *fakes.Cloud) (*ConfirmCommand, *recordingNotifier) {
+	notifier := &recordingNotifier{}
+	return &ConfirmCommand{
+		Ui:       testUi(),
+		Aws:      cloud.Clients(),
+		Notifier: notifier,
+		Apps:     []core.SuripuApp{testApp},
+		Envs:     core.DefaultEnvironments,
+	}, notifier
+}
+
+func TestConfirmAfterDeploy(t *testing.T) {
+	cloud := stagingCloud("suripu-app-staging")
+	checksum := putPackage(cloud, testEnv(t, "staging"), "1.0.1")
+	deploy, _ := newDeployCommand(cloud)
+	expectRun(t, deploy, deploy.Ui, 0, "-env", "staging", "-app", "suripu-app", "-lc", "suripu-app-staging-1.0.1", "-yes")
+
+	c, notifier := newConfirmCommand(cloud)
+	// -version lists the matching launch configurations to pick from.
+	c.Ui.Ui.(*cli.MockUi).InputReader = strings.NewReader("0\n")
+	expectRun(t, c, c.Ui, 0, "-env", "staging", "-version", "1.0.1", "-yes", "-wait", "-timeout", "0s")
+
+	expectCapacity(t, cloud, "suripu-app-staging-green", 2, 2, 4)
+	expectLaunchRef(t, cloud, "suripu-app-staging-green", "suripu-app-staging-1.0.1")
+	expectTags(t, cloud, "suripu-app-staging-green", map[string]string{
+		"Launch Configuration":  "suripu-app-staging-1.0.1",
+		"Service":               "suripu-app",
+		core.PackageChecksumTag: checksum,
+	})
+	expectCapacity(t, cloud, "suripu-app-staging", 2, 2, 4)
+
+	action := notifier.last(t, "confirm")
+	if action.Outcome != OutcomeSuccess || action.CapacityBefore != 1 || action.NumServers != 2 {
+		t.Errorf("confirm notified %s from %d to %d servers, want %s from 1 to 2", action.Outcome, action.CapacityBefore, action.NumServers, OutcomeSuccess)
+	}
+}
+
+func TestConfirmLaunchTemplateVersion(t *testing.T) {
+	cloud := stagingCloud("suripu-app-staging")
+	ref := cloud.AddLaunchTemplateVersion("suripu-app-staging", "1.0.2", "ami-00000002")
+	cloud.AddGroup("suripu-app-staging-green", ref, "suripu-app-staging", 1)
+	c, _ := newConfirmCommand(cloud)
+
+	expectRun(t, c, c.Ui, 0, "-env", "staging", "-lc", ref, "-yes")
+
+	expectCapacity(t, cloud, "suripu-app-staging-green", 2, 2, 4)
+	expectLaunchRef(t, cloud, "suripu-app-staging-green", ref)
+}
+
+func TestConfirmAutoRollback(t *testing.T) {
+	cloud := stagingCloud("suripu-app-staging")
+	cloud.AddGroup("suripu-app-staging-green", "suripu-app-staging-1.0.1", "suripu-app-staging", 1)
+	cloud.LaunchState = fakes.OutOfService
+	c, notifier := newConfirmCommand(cloud)
+
+	expectRun(t, c, c.Ui, 1, "-env", "staging", "-lc", "suripu-app-staging-1.0.1", "-yes", "-auto-rollback", "-timeout", "0s")
+
+	expectCapacity(t, cloud, "suripu-app-staging-green", 0, 0, 0)
+	expectCapacity(t, cloud, "suripu-app-staging", 2, 2, 4)
+	if action := notifier.last(t, "confirm"); action.Outcome != OutcomeRolledBack {
+		t.Errorf("confirm notified %s, want %s", action.Outcome, OutcomeRolledBack)
+	}
+}
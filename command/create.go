@@ -5,8 +5,9 @@ import (
 	"fmt"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/autoscaling"
-	"github.com/aws/aws-sdk-go/service/ec2"
-	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/autoscaling/autoscalingiface"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
 	"github.com/hello/sanders/core"
 	"github.com/mitchellh/cli"
 	"strings"
@@ -17,17 +18,30 @@ type CreateCommand struct {
 	Ui          cli.ColoredUi
 	Notifier    BasicNotifier
 	AmiSelector core.AmiSelector
-	Ec2Service  *ec2.EC2
-	S3Service   *s3.S3
-	AsgService  *autoscaling.AutoScaling
+	Ec2Service  ec2iface.EC2API
+	S3Service   s3iface.S3API
+	AsgService  autoscalingiface.AutoScalingAPI
 	KeyService  core.KeyService
 	Apps        []core.SuripuApp
+	Envs        core.Environments
+	Lock        *Locking
 }
 
 func (c *CreateCommand) Help() string {
-	helpText := `Usage: create [--emergency] [--canary]
+	helpText := `Usage: create [--emergency] [-env prod] [--canary] [-app name] [-version version] [-base-ami ami] [-launch-template] [-yes]
 	--emergency		Create specially named Launch Config for emergency situations ONLY.
-	--canary		Create a Launch Config for a canary build. (Not necessary for canary deploys)`
+	-env			Environment the Launch Config is for (default prod).
+	--canary		Same as -env canary. (Not necessary for canary deploys)
+	-app			App to create the Launch Config for. Prompts if omitted.
+	-version		Package version to use, or a pattern like 8.8.* to
+				pick from. Prompts if omitted.
+	-base-ami		Base AMI id, or name pattern to take the latest of,
+				instead of the one of the app and environment.
+	-launch-template	Add a version to the launch template of the app instead
+				of creating a Launch Config. Default for apps with
+				"launch_template": true.
+	-yes			Don't ask for confirmation.
+	` + planFlagsHelp
 	return strings.TrimSpace(helpText)
 }
 
@@ -35,61 +49,59 @@ func (c *CreateCommand) Run(args []string) int {
 
 	var isEmergency bool
 	var isCanary bool
+	var appName string
+	var version string
+	var envName string
+	var yes bool
+	var useTemplate bool
+	var baseAmi string
 
 	cmdFlags := flag.NewFlagSet("create", flag.ContinueOnError)
 	cmdFlags.Usage = func() { c.Ui.Output(c.Help()) }
 
 	cmdFlags.BoolVar(&isEmergency, "emergency", false, "emergency")
+	cmdFlags.StringVar(&envName, "env", "prod", "environment")
 	cmdFlags.BoolVar(&isCanary, "canary", false, "canary")
+	cmdFlags.StringVar(&appName, "app", "", "app")
+	cmdFlags.StringVar(&version, "version", "", "version")
+	cmdFlags.BoolVar(&yes, "yes", false, "yes")
+	cmdFlags.BoolVar(&useTemplate, "launch-template", false, "launch template")
+	cmdFlags.StringVar(&baseAmi, "base-ami", "", "base AMI id or name pattern")
+	planFlags := addPlanFlags(cmdFlags)
 	if err := cmdFlags.Parse(args); err != nil {
 		c.Ui.Error(fmt.Sprintf("%v", err))
 		return 1
 	}
 
-	environment := "prod"
 	if isCanary {
-		environment = "canary"
+		envName = "canary"
 	}
 
-	c.Ui.Output(fmt.Sprintf("Creating LC for %s environment.\n", environment))
-
-	appSelector := core.NewCliAppSelector(c.Ui)
-	selectedApp, err := appSelector.Choose(c.Apps)
+	env, err := c.Envs.Get(envName)
 	if err != nil {
 		c.Ui.Error(err.Error())
 		return 1
 	}
 
-	var params *autoscaling.DescribeAccountLimitsInput
-	accountLimits, err := c.AsgService.DescribeAccountLimits(params)
-
+	appSelector := core.NewAppSelector(c.Ui, appName)
+	selectedApp, err := appSelector.Choose(c.Apps)
 	if err != nil {
 		c.Ui.Error(err.Error())
 		return 1
 	}
-	maxLCs := *accountLimits.MaxNumberOfLaunchConfigurations
-
-	lcParams := &autoscaling.DescribeLaunchConfigurationsInput{
-		MaxRecords: aws.Int64(100),
-	}
-
-	currentLCCount := 0
-	err = c.AsgService.DescribeLaunchConfigurationsPages(lcParams,
-		func(page *autoscaling.DescribeLaunchConfigurationsOutput, lastPage bool) bool {
-			currentLCCount += len(page.LaunchConfigurations)
-			return !lastPage
-		})
-
-	if err != nil {
-		// Print the error, cast err to awserr.Error to get the Code and
-		// Message from an error.
-		c.Ui.Error(err.Error())
-		return 1
+	useTemplate = useTemplate || selectedApp.LaunchTemplate
+
+	if useTemplate {
+		c.Ui.Output(fmt.Sprintf("Creating launch template version for %s environment.\n", env.Name))
+	} else {
+		c.Ui.Output(fmt.Sprintf("Creating LC for %s environment.\n", env.Name))
+		if err := c.printLCCapacity(); err != nil {
+			c.Ui.Error(err.Error())
+			return 1
+		}
 	}
 
-	c.Ui.Info(fmt.Sprintf("Current Launch Config Capacity: %d/%d", currentLCCount, maxLCs))
-
-	selectedAmi, err := c.AmiSelector.Select(*selectedApp, environment)
+	selectedAmi, err := c.AmiSelector.Select(*selectedApp, env, version, baseAmi)
 
 	if err != nil {
 		c.Ui.Error(err.Error())
@@ -104,20 +116,14 @@ func (c *CreateCommand) Run(args []string) int {
 		emergencyText = "-emergency"
 	}
 
-	launchConfigName := fmt.Sprintf("%s-%s-%s%s", selectedApp.Name, environment, selectedAmi.Version, emergencyText)
-
-	//Create deployment-specific KeyPair
+	launchConfigName := env.LaunchConfigName(selectedApp, selectedAmi.Version+emergencyText)
 
 	keyName := fmt.Sprintf("%s-%d", launchConfigName, time.Now().Unix())
 
-	keyUploadResults, err := c.KeyService.Upload(keyName, *selectedApp, environment)
-	if err != nil {
-		c.Ui.Error(err.Error())
-		return 1
+	if useTemplate {
+		return c.createTemplateVersion(planFlags, env, selectedApp, selectedAmi, selectedAmi.Version+emergencyText, keyName, yes)
 	}
 
-	c.Ui.Info(fmt.Sprintf("Created KeyPair: %s. \n", keyUploadResults.KeyName))
-
 	createLCParams := &autoscaling.CreateLaunchConfigurationInput{
 		LaunchConfigurationName:  aws.String(launchConfigName), // Required
 		AssociatePublicIpAddress: aws.Bool(true),
@@ -134,28 +140,59 @@ func (c *CreateCommand) Run(args []string) int {
 		UserData: aws.String(selectedAmi.UserData),
 	}
 
-	deployAction := NewDeployAction("create", selectedApp.Name, launchConfigName, 0)
+	plan := core.NewPlan("create", selectedApp.Name, env.Name)
+	plan.Add(core.Change{
+		Resource: "key_pair",
+		Name:     keyName,
+		Action:   core.ActionCreate,
+	})
+	plan.Add(withBaseAmi(launchConfigurationChange(createLCParams), selectedAmi))
+	stop, err := planFlags.show(c.Ui, plan)
+	if err != nil {
+		c.Ui.Error(err.Error())
+		return 1
+	}
+	if stop {
+		return 0
+	}
 
-	c.Ui.Info(fmt.Sprint("Creating Launch Configuration with the following parameters:"))
-	c.Ui.Info(fmt.Sprint(createLCParams))
-	ok, err := c.Ui.Ask("'ok' if you agree, anything else to cancel: ")
+	unlock, err := c.Lock.acquire(c.Ui, selectedApp, env, "create", 0)
+	if err != nil {
+		c.Ui.Error(err.Error())
+		return 1
+	}
+	defer unlock()
+
+	ok, err := approve(c.Ui, env, yes, "'ok' if you agree, anything else to cancel: ")
 	if err != nil {
 		c.Ui.Error(fmt.Sprintf("%s", err))
-		c.Cleanup(keyUploadResults)
 		return 1
 	}
 
-	if ok != "ok" {
+	if !ok {
 		c.Ui.Warn("Cancelled.")
-		if !c.Cleanup(keyUploadResults) {
-			return 1
-		}
 		return 0
 	}
 
+	//Create deployment-specific KeyPair
+
+	deployAction := NewDeployAction("create", selectedApp.Name, launchConfigName, 0)
+	deployAction.Env = env.Name
+	c.Notifier.Notify(deployAction)
+
+	keyUploadResults, err := c.KeyService.Upload(keyName, *selectedApp, env.Name)
+	if err != nil {
+		c.Notifier.Notify(deployAction.Failed(err))
+		c.Ui.Error(err.Error())
+		return 1
+	}
+
+	c.Ui.Info(fmt.Sprintf("Created KeyPair: %s. \n", keyUploadResults.KeyName))
+
 	_, createError := c.AsgService.CreateLaunchConfiguration(createLCParams)
 
 	if createError != nil {
+		c.Notifier.Notify(deployAction.Failed(createError))
 		// Message from an error.
 		c.Ui.Error(fmt.Sprintf("Failed to create Launch Configuration: %s", launchConfigName))
 		c.Ui.Error(fmt.Sprintln(createError.Error()))
@@ -163,12 +200,112 @@ func (c *CreateCommand) Run(args []string) int {
 		return 1
 	}
 
-	c.Notifier.Notify(deployAction)
+	c.Notifier.Notify(deployAction.Succeeded())
 	c.Ui.Output(fmt.Sprintln("Launch Configuration created."))
 
 	return 0
 }
 
+// printLCCapacity shows how many launch configurations the account can still
+// create.
+func (c *CreateCommand) printLCCapacity() error {
+	var params *autoscaling.DescribeAccountLimitsInput
+	accountLimits, err := c.AsgService.DescribeAccountLimits(params)
+	if err != nil {
+		return err
+	}
+	maxLCs := *accountLimits.MaxNumberOfLaunchConfigurations
+
+	lcParams := &autoscaling.DescribeLaunchConfigurationsInput{
+		MaxRecords: aws.Int64(100),
+	}
+
+	currentLCCount := 0
+	err = c.AsgService.DescribeLaunchConfigurationsPages(lcParams,
+		func(page *autoscaling.DescribeLaunchConfigurationsOutput, lastPage bool) bool {
+			currentLCCount += len(page.LaunchConfigurations)
+			return !lastPage
+		})
+	if err != nil {
+		return err
+	}
+
+	c.Ui.Info(fmt.Sprintf("Current Launch Config Capacity: %d/%d", currentLCCount, maxLCs))
+	return nil
+}
+
+// createTemplateVersion adds a version running ami to the launch template of
+// app, creating the template on first use.
+func (c *CreateCommand) createTemplateVersion(planFlags *planFlags, env *core.Environment, app *core.SuripuApp, ami *core.SelectedAmi, version, keyName string, yes bool) int {
+	template, err := newTemplateVersion(c.Ec2Service, env, app, ami, version, keyName)
+	if err != nil {
+		c.Ui.Error(err.Error())
+		return 1
+	}
+
+	plan := core.NewPlan("create", app.Name, env.Name)
+	plan.Add(core.Change{
+		Resource: "key_pair",
+		Name:     keyName,
+		Action:   core.ActionCreate,
+	})
+	template.addTo(plan)
+	stop, err := planFlags.show(c.Ui, plan)
+	if err != nil {
+		c.Ui.Error(err.Error())
+		return 1
+	}
+	if stop {
+		return 0
+	}
+
+	unlock, err := c.Lock.acquire(c.Ui, app, env, "create", 0)
+	if err != nil {
+		c.Ui.Error(err.Error())
+		return 1
+	}
+	defer unlock()
+
+	ok, err := approve(c.Ui, env, yes, "'ok' if you agree, anything else to cancel: ")
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("%s", err))
+		return 1
+	}
+
+	if !ok {
+		c.Ui.Warn("Cancelled.")
+		return 0
+	}
+
+	deployAction := NewDeployAction("create", app.Name, template.ref(), 0)
+	deployAction.Env = env.Name
+	c.Notifier.Notify(deployAction)
+
+	keyUploadResults, err := c.KeyService.Upload(keyName, *app, env.Name)
+	if err != nil {
+		c.Notifier.Notify(deployAction.Failed(err))
+		c.Ui.Error(err.Error())
+		return 1
+	}
+
+	c.Ui.Info(fmt.Sprintf("Created KeyPair: %s. \n", keyUploadResults.KeyName))
+
+	ref, err := template.create(c.Ec2Service)
+	if err != nil {
+		c.Notifier.Notify(deployAction.Failed(err))
+		c.Ui.Error(fmt.Sprintf("Failed to create version of launch template: %s", template.name))
+		c.Ui.Error(err.Error())
+		c.Cleanup(keyUploadResults)
+		return 1
+	}
+
+	deployAction.LC = ref
+	c.Notifier.Notify(deployAction.Succeeded())
+	c.Ui.Output(fmt.Sprintf("Launch template version %s created.", deployAction.LC))
+
+	return 0
+}
+
 func (c *CreateCommand) Cleanup(uploadRes *core.KeyUploadResult) bool {
 
 	c.Ui.Info("")
@@ -186,5 +323,5 @@ func (c *CreateCommand) Cleanup(uploadRes *core.KeyUploadResult) bool {
 }
 
 func (c *CreateCommand) Synopsis() string {
-	return "Creates a launch configuration based on selected parameters."
+	return "Creates a launch configuration or launch template version based on selected parameters."
 }
@@ -0,0 +1,114 @@
+package command
+
+import (
+	"encoding/base64"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/hello/sanders/core"
+	"github.com/hello/sanders/fakes"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testUserData = "#!/bin/bash\necho {{.app_name}} {{.app_version}} {{.env}}\n"
+
+// newCreateCommand creates launch configurations of suripu-app 1.0.1 on top
+// of ami-0000base, with user data from a pinned template.
+func newCreateCommand(t *testing.T, cloud *fakes.Cloud) (*CreateCommand, *recordingNotifier) {
+	cloud.AddImage("Base-2026-10-01", "ami-0000base", time.Now().Add(-24*time.Hour))
+	cloud.PutObject("hello-deploy", "userdata/test_userdata.sh", []byte(testUserData))
+	putPackage(cloud, testEnv(t, "staging"), "1.0.1")
+
+	clients := cloud.Clients()
+	ui := testUi()
+	generator := core.NewUserMetaDataGenerator([]core.UserDataTemplate{{
+		Name:   core.DefaultUserDataTemplate,
+		Bucket: "hello-deploy",
+		Key:    "userdata/test_userdata.sh",
+		Sha256: core.UserDataSha256([]byte(testUserData)),
+	}}, clients.S3)
+
+	notifier := &recordingNotifier{}
+	return &CreateCommand{
+		Ui:          ui,
+		Notifier:    notifier,
+		AmiSelector: core.NewSuripuAppAmiSelector(ui, clients.EC2, clients.S3, generator, "us-east-1"),
+		KeyService:  core.NewS3KeyService(clients.KeyS3, clients.EC2, "hello-keys"),
+		Ec2Service:  clients.EC2,
+		S3Service:   clients.S3,
+		AsgService:  clients.AutoScaling,
+		Apps:        []core.SuripuApp{testApp},
+		Envs:        core.DefaultEnvironments,
+	}, notifier
+}
+
+func TestCreateLaunchConfiguration(t *testing.T) {
+	cloud := fakes.NewCloud()
+	c, notifier := newCreateCommand(t, cloud)
+
+	expectRun(t, c, c.Ui, 0, "-env", "staging", "-app", "suripu-app", "-version", "1.0.1", "-base-ami", "ami-0000base", "-yes")
+
+	lc, ok := cloud.LaunchConfigurations["suripu-app-staging-1.0.1"]
+	if !ok {
+		t.Fatalf("no launch configuration suripu-app-staging-1.0.1 in %v", cloud.LaunchConfigurations)
+	}
+	if aws.StringValue(lc.ImageId) != "ami-0000base" || aws.StringValue(lc.InstanceType) != "m3.medium" {
+		t.Errorf("launch configuration runs %s on %s, want ami-0000base on m3.medium", aws.StringValue(lc.ImageId), aws.StringValue(lc.InstanceType))
+	}
+	userData, err := base64.StdEncoding.DecodeString(aws.StringValue(lc.UserData))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(userData), "echo suripu-app 1.0.1 staging") {
+		t.Errorf("user data isn't rendered for suripu-app 1.0.1:\n%s", userData)
+	}
+	keyName := aws.StringValue(lc.KeyName)
+	if !strings.HasPrefix(keyName, "suripu-app-staging-1.0.1-") || !cloud.KeyPairs[keyName] {
+		t.Errorf("launch configuration uses key pair %q, which wasn't created", keyName)
+	}
+	if _, ok := cloud.Objects["hello-keys/"+core.KeyObjectPath("staging", "suripu-app", keyName)]; !ok {
+		t.Errorf("private key of %s wasn't uploaded", keyName)
+	}
+
+	if action := notifier.last(t, "create"); action.Outcome != OutcomeSuccess || action.LC != "suripu-app-staging-1.0.1" {
+		t.Errorf("create notified %s of %s", action.Outcome, action.LC)
+	}
+}
+
+func TestCreateLaunchTemplateVersion(t *testing.T) {
+	cloud := fakes.NewCloud()
+	cloud.AddLaunchTemplateVersion("suripu-app-staging", "1.0.0", "ami-00000001")
+	c, notifier := newCreateCommand(t, cloud)
+
+	expectRun(t, c, c.Ui, 0, "-env", "staging", "-app", "suripu-app", "-version", "1.0.1", "-base-ami", "ami-0000base", "-launch-template", "-yes")
+
+	if len(cloud.LaunchConfigurations) != 0 {
+		t.Errorf("created launch configurations %v", cloud.LaunchConfigurations)
+	}
+	versions := cloud.LaunchTemplates["suripu-app-staging"]
+	if len(versions) != 2 {
+		t.Fatalf("launch template suripu-app-staging has %d versions, want 2", len(versions))
+	}
+	created := versions[1]
+	if *created.VersionNumber != 2 || aws.StringValue(created.VersionDescription) != "1.0.1" || aws.StringValue(created.LaunchTemplateData.ImageId) != "ami-0000base" {
+		t.Errorf("created version %d of %s running %s, want version 2 of 1.0.1 running ami-0000base", *created.VersionNumber, aws.StringValue(created.VersionDescription), aws.StringValue(created.LaunchTemplateData.ImageId))
+	}
+	if !cloud.KeyPairs[aws.StringValue(created.LaunchTemplateData.KeyName)] {
+		t.Errorf("key pair %s wasn't created", aws.StringValue(created.LaunchTemplateData.KeyName))
+	}
+
+	if action := notifier.last(t, "create"); action.Outcome != OutcomeSuccess || action.LC != "suripu-app-staging:2" {
+		t.Errorf("create notified %s of %s, want %s of suripu-app-staging:2", action.Outcome, action.LC, OutcomeSuccess)
+	}
+}
+
+func TestCreateRefusesMissingPackage(t *testing.T) {
+	cloud := fakes.NewCloud()
+	c, _ := newCreateCommand(t, cloud)
+
+	expectRun(t, c, c.Ui, 1, "-env", "staging", "-app", "suripu-app", "-version", "1.0.2", "-base-ami", "ami-0000base", "-yes")
+
+	if len(cloud.LaunchConfigurations) != 0 || len(cloud.KeyPairs) != 0 {
+		t.Errorf("created %v and key pairs %v for a missing package", cloud.LaunchConfigurations, cloud.KeyPairs)
+	}
+}
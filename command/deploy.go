@@ -1,53 +1,76 @@
 package command
 
 import (
+	"flag"
 	"fmt"
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/autoscaling"
 	"github.com/hello/sanders/core"
 	"github.com/mitchellh/cli"
 	"strings"
+	"time"
 )
 
 type DeployCommand struct {
 	Ui       cli.ColoredUi
+	Aws      *core.Clients
 	Notifier BasicNotifier
 	Apps     []core.SuripuApp
+	Envs     core.Environments
+	Lock     *Locking
 }
 
 func (c *DeployCommand) Help() string {
-	helpText := `Usage: hello deploy`
+	helpText := `Usage: sanders deploy [-env prod] [-app name] [-lc name] [-yes] [-wait] [-auto-rollback] [-timeout 15m]
+	-env	Environment to deploy to (default prod).
+	-app	App to deploy. Prompts if omitted.
+	-lc	Launch configuration, or launch template version (name:version),
+		to deploy. Prompts if omitted.
+	-yes	Don't ask for confirmation.
+	-auto-rollback	Wait for the new instance to be InService and roll back if it isn't.
+	-wait		Wait for the new instance to be InService on the ELB.
+	-timeout	How long to wait (default 15m).
+	` + planFlagsHelp
 	return strings.TrimSpace(helpText)
 }
 
 func (c *DeployCommand) Run(args []string) int {
 
-	plan := `
-
-Plan:
-+++ ASG: %s
-+++ LC: %s
-+++ # of servers to deploy: %d
+	cmdFlags := flag.NewFlagSet("deploy", flag.ContinueOnError)
+	cmdFlags.Usage = func() { c.Ui.Output(c.Help()) }
+	envName := envFlag(cmdFlags, "prod")
+	appName := cmdFlags.String("app", "", "app to deploy")
+	lc := cmdFlags.String("lc", "", "launch configuration to deploy")
+	yes := cmdFlags.Bool("yes", false, "don't ask for confirmation")
+	autoRollback := cmdFlags.Bool("auto-rollback", false, "roll back if the new instance never gets InService")
+	wait := cmdFlags.Bool("wait", false, "wait for the new instance to be InService")
+	timeout := cmdFlags.Duration("timeout", 15*time.Minute, "time to wait for instances to be InService")
+	planFlags := addPlanFlags(cmdFlags)
+	if err := cmdFlags.Parse(args); err != nil {
+		c.Ui.Error(fmt.Sprintf("%s", err))
+		return 1
+	}
 
-`
-	config := &aws.Config{
-		Region: aws.String("us-east-1"),
+	env, err := c.Envs.Get(*envName)
+	if err != nil {
+		c.Ui.Error(err.Error())
+		return 1
 	}
-	service := autoscaling.New(session.New(), config)
+
+	service := c.Aws.AutoScaling
+	lbs := c.Aws.LoadBalancers()
 
 	desiredCapacity := int64(1)
 
-	appSelector := core.NewCliAppSelector(c.Ui)
+	appSelector := core.NewAppSelector(c.Ui, *appName)
 	selectedApp, err := appSelector.Choose(c.Apps)
 	if err != nil {
 		c.Ui.Error(err.Error())
 		return 1
 	}
 
-	lcSelector := core.NewCliLaunchConfigurationSelector(c.Ui, service)
+	lcSelector := core.NewLaunchConfigurationSelector(c.Ui, service, c.Aws.EC2, *lc)
 
-	lcName, err := lcSelector.Choose(selectedApp)
+	lcName, err := lcSelector.Choose(selectedApp, env)
 	if err != nil {
 		c.Ui.Error(err.Error())
 		return 1
@@ -55,16 +78,8 @@ Plan:
 
 	c.Ui.Info(fmt.Sprintf("--> proceeding with LC : %s", lcName))
 
-	appName := selectedApp.Name
-
-	groupnames := make([]*string, 2)
-	one := fmt.Sprintf("%s-prod", appName)
-	two := fmt.Sprintf("%s-prod-green", appName)
-	groupnames[0] = &one
-	groupnames[1] = &two
-
 	describeASGreq := &autoscaling.DescribeAutoScalingGroupsInput{
-		AutoScalingGroupNames: groupnames,
+		AutoScalingGroupNames: env.GroupNames(selectedApp),
 	}
 
 	describeASGResp, err := service.DescribeAutoScalingGroups(describeASGreq)
@@ -77,75 +92,61 @@ Plan:
 		asgName := *asg.AutoScalingGroupName
 		if *asg.DesiredCapacity == 0 {
 			c.Ui.Info(fmt.Sprintf("Update ASG %s with launch configuration:", asgName))
+			// Fail before changing anything when there is nothing to roll
+			// back to.
+			restoreAsg := ""
+			if *autoRollback {
+				restoreAsg, err = rollbackTarget(describeASGResp.AutoScalingGroups, asgName)
+				if err != nil {
+					c.Ui.Error(err.Error())
+					return 1
+				}
+			}
 
-			c.Ui.Warn(fmt.Sprintf(plan, asgName, lcName, desiredCapacity))
+			plan := core.NewPlan("deploy", selectedApp.Name, env.Name)
+			plan.Add(scaleChange(asg, lcName, desiredCapacity))
+			stop, err := planFlags.show(c.Ui, plan)
+			if err != nil {
+				c.Ui.Error(err.Error())
+				return 1
+			}
+			if stop {
+				return 0
+			}
 
+			unlock, err := c.Lock.acquire(c.Ui, selectedApp, env, "deploy", *timeout)
 			if err != nil {
-				c.Ui.Error(fmt.Sprintf("%s", err))
+				c.Ui.Error(err.Error())
 				return 1
 			}
+			defer unlock()
 
-			ok, err := c.Ui.Ask("'ok' if you agree, anything else to cancel: ")
+			ok, err := approve(c.Ui, env, *yes, "'ok' if you agree, anything else to cancel: ")
 			if err != nil {
 				c.Ui.Error(fmt.Sprintf("%s", err))
 				return 1
 			}
 
-			if ok != "ok" {
+			if !ok {
 				c.Ui.Warn("Cancelled.")
 				return 0
 			}
 
-			maxSize := desiredCapacity * 2
-			updateReq := &autoscaling.UpdateAutoScalingGroupInput{
-				DesiredCapacity:         &desiredCapacity,
-				AutoScalingGroupName:    &asgName,
-				LaunchConfigurationName: &lcName,
-				MinSize:                 &desiredCapacity,
-				MaxSize:                 &maxSize,
-			}
-
-			deployAction := NewDeployAction("deploy", asgName, lcName, *updateReq.DesiredCapacity)
-			c.Ui.Info("Executing plan:")
-			c.Ui.Info(fmt.Sprintf(plan, asgName, lcName, *updateReq.DesiredCapacity))
+			deployAction := NewAsgAction("deploy", selectedApp, env, asgName, lcName, *asg.DesiredCapacity, desiredCapacity)
+			deployAction.PreviousLC = servingLC(describeASGResp.AutoScalingGroups, asgName)
+			c.Ui.Info("Executing plan...")
+			c.Notifier.Notify(deployAction)
 
-			_, err = service.UpdateAutoScalingGroup(updateReq)
+			err = scaleASG(service, asgName, lcName, desiredCapacity)
 			if err != nil {
+				c.Notifier.Notify(deployAction.Failed(err))
 				c.Ui.Error(fmt.Sprintf("%s", err))
 				return 1
 			}
 
-			c.Notifier.Notify(deployAction)
-
-			tags := []core.Tag{
-				{
-					AsgName:   asgName,
-					TagName:   "Launch Configuration",
-					TagValue:  lcName,
-					Propagate: true,
-				},
-				{
-					AsgName:   asgName,
-					TagName:   "Name",
-					TagValue:  fmt.Sprintf("%s-prod", appName),
-					Propagate: true,
-				},
-				{
-					AsgName:   asgName,
-					TagName:   "Env",
-					TagValue:  "prod",
-					Propagate: true,
-				},
-				{
-					AsgName:   asgName,
-					TagName:   "Service",
-					TagValue:  appName,
-					Propagate: true,
-				},
-			}
-
-			respTag, err := c.updateASGTags(service, tags)
+			respTag, err := updateASGTags(service, deployTags(asgName, selectedApp, env, lcName, packageChecksum(c.Ui, c.Aws, selectedApp, env, lcName)))
 			if err != nil {
+				c.Notifier.Notify(deployAction.Failed(err))
 				c.Ui.Error(fmt.Sprintf("%s", err))
 				return 1
 			}
@@ -155,6 +156,30 @@ Plan:
 			}
 
 			c.Ui.Info(fmt.Sprintf("Update autoscaling group %s request acknowledged", asgName))
+
+			if *autoRollback {
+				err := waitOrRollback(c.Ui, c.Notifier, deployAction, service, lbs, selectedApp, env, asgName, lcName, restoreAsg, desiredCapacity, *timeout)
+				if err != nil {
+					c.Ui.Error(err.Error())
+					return 1
+				}
+			} else if *wait {
+				elbName, err := healthElbName(service, selectedApp, env)
+				if err != nil {
+					c.Notifier.Notify(deployAction.Failed(err))
+					c.Ui.Error(err.Error())
+					return 1
+				}
+				c.Ui.Info(fmt.Sprintf("Waiting for %d instances of %s to be InService", desiredCapacity, asgName))
+				err = waitAndNotify(c.Ui, c.Notifier, deployAction, service, lbs, asgName, elbName, lcName, desiredCapacity, *timeout)
+				if err != nil {
+					c.Ui.Error(err.Error())
+					return 1
+				}
+			} else {
+				c.Notifier.Notify(deployAction.Succeeded())
+				c.Ui.Info("Run: `sanders status` to monitor servers being attached to ELB")
+			}
 			return 0
 		}
 		c.Ui.Warn(fmt.Sprintf("%s ignored because desired capacity is > 0", asgName))
@@ -164,31 +189,6 @@ Plan:
 	return 0
 }
 
-func (c *DeployCommand) updateASGTags(service *autoscaling.AutoScaling, tagsToUpdate []core.Tag) (*autoscaling.CreateOrUpdateTagsOutput, error) {
-
-	tags := make([]*autoscaling.Tag, 0)
-
-	for _, tag := range tagsToUpdate {
-		awsTag := &autoscaling.Tag{ // Required
-			Key:               aws.String(tag.TagName), // Required
-			PropagateAtLaunch: aws.Bool(tag.Propagate),
-			ResourceId:        aws.String(tag.AsgName),
-			ResourceType:      aws.String("auto-scaling-group"),
-			Value:             aws.String(tag.TagValue),
-		}
-
-		tags = append(tags, awsTag)
-	}
-
-	//Tag the ASG so version number can be passed to instance
-	params := &autoscaling.CreateOrUpdateTagsInput{
-		Tags: tags,
-	}
-	resp, err := service.CreateOrUpdateTags(params)
-
-	return resp, err
-}
-
 func (c *DeployCommand) Synopsis() string {
 	return "deploy a new version of the app to the empty autoscaling group"
 }
@@ -0,0 +1,132 @@
+package command
+
+import (
+	"github.com/hello/sanders/core"
+	"github.com/hello/sanders/fakes"
+	"testing"
+)
+
+// stagingCloud runs 2 instances of suripu-app 1.0.0 in staging, with the
+// green ASG empty and 1.0.1 ready to deploy.
+func stagingCloud(elbName string) *fakes.Cloud {
+	cloud := fakes.NewCloud()
+	cloud.AddLaunchConfiguration("suripu-app-staging-1.0.0", "ami-00000001")
+	cloud.AddLaunchConfiguration("suripu-app-staging-1.0.1", "ami-00000001")
+	cloud.AddGroup("suripu-app-staging", "suripu-app-staging-1.0.0", elbName, 2)
+	cloud.AddGroup("suripu-app-staging-green", "suripu-app-staging-1.0.0", elbName, 0)
+	return cloud
+}
+
+func newDeployCommand(cloud *fakes.Cloud) (*DeployCommand, *recordingNotifier) {
+	notifier := &recordingNotifier{}
+	return &DeployCommand{
+		Ui:       testUi(),
+		Aws:      cloud.Clients(),
+		Notifier: notifier,
+		Apps:     []core.SuripuApp{testApp},
+		Envs:     core.DefaultEnvironments,
+	}, notifier
+}
+
+func TestDeployScalesEmptyGroup(t *testing.T) {
+	cloud := stagingCloud("suripu-app-staging")
+	checksum := putPackage(cloud, testEnv(t, "staging"), "1.0.1")
+	c, notifier := newDeployCommand(cloud)
+
+	expectRun(t, c, c.Ui, 0, "-env", "staging", "-app", "suripu-app", "-lc", "suripu-app-staging-1.0.1", "-yes")
+
+	expectCapacity(t, cloud, "suripu-app-staging-green", 1, 1, 2)
+	expectLaunchRef(t, cloud, "suripu-app-staging-green", "suripu-app-staging-1.0.1")
+	expectTags(t, cloud, "suripu-app-staging-green", map[string]string{
+		"Launch Configuration":  "suripu-app-staging-1.0.1",
+		"Name":                  "suripu-app-staging",
+		"Env":                   "staging",
+		"Service":               "suripu-app",
+		core.PackageChecksumTag: checksum,
+	})
+	expectCapacity(t, cloud, "suripu-app-staging", 2, 2, 4)
+	expectLaunchRef(t, cloud, "suripu-app-staging", "suripu-app-staging-1.0.0")
+
+	action := notifier.last(t, "deploy")
+	if action.Outcome != OutcomeSuccess || action.PreviousLC != "suripu-app-staging-1.0.0" {
+		t.Errorf("deploy notified %s from %s, want %s from suripu-app-staging-1.0.0", action.Outcome, action.PreviousLC, OutcomeSuccess)
+	}
+}
+
+func TestDeployLaunchTemplateVersion(t *testing.T) {
+	cloud := stagingCloud("suripu-app-staging")
+	ref := cloud.AddLaunchTemplateVersion("suripu-app-staging", "1.0.2", "ami-00000002")
+	checksum := putPackage(cloud, testEnv(t, "staging"), "1.0.2")
+	c, _ := newDeployCommand(cloud)
+
+	expectRun(t, c, c.Ui, 0, "-env", "staging", "-app", "suripu-app", "-lc", ref, "-yes", "-wait", "-timeout", "0s")
+
+	expectCapacity(t, cloud, "suripu-app-staging-green", 1, 1, 2)
+	expectLaunchRef(t, cloud, "suripu-app-staging-green", ref)
+	expectTags(t, cloud, "suripu-app-staging-green", map[string]string{
+		"Launch Configuration":  ref,
+		core.PackageChecksumTag: checksum,
+	})
+}
+
+func TestDeployWaitsWithoutLoadBalancer(t *testing.T) {
+	cloud := stagingCloud("")
+	c, notifier := newDeployCommand(cloud)
+
+	expectRun(t, c, c.Ui, 0, "-env", "staging", "-app", "suripu-app", "-lc", "suripu-app-staging-1.0.1", "-yes", "-wait", "-timeout", "0s")
+
+	expectCapacity(t, cloud, "suripu-app-staging-green", 1, 1, 2)
+	if action := notifier.last(t, "deploy"); action.Health == "" {
+		t.Errorf("deploy -wait notified no health")
+	}
+}
+
+func TestDeployWaitsOnTargetGroup(t *testing.T) {
+	for _, state := range []string{fakes.InService, fakes.OutOfService} {
+		cloud := stagingCloud("")
+		arn := cloud.AddTargetGroup("suripu-app-staging", "suripu-app-staging")
+		cloud.AttachTargetGroup("suripu-app-staging", arn)
+		cloud.AttachTargetGroup("suripu-app-staging-green", arn)
+		cloud.LaunchState = state
+		c, notifier := newDeployCommand(cloud)
+
+		want, outcome := 0, OutcomeSuccess
+		if state == fakes.OutOfService {
+			want, outcome = 1, OutcomeRolledBack
+		}
+		expectRun(t, c, c.Ui, want, "-env", "staging", "-app", "suripu-app", "-lc", "suripu-app-staging-1.0.1", "-yes", "-auto-rollback", "-timeout", "0s")
+
+		if action := notifier.last(t, "deploy"); action.Outcome != outcome {
+			t.Errorf("deploy with targets %s notified %s, want %s", state, action.Outcome, outcome)
+		}
+	}
+}
+
+func TestDeployAutoRollback(t *testing.T) {
+	cloud := stagingCloud("suripu-app-staging")
+	cloud.LaunchState = fakes.OutOfService
+	c, notifier := newDeployCommand(cloud)
+
+	expectRun(t, c, c.Ui, 1, "-env", "staging", "-app", "suripu-app", "-lc", "suripu-app-staging-1.0.1", "-yes", "-auto-rollback", "-timeout", "0s")
+
+	expectCapacity(t, cloud, "suripu-app-staging-green", 0, 0, 0)
+	expectCapacity(t, cloud, "suripu-app-staging", 2, 2, 4)
+	expectLaunchRef(t, cloud, "suripu-app-staging", "suripu-app-staging-1.0.0")
+	if action := notifier.last(t, "deploy"); action.Outcome != OutcomeRolledBack {
+		t.Errorf("deploy notified %s, want %s", action.Outcome, OutcomeRolledBack)
+	}
+}
+
+func TestDeployAutoRollbackWithoutRestoreTarget(t *testing.T) {
+	cloud := fakes.NewCloud()
+	cloud.AddLaunchConfiguration("suripu-app-staging-1.0.1", "ami-00000001")
+	cloud.AddGroup("suripu-app-staging-green", "suripu-app-staging-1.0.1", "suripu-app-staging", 0)
+	c, notifier := newDeployCommand(cloud)
+
+	expectRun(t, c, c.Ui, 1, "-env", "staging", "-app", "suripu-app", "-lc", "suripu-app-staging-1.0.1", "-yes", "-auto-rollback")
+
+	expectCapacity(t, cloud, "suripu-app-staging-green", 0, 0, 0)
+	if len(notifier.actions) != 0 {
+		t.Errorf("deploy notified %v before failing", notifier.actions)
+	}
+}
@@ -0,0 +1,23 @@
+package command
+
+import (
+	"flag"
+	"fmt"
+	"github.com/hello/sanders/core"
+	"github.com/mitchellh/cli"
+)
+
+// envFlag registers the -env flag shared by every command.
+func envFlag(cmdFlags *flag.FlagSet, defaultEnv string) *string {
+	return cmdFlags.String("env", defaultEnv, "environment to operate on (prod, canary, staging, dev or one from the config file)")
+}
+
+// approve asks for confirmation in environments that require approval. Other
+// environments proceed without a prompt.
+func approve(ui cli.ColoredUi, env *core.Environment, yes bool, question string) (bool, error) {
+	if !yes && !env.RequiresApproval {
+		ui.Output(fmt.Sprintf("%s does not require approval, proceeding.", env.Name))
+		return true, nil
+	}
+	return askOk(ui, yes, question)
+}
@@ -0,0 +1,108 @@
+package command
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/hello/sanders/core"
+	"github.com/hello/sanders/fakes"
+	"github.com/mitchellh/cli"
+	"testing"
+)
+
+// recordingNotifier keeps a copy of every notification, in order.
+type recordingNotifier struct {
+	actions []DeployAction
+}
+
+func (n *recordingNotifier) Notify(action *DeployAction) error {
+	n.actions = append(n.actions, *action)
+	return nil
+}
+
+// last returns the last notification of cmdType.
+func (n *recordingNotifier) last(t *testing.T, cmdType string) DeployAction {
+	for idx := len(n.actions) - 1; idx >= 0; idx-- {
+		if n.actions[idx].CmdType == cmdType {
+			return n.actions[idx]
+		}
+	}
+	t.Fatalf("no %s notification in %v", cmdType, n.actions)
+	return DeployAction{}
+}
+
+func testUi() cli.ColoredUi {
+	return cli.ColoredUi{Ui: &cli.MockUi{}}
+}
+
+var testApp = core.SuripuApp{
+	Name:                  "suripu-app",
+	SecurityGroup:         "sg-suripu-app",
+	InstanceType:          "m3.medium",
+	InstanceProfile:       "suripu-app",
+	TargetDesiredCapacity: 2,
+	PackagePath:           "com/hello/suripu",
+}
+
+func testEnv(t *testing.T, name string) *core.Environment {
+	env, err := core.DefaultEnvironments.Get(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return env
+}
+
+// putPackage uploads the package of version of testApp in env and its
+// .sha256, and returns the checksum.
+func putPackage(cloud *fakes.Cloud, env *core.Environment, version string) string {
+	body := []byte(fmt.Sprintf("suripu-app %s", version))
+	checksum := fmt.Sprintf("%x", sha256.Sum256(body))
+	key := core.PackageKey(&testApp, env, version)
+	cloud.PutObject(core.PackageBucket, key, body)
+	cloud.PutObject(core.PackageBucket, key+".sha256", []byte(fmt.Sprintf("%s  suripu-app_%s_amd64.deb\n", checksum, version)))
+	return checksum
+}
+
+// expectRun fails the test when the command didn't exit with want. ui is
+// the one of the command, its output is shown on failure.
+func expectRun(t *testing.T, c cli.Command, ui cli.ColoredUi, want int, args ...string) {
+	mock := ui.Ui.(*cli.MockUi)
+	if got := c.Run(args); got != want {
+		t.Fatalf("Run(%v) = %d, want %d\nstdout:\n%s\nstderr:\n%s", args, got, want, mock.OutputWriter.String(), mock.ErrorWriter.String())
+	}
+}
+
+func expectCapacity(t *testing.T, cloud *fakes.Cloud, asgName string, desired, min, max int64) {
+	group, ok := cloud.Groups[asgName]
+	if !ok {
+		t.Fatalf("no ASG %s", asgName)
+	}
+	if *group.DesiredCapacity != desired || *group.MinSize != min || *group.MaxSize != max {
+		t.Errorf("%s desired/min/max = %d/%d/%d, want %d/%d/%d", asgName, *group.DesiredCapacity, *group.MinSize, *group.MaxSize, desired, min, max)
+	}
+	if int64(len(group.Instances)) != desired {
+		t.Errorf("%s has %d instances, want %d", asgName, len(group.Instances), desired)
+	}
+}
+
+func expectLaunchRef(t *testing.T, cloud *fakes.Cloud, asgName, want string) {
+	if got := core.GroupLaunchRef(cloud.Groups[asgName]); got != want {
+		t.Errorf("%s launches %s, want %s", asgName, got, want)
+	}
+}
+
+// expectTags checks the ASG has every tag in want, propagated to instances.
+func expectTags(t *testing.T, cloud *fakes.Cloud, asgName string, want map[string]string) {
+	tags := make(map[string]string)
+	for _, tag := range cloud.Groups[asgName].Tags {
+		if !aws.BoolValue(tag.PropagateAtLaunch) {
+			continue
+		}
+		tags[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+	}
+	for key, value := range want {
+		if tags[key] != value {
+			t.Errorf("%s tag %q = %q, want %q", asgName, key, tags[key], value)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package command
+
+import (
+	"flag"
+	"fmt"
+	"github.com/hello/sanders/core"
+	"github.com/mitchellh/cli"
+	"strings"
+	"time"
+)
+
+// HistoryNotifier records every finished action in the audit log. It goes
+// first so other notifiers can link to the entry.
+type HistoryNotifier struct {
+	Ui       cli.ColoredUi
+	Store    core.HistoryStore
+	Settings *core.HistorySettings
+	username string
+}
+
+func NewHistoryNotifier(ui cli.ColoredUi, store core.HistoryStore, settings *core.HistorySettings, username string) *HistoryNotifier {
+	return &HistoryNotifier{Ui: ui, Store: store, Settings: settings, username: username}
+}
+
+func (n *HistoryNotifier) Notify(action *DeployAction) error {
+	action.HistoryLink = n.Settings.Link(action.Id)
+	if !action.Finished() {
+		return nil
+	}
+
+	entry := &core.HistoryEntry{
+		Id:             action.Id,
+		At:             action.At,
+		User:           n.username,
+		Type:           action.CmdType,
+		App:            action.AppName,
+		Env:            action.Env,
+		Asg:            action.Asg,
+		LC:             action.LC,
+		PreviousLC:     action.PreviousLC,
+		CapacityBefore: action.CapacityBefore,
+		CapacityAfter:  action.NumServers,
+		Duration:       action.Duration.String(),
+		Health:         action.Health,
+		Outcome:        action.Outcome,
+		Error:          action.Error,
+	}
+
+	if err := n.Store.Append(entry); err != nil {
+		n.Ui.Error(fmt.Sprintf("Failed to record %s of %s in history: %s", action.CmdType, action.AppName, err))
+		return err
+	}
+	return nil
+}
+
+// MultiNotifier sends every action to all its notifiers, even when one of
+// them fails.
+type MultiNotifier []BasicNotifier
+
+func (m MultiNotifier) Notify(action *DeployAction) error {
+	var firstErr error
+	for _, notifier := range m {
+		if err := notifier.Notify(action); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+type HistoryCommand struct {
+	Ui    cli.ColoredUi
+	Store core.HistoryStore
+}
+
+func (c *HistoryCommand) Help() string {
+	helpText := `Usage: sanders history [-app name] [-env name] [-limit 20] [-id id]
+
+	Lists what was deployed, by whom and when, newest first.
+
+	-id	Show the details of one entry, as linked from notifications.
+	-app	Only show this app.
+	-env	Only show this environment.
+	-limit	Number of entries to show, 0 for all (default 20).`
+	return strings.TrimSpace(helpText)
+}
+
+func (c *HistoryCommand) Run(args []string) int {
+	cmdFlags := flag.NewFlagSet("history", flag.ContinueOnError)
+	cmdFlags.Usage = func() { c.Ui.Output(c.Help()) }
+	appName := cmdFlags.String("app", "", "app to show")
+	envName := cmdFlags.String("env", "", "environment to show")
+	limit := cmdFlags.Int("limit", 20, "number of entries")
+	id := cmdFlags.String("id", "", "entry to show")
+	if err := cmdFlags.Parse(args); err != nil {
+		c.Ui.Error(fmt.Sprintf("%s", err))
+		return 1
+	}
+
+	entries, err := c.Store.List(&core.HistoryFilter{
+		Id:    *id,
+		App:   *appName,
+		Env:   *envName,
+		Limit: *limit,
+	})
+	if err != nil {
+		c.Ui.Error(err.Error())
+		return 1
+	}
+
+	if len(entries) == 0 {
+		c.Ui.Warn("No history found.")
+		return 0
+	}
+
+	if *id != "" {
+		c.showEntry(&entries[0])
+		return 0
+	}
+
+	c.Ui.Info(fmt.Sprintf("%-20s\t%-12s\t%-9s\t%-24s\t%-8s\t%-28s\t%-36s\t%s", "When:", "Who:", "Type:", "App:", "Env:", "ASG:", "LC:", "Capacity:"))
+	for _, entry := range entries {
+		line := fmt.Sprintf("%-20s\t%-12s\t%-9s\t%-24s\t%-8s\t%-28s\t%-36s\t%d -> %d",
+			entry.At.Local().Format(time.RFC822), entry.User, entry.Type, entry.App, entry.Env, entry.Asg, entry.LC, entry.CapacityBefore, entry.CapacityAfter)
+
+		switch entry.Outcome {
+		case OutcomeFailure:
+			c.Ui.Error(fmt.Sprintf("%s\tFAILED: %s", line, entry.Error))
+		case OutcomeRolledBack:
+			c.Ui.Warn(fmt.Sprintf("%s\tROLLED BACK: %s", line, entry.Error))
+		default:
+			c.Ui.Output(line)
+		}
+	}
+	return 0
+}
+
+func (c *HistoryCommand) showEntry(entry *core.HistoryEntry) {
+	fields := [][2]string{
+		{"Id", entry.Id},
+		{"When", entry.At.Local().Format(time.RFC822)},
+		{"Who", entry.User},
+		{"Type", entry.Type},
+		{"App", entry.App},
+		{"Env", entry.Env},
+		{"ASG", entry.Asg},
+		{"LC", entry.LC},
+		{"Previous LC", entry.PreviousLC},
+		{"Capacity", fmt.Sprintf("%d -> %d", entry.CapacityBefore, entry.CapacityAfter)},
+		{"Duration", entry.Duration},
+		{"Health", entry.Health},
+		{"Outcome", entry.Outcome},
+		{"Error", entry.Error},
+	}
+	for _, field := range fields {
+		if field[1] != "" {
+			c.Ui.Output(fmt.Sprintf("%-12s\t%s", field[0]+":", field[1]))
+		}
+	}
+}
+
+func (c *HistoryCommand) Synopsis() string {
+	return "Shows who deployed what and when"
+}
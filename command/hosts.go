@@ -4,7 +4,6 @@ import (
 	"flag"
 	"fmt"
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/autoscaling"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/hello/sanders/core"
@@ -16,92 +15,178 @@ import (
 
 type HostsCommand struct {
 	Ui       cli.ColoredUi
+	Aws      *core.Clients
 	Notifier BasicNotifier
 	Apps     []core.SuripuApp
+	Envs     core.Environments
 }
 
 func (c *HostsCommand) Help() string {
-	helpText := `Usage: sanders hosts [-nosync]`
+	helpText := `Usage: sanders hosts [-env prod] [-nosync] [-format text]
+	-nosync	Don't write the dsh groups in ~/.dsh/group.
+	` + outputFlagsHelp
 	return strings.TrimSpace(helpText)
 }
 
+// AsgHosts are the instances of one ASG.
+type AsgHosts struct {
+	AsgName string `json:"asg"`
+	LCName  string `json:"launch_configuration"`
+	Hosts   []Host `json:"hosts"`
+}
+
+type Host struct {
+	InstanceId     string `json:"instance_id"`
+	PublicDnsName  string `json:"public_dns_name"`
+	PrivateDnsName string `json:"private_dns_name"`
+}
+
+// HostList is what hosts prints, one row per instance in tables.
+type HostList []AsgHosts
+
+func (l HostList) Header() []string {
+	return []string{"ASG", "LC", "ID", "Public DNS", "Private DNS"}
+}
+
+func (l HostList) Rows() [][]string {
+	rows := make([][]string, 0)
+	for _, asg := range l {
+		for _, host := range asg.Hosts {
+			rows = append(rows, []string{asg.AsgName, asg.LCName, host.InstanceId, host.PublicDnsName, host.PrivateDnsName})
+		}
+	}
+	return rows
+}
+
 func (c *HostsCommand) Run(args []string) int {
 
 	cmdFlags := flag.NewFlagSet("hosts", flag.ContinueOnError)
 	cmdFlags.Usage = func() { c.Ui.Output(c.Help()) }
+	envName := envFlag(cmdFlags, "prod")
 	var nosync = cmdFlags.Bool("nosync", false, "disable syncing dsh groupnames")
+	output := addOutputFlags(cmdFlags)
 	if err := cmdFlags.Parse(args); err != nil {
 		c.Ui.Error(fmt.Sprintf("%s", err))
 		return 1
 	}
+	if err := output.validate(); err != nil {
+		c.Ui.Error(err.Error())
+		return 1
+	}
 
-	config := &aws.Config{
-		Region: aws.String("us-east-1"),
+	env, err := c.Envs.Get(*envName)
+	if err != nil {
+		c.Ui.Error(err.Error())
+		return 1
 	}
 
-	service := autoscaling.New(session.New(), config)
-	ec2Service := ec2.New(session.New(), config)
+	service := c.Aws.AutoScaling
+	ec2Service := c.Aws.EC2
 
 	groupnames := make([]*string, 0)
-	for _, app := range c.Apps {
-		one := fmt.Sprintf("%s-prod", app.Name)
-		two := fmt.Sprintf("%s-prod-green", app.Name)
-		groupnames = append(groupnames, &one)
-		groupnames = append(groupnames, &two)
+	for idx := range c.Apps {
+		groupnames = append(groupnames, env.GroupNames(&c.Apps[idx])...)
 	}
 
 	req := &autoscaling.DescribeAutoScalingGroupsInput{
 		AutoScalingGroupNames: groupnames,
 	}
 
-	resp, _ := service.DescribeAutoScalingGroups(req)
+	resp, err := service.DescribeAutoScalingGroups(req)
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("%s", err))
+		return 1
+	}
 
+	hosts := make(HostList, 0)
 	for _, asg := range resp.AutoScalingGroups {
-		instanceIds := make([]*string, 0)
+		asgHosts := AsgHosts{
+			AsgName: *asg.AutoScalingGroupName,
+			LCName:  core.GroupLaunchRef(asg),
+			Hosts:   make([]Host, 0),
+		}
 
+		instanceIds := make([]*string, 0)
 		for _, instance := range asg.Instances {
 			instanceIds = append(instanceIds, instance.InstanceId)
 		}
 
-		if len(instanceIds) == 0 {
-			c.Ui.Warn(fmt.Sprintf("No instance for ASG: %s\n", *asg.AutoScalingGroupName))
-			continue
+		if len(instanceIds) > 0 {
+			describeReq := &ec2.DescribeInstancesInput{
+				InstanceIds: instanceIds,
+			}
+
+			describeResp, err := ec2Service.DescribeInstances(describeReq)
+			if err != nil {
+				c.Ui.Error(fmt.Sprintf("%s", err))
+				return 1
+			}
+
+			for _, reservation := range describeResp.Reservations {
+				for _, instance := range reservation.Instances {
+					asgHosts.Hosts = append(asgHosts.Hosts, Host{
+						InstanceId:     *instance.InstanceId,
+						PublicDnsName:  aws.StringValue(instance.PublicDnsName),
+						PrivateDnsName: aws.StringValue(instance.PrivateDnsName),
+					})
+				}
+			}
 		}
+		hosts = append(hosts, asgHosts)
+	}
 
-		c.Ui.Info(fmt.Sprintf("ASG: %s [%s]", *asg.AutoScalingGroupName, *asg.LaunchConfigurationName))
+	err = output.render(c.Ui, hosts, func() {
+		for _, asg := range hosts {
+			if len(asg.Hosts) == 0 {
+				c.Ui.Warn(fmt.Sprintf("No instance for ASG: %s\n", asg.AsgName))
+				continue
+			}
 
-		describeReq := &ec2.DescribeInstancesInput{
-			InstanceIds: instanceIds,
+			c.Ui.Info(fmt.Sprintf("ASG: %s [%s]", asg.AsgName, asg.LCName))
+			for _, host := range asg.Hosts {
+				c.Ui.Error(fmt.Sprintf("\t%s", host.PublicDnsName))
+			}
+			c.Ui.Info("")
 		}
+		c.Ui.Output("")
+	})
+	if err != nil {
+		c.Ui.Error(err.Error())
+		return 1
+	}
+
+	if !*nosync {
+		c.syncDsh(hosts, output.text())
+	}
+	return 0
+}
 
-		describeResp, err := ec2Service.DescribeInstances(describeReq)
-		if err != nil {
-			c.Ui.Error(fmt.Sprintf("%s", err))
-			return 1
+// syncDsh writes the public names of each ASG's instances to its dsh group.
+// It only reports what it did when verbose, so the table, json and yaml
+// formats print their document alone.
+func (c *HostsCommand) syncDsh(hosts HostList, verbose bool) {
+	homedir := os.Getenv("HOME")
+	for _, asg := range hosts {
+		if len(asg.Hosts) == 0 {
+			continue
 		}
 
 		content := ""
-		for _, reservation := range describeResp.Reservations {
-			for _, instance := range reservation.Instances {
-				content += fmt.Sprintf("%s\n", *instance.PublicDnsName)
-				c.Ui.Error(fmt.Sprintf("\t%s", *instance.PublicDnsName))
-			}
+		for _, host := range asg.Hosts {
+			content += fmt.Sprintf("%s\n", host.PublicDnsName)
 		}
-		if !*nosync {
-			homedir := os.Getenv("HOME")
-			filePath := homedir + "/.dsh/group/" + *asg.AutoScalingGroupName
-			err = ioutil.WriteFile(filePath, []byte(content), 0644)
-			if err != nil {
-				c.Ui.Info(fmt.Sprintf("Failed saving file %s. %s", *asg.AutoScalingGroupName, err))
+
+		filePath := homedir + "/.dsh/group/" + asg.AsgName
+		if err := ioutil.WriteFile(filePath, []byte(content), 0644); err != nil {
+			if verbose {
+				c.Ui.Warn(fmt.Sprintf("Failed saving file %s. %s", asg.AsgName, err))
 			}
+			continue
+		}
+		if verbose {
 			c.Ui.Output(fmt.Sprintf("Saved to :%s", filePath))
 		}
-
-		c.Ui.Info("")
 	}
-
-	c.Ui.Output("")
-	return 0
 }
 
 func (c *HostsCommand) Synopsis() string {
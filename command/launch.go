@@ -2,6 +2,7 @@ package command
 
 import (
 	"encoding/base64"
+	"flag"
 	"fmt"
 	"github.com/hello/sanders/core"
 	"github.com/mitchellh/cli"
@@ -16,27 +17,49 @@ type LaunchCommand struct {
 	KeyService   core.KeyService
 	Apps         []core.SuripuApp
 	FleetManager *core.FleetManager
+	Envs         core.Environments
+	Lock         *Locking
 }
 
 func (c *LaunchCommand) Help() string {
-	helpText := `Usage: sanders launch-spot`
+	helpText := `Usage: sanders launch-spot [-env prod] [-app name] [-version version] [-base-ami ami] [-yes]
+	-base-ami	Base AMI id, or name pattern to take the latest of, instead
+			of the one of the app and environment.
+	` + planFlagsHelp
 	return strings.TrimSpace(helpText)
 }
 
 func (c *LaunchCommand) Run(args []string) int {
 
-	environment := "prod"
+	cmdFlags := flag.NewFlagSet("launch-spot", flag.ContinueOnError)
+	cmdFlags.Usage = func() { c.Ui.Output(c.Help()) }
+	envName := envFlag(cmdFlags, "prod")
+	appName := cmdFlags.String("app", "", "app to launch")
+	version := cmdFlags.String("version", "", "package version")
+	baseAmi := cmdFlags.String("base-ami", "", "base AMI id or name pattern")
+	yes := cmdFlags.Bool("yes", false, "don't ask for confirmation")
+	planFlags := addPlanFlags(cmdFlags)
+	if err := cmdFlags.Parse(args); err != nil {
+		c.Ui.Error(fmt.Sprintf("%s", err))
+		return 1
+	}
 
-	c.Ui.Output(fmt.Sprintf("Creating LC for %s environment.\n", environment))
+	env, err := c.Envs.Get(*envName)
+	if err != nil {
+		c.Ui.Error(err.Error())
+		return 1
+	}
 
-	appSelector := core.NewCliAppSelector(c.Ui)
+	c.Ui.Output(fmt.Sprintf("Creating LC for %s environment.\n", env.Name))
+
+	appSelector := core.NewAppSelector(c.Ui, *appName)
 	selectedApp, err := appSelector.Choose(c.Apps)
 	if err != nil {
 		c.Ui.Error(err.Error())
 		return 1
 	}
 
-	selectedAmi, err := c.AmiSelector.Select(*selectedApp, environment)
+	selectedAmi, err := c.AmiSelector.Select(*selectedApp, env, *version, *baseAmi)
 
 	if err != nil {
 		c.Ui.Error(err.Error())
@@ -48,60 +71,79 @@ func (c *LaunchCommand) Run(args []string) int {
 
 	emergencyText := "-spot"
 
-	launchConfigName := fmt.Sprintf("%s-%s-%s%s", selectedApp.Name, environment, selectedAmi.Version, emergencyText)
+	launchConfigName := env.LaunchConfigName(selectedApp, selectedAmi.Version+emergencyText)
 
 	//Create deployment-specific KeyPair
 
 	keyName := fmt.Sprintf("%s-%d", launchConfigName, time.Now().Unix())
 
-	keyUploadResults, err := c.KeyService.Upload(keyName, *selectedApp, environment)
+	config, err := c.FleetManager.Create(selectedApp, selectedAmi, keyName)
 	if err != nil {
 		c.Ui.Error(err.Error())
 		return 1
 	}
 
-	c.Ui.Info(fmt.Sprintf("Created KeyPair: %s. \n", keyUploadResults.KeyName))
+	decoded, _ := base64.RawStdEncoding.DecodeString(selectedAmi.UserData)
+	c.Ui.Info(fmt.Sprintf("%s", decoded))
 
-	config, err := c.FleetManager.Create(selectedApp, selectedAmi, keyUploadResults.KeyName)
+	plan := core.NewPlan("launch-spot", selectedApp.Name, env.Name)
+	plan.Add(core.Change{
+		Resource: "key_pair",
+		Name:     keyName,
+		Action:   core.ActionCreate,
+	})
+	plan.Add(withBaseAmi(spotFleetChange(launchConfigName, config), selectedAmi))
+	stop, err := planFlags.show(c.Ui, plan)
 	if err != nil {
 		c.Ui.Error(err.Error())
-		c.Cleanup(keyUploadResults)
 		return 1
 	}
+	if stop {
+		return 0
+	}
 
-	deployAction := NewDeployAction("launch", selectedApp.Name, launchConfigName, 0)
-
-	c.Ui.Info(fmt.Sprint("Creating Spot Fleet request with the following parameters:"))
-	c.Ui.Info(fmt.Sprintf("%s", config))
-
-	decoded, _ := base64.RawStdEncoding.DecodeString(selectedAmi.UserData)
-	c.Ui.Info(fmt.Sprintf("%s", decoded))
+	unlock, err := c.Lock.acquire(c.Ui, selectedApp, env, "launch-spot", 0)
+	if err != nil {
+		c.Ui.Error(err.Error())
+		return 1
+	}
+	defer unlock()
 
-	ok, err := c.Ui.Ask("'ok' if you agree, anything else to cancel: ")
+	ok, err := approve(c.Ui, env, *yes, "'ok' if you agree, anything else to cancel: ")
 	if err != nil {
 		c.Ui.Error(fmt.Sprintf("%s", err))
-		c.Cleanup(keyUploadResults)
 		return 1
 	}
 
-	if ok != "ok" {
+	if !ok {
 		c.Ui.Warn("Cancelled.")
-		if !c.Cleanup(keyUploadResults) {
-			return 1
-		}
 		return 0
 	}
 
+	deployAction := NewDeployAction("launch", selectedApp.Name, launchConfigName, 0)
+	deployAction.Env = env.Name
+	c.Notifier.Notify(deployAction)
+
+	keyUploadResults, err := c.KeyService.Upload(keyName, *selectedApp, env.Name)
+	if err != nil {
+		c.Notifier.Notify(deployAction.Failed(err))
+		c.Ui.Error(err.Error())
+		return 1
+	}
+
+	c.Ui.Info(fmt.Sprintf("Created KeyPair: %s. \n", keyUploadResults.KeyName))
+
 	requestId, err := c.FleetManager.Execute(config)
 
 	if err != nil {
+		c.Notifier.Notify(deployAction.Failed(err))
 		// Message from an error.
 		c.Ui.Error(fmt.Sprintf("Failed to create Spot Fleet request: %s", err))
 		c.Cleanup(keyUploadResults)
 		return 1
 	}
 
-	c.Notifier.Notify(deployAction)
+	c.Notifier.Notify(deployAction.Succeeded())
 	c.Ui.Output(fmt.Sprintf("Spot Fleet request %s was successfully created", requestId))
 
 	return 0
@@ -0,0 +1,118 @@
+package command
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/hello/sanders/core"
+)
+
+// templateVersion is the next version of the launch template of an app,
+// running a selected AMI.
+type templateVersion struct {
+	name        string
+	description string
+	number      int64
+	exists      bool
+	data        *ec2.RequestLaunchTemplateData
+	ami         *core.SelectedAmi
+}
+
+// newTemplateVersion prepares a version of the launch template of app in env
+// running ami. The description is the app version so confirm -version finds
+// it.
+func newTemplateVersion(service ec2iface.EC2API, env *core.Environment, app *core.SuripuApp, ami *core.SelectedAmi, description, keyName string) (*templateVersion, error) {
+	name := env.LaunchTemplateName(app)
+
+	versions, err := core.LaunchTemplateVersions(service, name)
+	if err != nil {
+		return nil, err
+	}
+
+	number := int64(1)
+	for _, existing := range versions {
+		if *existing.VersionNumber >= number {
+			number = *existing.VersionNumber + 1
+		}
+	}
+
+	data := &ec2.RequestLaunchTemplateData{
+		ImageId:      aws.String(ami.Id),
+		InstanceType: aws.String(app.InstanceType),
+		KeyName:      aws.String(keyName),
+		IamInstanceProfile: &ec2.LaunchTemplateIamInstanceProfileSpecificationRequest{
+			Name: aws.String(app.InstanceProfile),
+		},
+		Monitoring: &ec2.LaunchTemplatesMonitoringRequest{
+			Enabled: aws.Bool(true),
+		},
+		NetworkInterfaces: []*ec2.LaunchTemplateInstanceNetworkInterfaceSpecificationRequest{{
+			AssociatePublicIpAddress: aws.Bool(true),
+			DeviceIndex:              aws.Int64(0),
+			Groups:                   []*string{aws.String(app.SecurityGroup)},
+		}},
+		UserData: aws.String(ami.UserData),
+	}
+	if ami.Package != nil && ami.Package.Sha256 != "" {
+		data.TagSpecifications = []*ec2.LaunchTemplateTagSpecificationRequest{{
+			ResourceType: aws.String(ec2.ResourceTypeInstance),
+			Tags: []*ec2.Tag{{
+				Key:   aws.String(core.PackageChecksumTag),
+				Value: aws.String(ami.Package.Sha256),
+			}},
+		}}
+	}
+
+	return &templateVersion{
+		name:        name,
+		description: description,
+		number:      number,
+		exists:      versions != nil,
+		data:        data,
+		ami:         ami,
+	}, nil
+}
+
+// ref is the reference the version will have once created.
+func (t *templateVersion) ref() string {
+	return core.LaunchTemplateRef(t.name, t.number)
+}
+
+// addTo adds the creation of the version, and of the template on first use,
+// to plan.
+func (t *templateVersion) addTo(plan *core.Plan) {
+	if !t.exists {
+		plan.Add(core.Change{
+			Resource: "launch_template",
+			Name:     t.name,
+			Action:   core.ActionCreate,
+		})
+	}
+	plan.Add(withBaseAmi(launchTemplateVersionChange(t.ref(), t.description, t.data), t.ami))
+}
+
+// create creates the version, and the template on first use, and returns the
+// reference of the version EC2 created.
+func (t *templateVersion) create(service ec2iface.EC2API) (string, error) {
+	if !t.exists {
+		resp, err := service.CreateLaunchTemplate(&ec2.CreateLaunchTemplateInput{
+			LaunchTemplateName: aws.String(t.name),
+			VersionDescription: aws.String(t.description),
+			LaunchTemplateData: t.data,
+		})
+		if err != nil {
+			return "", err
+		}
+		return core.LaunchTemplateRef(t.name, *resp.LaunchTemplate.LatestVersionNumber), nil
+	}
+
+	resp, err := service.CreateLaunchTemplateVersion(&ec2.CreateLaunchTemplateVersionInput{
+		LaunchTemplateName: aws.String(t.name),
+		VersionDescription: aws.String(t.description),
+		LaunchTemplateData: t.data,
+	})
+	if err != nil {
+		return "", err
+	}
+	return core.LaunchTemplateRef(t.name, *resp.LaunchTemplateVersion.VersionNumber), nil
+}
@@ -0,0 +1,213 @@
+package command
+
+import (
+	"flag"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/hello/sanders/core"
+	"github.com/mitchellh/cli"
+	"sort"
+	"strings"
+	"time"
+)
+
+type LCListCommand struct {
+	Ui   cli.ColoredUi
+	Aws  *core.Clients
+	Apps []core.SuripuApp
+	Envs core.Environments
+}
+
+func (c *LCListCommand) Help() string {
+	helpText := `Usage: sanders lc list [-app name] [-env prod] [-limit 5] [-format text]
+
+	Lists the launch configurations and launch template versions of the apps
+	in an environment, newest first, and the ASG using each of them.
+
+	-app	Only list this app.
+	-limit	Launch configurations per app, 0 for all (default 5).
+	` + outputFlagsHelp
+	return strings.TrimSpace(helpText)
+}
+
+// LaunchConfig is a launch configuration, or a launch template version, of
+// an app.
+type LaunchConfig struct {
+	App          string    `json:"app"`
+	Name         string    `json:"name"`
+	Description  string    `json:"description,omitempty"`
+	ImageId      string    `json:"image_id"`
+	InstanceType string    `json:"instance_type"`
+	Created      time.Time `json:"created"`
+	UsedBy       string    `json:"used_by,omitempty"`
+}
+
+// LaunchConfigList is what lc list prints.
+type LaunchConfigList []LaunchConfig
+
+func (l LaunchConfigList) Header() []string {
+	return []string{"App", "Name", "Description", "Image", "Type", "Created", "Used by"}
+}
+
+func (l LaunchConfigList) Rows() [][]string {
+	rows := make([][]string, 0)
+	for _, lc := range l {
+		rows = append(rows, []string{lc.App, lc.Name, lc.Description, lc.ImageId, lc.InstanceType, lc.Created.Local().Format(time.RFC822), lc.UsedBy})
+	}
+	return rows
+}
+
+func (c *LCListCommand) Run(args []string) int {
+	cmdFlags := flag.NewFlagSet("lc list", flag.ContinueOnError)
+	cmdFlags.Usage = func() { c.Ui.Output(c.Help()) }
+	appName := cmdFlags.String("app", "", "app to list")
+	envName := envFlag(cmdFlags, "prod")
+	limit := cmdFlags.Int("limit", 5, "launch configurations per app")
+	output := addOutputFlags(cmdFlags)
+	if err := cmdFlags.Parse(args); err != nil {
+		c.Ui.Error(fmt.Sprintf("%s", err))
+		return 1
+	}
+	if err := output.validate(); err != nil {
+		c.Ui.Error(err.Error())
+		return 1
+	}
+
+	env, err := c.Envs.Get(*envName)
+	if err != nil {
+		c.Ui.Error(err.Error())
+		return 1
+	}
+
+	apps := make([]*core.SuripuApp, 0)
+	for idx := range c.Apps {
+		if *appName == "" || c.Apps[idx].Name == *appName {
+			apps = append(apps, &c.Apps[idx])
+		}
+	}
+	if len(apps) == 0 {
+		c.Ui.Error(fmt.Sprintf("Unknown app: %s", *appName))
+		return 1
+	}
+
+	service := c.Aws.AutoScaling
+
+	groupNames := make([]*string, 0)
+	for _, app := range apps {
+		groupNames = append(groupNames, env.GroupNames(app)...)
+	}
+	asgResp, err := service.DescribeAutoScalingGroups(&autoscaling.DescribeAutoScalingGroupsInput{
+		AutoScalingGroupNames: groupNames,
+	})
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("%s", err))
+		return 1
+	}
+	usedBy := make(map[string]string)
+	for _, asg := range asgResp.AutoScalingGroups {
+		usedBy[core.GroupLaunchRef(asg)] = *asg.AutoScalingGroupName
+	}
+
+	perApp := make(map[string][]*autoscaling.LaunchConfiguration)
+	err = service.DescribeLaunchConfigurationsPages(&autoscaling.DescribeLaunchConfigurationsInput{
+		MaxRecords: aws.Int64(100),
+	}, func(page *autoscaling.DescribeLaunchConfigurationsOutput, lastPage bool) bool {
+		for _, lc := range page.LaunchConfigurations {
+			for _, app := range apps {
+				if strings.HasPrefix(*lc.LaunchConfigurationName, env.LaunchConfigName(app, "")) {
+					perApp[app.Name] = append(perApp[app.Name], lc)
+				}
+			}
+		}
+		return true
+	})
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("%s", err))
+		return 1
+	}
+
+	lcs := make(LaunchConfigList, 0)
+	for _, app := range apps {
+		appLCs := make(LaunchConfigList, 0)
+		for _, lc := range perApp[app.Name] {
+			appLCs = append(appLCs, LaunchConfig{
+				App:          app.Name,
+				Name:         *lc.LaunchConfigurationName,
+				ImageId:      aws.StringValue(lc.ImageId),
+				InstanceType: aws.StringValue(lc.InstanceType),
+				Created:      aws.TimeValue(lc.CreatedTime),
+				UsedBy:       usedBy[*lc.LaunchConfigurationName],
+			})
+		}
+
+		versions, err := core.LaunchTemplateVersions(c.Aws.EC2, env.LaunchTemplateName(app))
+		if err != nil {
+			c.Ui.Error(fmt.Sprintf("%s", err))
+			return 1
+		}
+		for _, version := range versions {
+			ref := core.LaunchTemplateRef(*version.LaunchTemplateName, *version.VersionNumber)
+			lc := LaunchConfig{
+				App:         app.Name,
+				Name:        ref,
+				Description: aws.StringValue(version.VersionDescription),
+				Created:     aws.TimeValue(version.CreateTime),
+				UsedBy:      usedBy[ref],
+			}
+			if data := version.LaunchTemplateData; data != nil {
+				lc.ImageId = aws.StringValue(data.ImageId)
+				lc.InstanceType = aws.StringValue(data.InstanceType)
+			}
+			appLCs = append(appLCs, lc)
+		}
+
+		sort.Sort(sort.Reverse(byLaunchConfigTime(appLCs)))
+		if *limit > 0 && len(appLCs) > *limit {
+			appLCs = appLCs[:*limit]
+		}
+		lcs = append(lcs, appLCs...)
+	}
+
+	err = output.render(c.Ui, lcs, func() {
+		if len(lcs) == 0 {
+			c.Ui.Warn(fmt.Sprintf("No launch configuration found in %s.", env.Name))
+			return
+		}
+
+		c.Ui.Info(fmt.Sprintf("%-16s\t%-36s\t%-12s\t%-20s\t%s", "App:", "Name:", "Image:", "Created:", "Used by:"))
+		for _, lc := range lcs {
+			name := lc.Name
+			if lc.Description != "" {
+				name = fmt.Sprintf("%s (%s)", lc.Name, lc.Description)
+			}
+			line := fmt.Sprintf("%-16s\t%-36s\t%-12s\t%-20s\t%s", lc.App, name, lc.ImageId, lc.Created.Local().Format(time.RFC822), lc.UsedBy)
+			if lc.UsedBy != "" {
+				c.Ui.Info(line)
+			} else {
+				c.Ui.Output(line)
+			}
+		}
+	})
+	if err != nil {
+		c.Ui.Error(err.Error())
+		return 1
+	}
+	return 0
+}
+
+type byLaunchConfigTime LaunchConfigList
+
+func (s byLaunchConfigTime) Len() int {
+	return len(s)
+}
+func (s byLaunchConfigTime) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
+func (s byLaunchConfigTime) Less(i, j int) bool {
+	return s[i].Created.Before(s[j].Created)
+}
+
+func (c *LCListCommand) Synopsis() string {
+	return "Lists the launch configurations and launch template versions of each app"
+}
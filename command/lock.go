@@ -0,0 +1,180 @@
+package command
+
+import (
+	"flag"
+	"fmt"
+	"github.com/hello/sanders/core"
+	"github.com/mitchellh/cli"
+	"strings"
+	"time"
+)
+
+// Locking is what mutating commands need to take the lock of the app and
+// environment they operate on.
+type Locking struct {
+	Locker core.Locker
+	Owner  string
+	TTL    time.Duration
+}
+
+// acquire takes the lock of app in env for cmdName, held for the TTL plus
+// extra. The returned func releases it.
+func (l *Locking) acquire(ui cli.ColoredUi, app *core.SuripuApp, env *core.Environment, cmdName string, extra time.Duration) (func(), error) {
+	return l.acquireNamed(ui, app.Name, env.Name, cmdName, extra)
+}
+
+// acquireNamed takes a lock that isn't about an app in an environment, like
+// a user data template or a spot fleet request.
+func (l *Locking) acquireNamed(ui cli.ColoredUi, appName, envName, cmdName string, extra time.Duration) (func(), error) {
+	if l == nil {
+		return func() {}, nil
+	}
+
+	now := time.Now()
+	lock := &core.Lock{
+		App:        appName,
+		Env:        envName,
+		Owner:      l.Owner,
+		Command:    cmdName,
+		AcquiredAt: now,
+		ExpiresAt:  now.Add(l.TTL + extra),
+	}
+
+	if err := l.Locker.Acquire(lock); err != nil {
+		if held, ok := err.(*core.LockHeldError); ok {
+			return nil, fmt.Errorf("%s\nWait for it to finish, or run `sanders unlock -app %s -env %s` if it is stale.", held, appName, envName)
+		}
+		return nil, err
+	}
+
+	return func() {
+		if err := l.Locker.Release(lock); err != nil {
+			ui.Warn(fmt.Sprintf("Failed to release lock %s: %s", lock.Id(), err))
+		}
+	}, nil
+}
+
+type LockStatusCommand struct {
+	Ui     cli.ColoredUi
+	Locker core.Locker
+}
+
+func (c *LockStatusCommand) Help() string {
+	helpText := `Usage: sanders lock status [-app name] [-env name]
+
+	Lists the apps currently locked by a sanders command.`
+	return strings.TrimSpace(helpText)
+}
+
+func (c *LockStatusCommand) Run(args []string) int {
+	cmdFlags := flag.NewFlagSet("lock status", flag.ContinueOnError)
+	cmdFlags.Usage = func() { c.Ui.Output(c.Help()) }
+	appName := cmdFlags.String("app", "", "only show this app")
+	envName := cmdFlags.String("env", "", "only show this environment")
+	if err := cmdFlags.Parse(args); err != nil {
+		c.Ui.Error(fmt.Sprintf("%s", err))
+		return 1
+	}
+
+	locks, err := c.Locker.List()
+	if err != nil {
+		c.Ui.Error(err.Error())
+		return 1
+	}
+
+	found := 0
+	for _, lock := range locks {
+		if *appName != "" && lock.App != *appName {
+			continue
+		}
+		if *envName != "" && lock.Env != *envName {
+			continue
+		}
+		found++
+
+		if lock.Expired() {
+			c.Ui.Output(fmt.Sprintf("%s (expired)", lock.String()))
+		} else {
+			c.Ui.Warn(lock.String())
+		}
+	}
+
+	if found == 0 {
+		c.Ui.Info("No locks held.")
+	}
+	return 0
+}
+
+func (c *LockStatusCommand) Synopsis() string {
+	return "Shows which apps are locked and by whom"
+}
+
+type UnlockCommand struct {
+	Ui     cli.ColoredUi
+	Locker core.Locker
+}
+
+func (c *UnlockCommand) Help() string {
+	helpText := `Usage: sanders unlock -app name [-env prod] [-yes]
+
+	Forcibly releases the lock of an app, e.g. after a crashed command.
+	Make sure nobody is still deploying it.`
+	return strings.TrimSpace(helpText)
+}
+
+func (c *UnlockCommand) Run(args []string) int {
+	cmdFlags := flag.NewFlagSet("unlock", flag.ContinueOnError)
+	cmdFlags.Usage = func() { c.Ui.Output(c.Help()) }
+	appName := cmdFlags.String("app", "", "app to unlock")
+	envName := envFlag(cmdFlags, "prod")
+	yes := cmdFlags.Bool("yes", false, "don't ask for confirmation")
+	if err := cmdFlags.Parse(args); err != nil {
+		c.Ui.Error(fmt.Sprintf("%s", err))
+		return 1
+	}
+
+	if *appName == "" {
+		c.Ui.Error("-app is required")
+		return 1
+	}
+
+	locks, err := c.Locker.List()
+	if err != nil {
+		c.Ui.Error(err.Error())
+		return 1
+	}
+
+	var held *core.Lock
+	for idx := range locks {
+		if locks[idx].App == *appName && locks[idx].Env == *envName {
+			held = &locks[idx]
+		}
+	}
+
+	if held == nil {
+		c.Ui.Info(fmt.Sprintf("%s/%s is not locked.", *appName, *envName))
+		return 0
+	}
+
+	c.Ui.Warn(held.String())
+	ok, err := askOk(c.Ui, *yes, "'ok' to release it, anything else to cancel: ")
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("%s", err))
+		return 1
+	}
+	if !ok {
+		c.Ui.Warn("Cancelled.")
+		return 0
+	}
+
+	if err := c.Locker.ForceRelease(*appName, *envName); err != nil {
+		c.Ui.Error(err.Error())
+		return 1
+	}
+	c.Ui.Info(fmt.Sprintf("Released %s", held.Id()))
+	return 0
+}
+
+func (c *UnlockCommand) Synopsis() string {
+	return "Forcibly releases the lock of an app"
+}
@@ -1,11 +1,8 @@
 package command
 
 import (
+	"flag"
 	"fmt"
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/ec2"
-	"github.com/aws/aws-sdk-go/service/elb"
 	"github.com/hello/sanders/core"
 	"github.com/mitchellh/cli"
 	"strconv"
@@ -15,52 +12,131 @@ import (
 
 type MonitorCommand struct {
 	Ui       cli.ColoredUi
+	Aws      *core.Clients
 	Notifier BasicNotifier
 	Apps     []core.SuripuApp
+	Envs     core.Environments
 }
 
 func (c *MonitorCommand) Help() string {
-	helpText := `Usage: sanders monitor`
+	helpText := `Usage: sanders monitor [-app name] [-env name] [-elb name] [-format text]
+
+	Prompts for one of the ELBs found from the ASGs of the apps, or for the
+	ASG of an app without load balancer, and shows its instances every 10
+	seconds.
+
+	-app	Only list the ELBs of this app.
+	-env	Only list the ELBs of the apps in this environment.
+	-elb	ELB, ALB or target group ARN to monitor. Prompts if omitted.
+	` + outputFlagsHelp + `
+		Other formats print one document every 10 seconds.`
 	return strings.TrimSpace(helpText)
 }
 
+// monitored is an ELB, or the ASG of an app without load balancer.
+type monitored struct {
+	label   string
+	app     string
+	env     string
+	elbName string
+	asgName string
+}
+
 func (c *MonitorCommand) Run(args []string) int {
 
-	config := &aws.Config{
-		Region: aws.String("us-east-1"),
+	cmdFlags := flag.NewFlagSet("monitor", flag.ContinueOnError)
+	cmdFlags.Usage = func() { c.Ui.Output(c.Help()) }
+	appName := cmdFlags.String("app", "", "app to list")
+	envName := envFlag(cmdFlags, "")
+	elbFlag := cmdFlags.String("elb", "", "elb to monitor")
+	output := addOutputFlags(cmdFlags)
+	if err := cmdFlags.Parse(args); err != nil {
+		c.Ui.Error(fmt.Sprintf("%s", err))
+		return 1
 	}
-
-	elbs := []string{
-		"suripu-app-prod",
-		"suripu-service-prod",
-		"suripu-app-canary",
-		"suripu-admin-prod",
-		"messeji-prod",
-		"hello-time-prod",
-		"taimurain-prod",
+	if err := output.validate(); err != nil {
+		c.Ui.Error(err.Error())
+		return 1
 	}
 
-	service := elb.New(session.New(), config)
-	ec2Service := ec2.New(session.New(), config)
+	lbs := c.Aws.LoadBalancers()
+	ec2Service := c.Aws.EC2
+	asgService := c.Aws.AutoScaling
 
-	for idx, elb := range elbs {
-		c.Ui.Output(fmt.Sprintf("[%d] %s", idx, elb))
-	}
+	selected := &monitored{elbName: *elbFlag}
+	if selected.elbName == "" {
+		targets, err := discoverTargets(asgService, c.Apps, c.Envs, *appName, *envName)
+		if err != nil {
+			c.Ui.Error(err.Error())
+			return 1
+		}
 
-	elbSel, err := c.Ui.Ask("Select an elb #: ")
-	elbIdx, _ := strconv.Atoi(elbSel)
+		choices := make([]*monitored, 0)
+		for _, target := range targets {
+			for _, elbName := range target.ElbNames {
+				choices = append(choices, &monitored{label: core.LoadBalancerLabel(elbName), app: target.App, env: target.Env, elbName: elbName})
+			}
+			if len(target.ElbNames) > 0 {
+				continue
+			}
+			labels := target.labels()
+			for idx, group := range target.Groups {
+				choices = append(choices, &monitored{label: labels[idx], app: target.App, env: target.Env, asgName: *group.AutoScalingGroupName})
+			}
+		}
 
-	if err != nil || elbIdx >= len(elbs) {
-		c.Ui.Error(fmt.Sprintf("Incorrect elb selection: %s\n", err))
-		return 1
-	}
+		if len(choices) == 0 {
+			c.Ui.Warn("No ASG found for the selected apps and environments.")
+			return 0
+		}
+
+		for idx, choice := range choices {
+			c.Ui.Output(fmt.Sprintf("[%d] %s", idx, choice.label))
+		}
+
+		elbSel, err := c.Ui.Ask("Select an elb #: ")
+		elbIdx, _ := strconv.Atoi(elbSel)
 
-	selectedElb := elbs[elbIdx]
+		if err != nil || elbIdx < 0 || elbIdx >= len(choices) {
+			c.Ui.Error(fmt.Sprintf("Incorrect elb selection: %s\n", elbSel))
+			return 1
+		}
+
+		selected = choices[elbIdx]
+	}
 
 	for {
-		status := elbStatus(selectedElb, service, ec2Service)
-		printStatus(c.Ui, status)
-		c.Ui.Output("\nSleeping for 10 seconds...\n")
+		var status *Status
+		if selected.elbName != "" {
+			status = elbStatus(selected.elbName, lbs, ec2Service)
+		} else {
+			groups, err := describeGroupsByName(asgService, []*string{&selected.asgName})
+			if err != nil {
+				c.Ui.Error(err.Error())
+				return 1
+			}
+			group, found := groups[selected.asgName]
+			if !found {
+				c.Ui.Error(fmt.Sprintf("ASG %s not found.", selected.asgName))
+				return 1
+			}
+			status = asgStatus(group, ec2Service)
+		}
+		if status.Error != nil {
+			c.Ui.Error(fmt.Sprintf("%s", status.Error))
+			return 1
+		}
+		status.App = selected.app
+		status.Env = selected.env
+
+		err := output.render(c.Ui, ElbStatuses{status}, func() {
+			printStatus(c.Ui, status)
+			c.Ui.Output("\nSleeping for 10 seconds...\n")
+		})
+		if err != nil {
+			c.Ui.Error(err.Error())
+			return 1
+		}
 		time.Sleep(10000 * time.Millisecond)
 	}
 
@@ -68,5 +144,5 @@ func (c *MonitorCommand) Run(args []string) int {
 }
 
 func (c *MonitorCommand) Synopsis() string {
-	return "Monitor ELB status"
+	return "Monitor the instances of an ELB, or of an ASG without one"
 }
@@ -0,0 +1,649 @@
+package command
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/hello/sanders/core"
+	"github.com/mitchellh/cli"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/smtp"
+	"os"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// SlackWebhookEnv is read for the Slack webhook URL when the config file
+// doesn't set one, so the URL stays out of source control.
+const SlackWebhookEnv = "SANDERS_SLACK_WEBHOOK"
+
+const defaultSMTPPasswordEnv = "SANDERS_SMTP_PASSWORD"
+
+var notifyClient = &http.Client{Timeout: 10 * time.Second}
+
+// NotifierFactory builds a notifier from its entry in the config file. user
+// is the IAM user running sanders.
+type NotifierFactory func(ui cli.ColoredUi, settings *core.NotifierSettings, user string) (BasicNotifier, error)
+
+var notifierFactories = map[string]NotifierFactory{
+	"slack":   newSlackFromSettings,
+	"webhook": newWebhookFromSettings,
+	"email":   newEmailFromSettings,
+	"stdout":  newStdoutFromSettings,
+	"file":    newFileFromSettings,
+}
+
+// RegisterNotifier makes a new notifier type available to the notifiers
+// section of the config file.
+func RegisterNotifier(notifierType string, factory NotifierFactory) {
+	notifierFactories[notifierType] = factory
+}
+
+// NotifierTypes lists the registered notifier types.
+func NotifierTypes() []string {
+	types := make([]string, 0)
+	for notifierType := range notifierFactories {
+		types = append(types, notifierType)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// NewNotifiers builds every notifier of the config file. Each one only sees
+// the environments it is routed to, and tells the user when it fails rather
+// than failing the command.
+func NewNotifiers(ui cli.ColoredUi, settings []core.NotifierSettings, user string) (MultiNotifier, error) {
+	notifiers := make(MultiNotifier, 0)
+	for idx := range settings {
+		s := &settings[idx]
+		factory, ok := notifierFactories[s.Type]
+		if !ok {
+			return nil, errors.New(fmt.Sprintf("Unknown notifier type %q for %s (%s)", s.Type, s.Name, strings.Join(NotifierTypes(), ", ")))
+		}
+
+		notifier, err := factory(ui, s, user)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Notifier %s: %s", s.Name, err))
+		}
+
+		notifiers = append(notifiers, &routedNotifier{
+			ui:       ui,
+			settings: s,
+			notifier: notifier,
+		})
+	}
+	return notifiers, nil
+}
+
+// routedNotifier skips actions in environments its notifier isn't routed to
+// and warns when the notifier fails.
+type routedNotifier struct {
+	ui       cli.ColoredUi
+	settings *core.NotifierSettings
+	notifier BasicNotifier
+}
+
+func (r *routedNotifier) Notify(action *DeployAction) error {
+	if !r.settings.Routes(action.Env) {
+		return nil
+	}
+	if err := r.notifier.Notify(action); err != nil {
+		r.ui.Warn(fmt.Sprintf("Failed to notify %s of %s of %s: %s", r.settings.Name, action.CmdType, action.AppName, err))
+		return err
+	}
+	return nil
+}
+
+// webhookURL is where a slack or webhook notifier posts: the url of its
+// settings, or the content of the url_env variable read when notifying so
+// that users without the secret can still run sanders.
+type webhookURL struct {
+	url     string
+	envName string
+}
+
+func newWebhookURL(settings *core.NotifierSettings, defaultEnv string) (*webhookURL, error) {
+	envName := settings.URLEnv
+	if envName == "" {
+		envName = defaultEnv
+	}
+	if settings.URL == "" && envName == "" {
+		return nil, errors.New("url or url_env is required")
+	}
+	return &webhookURL{url: settings.URL, envName: envName}, nil
+}
+
+func (w *webhookURL) resolve() (string, error) {
+	if w.url != "" {
+		return w.url, nil
+	}
+	if url := os.Getenv(w.envName); url != "" {
+		return url, nil
+	}
+	return "", errors.New(fmt.Sprintf("%s is not set", w.envName))
+}
+
+// postJSON sends body to endpoint and fails on anything but a 2xx.
+func postJSON(method string, endpoint *webhookURL, header map[string]string, body []byte) error {
+	url, err := endpoint.resolve()
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range header {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := notifyClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		detail, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return errors.New(fmt.Sprintf("%s returned %s: %s", req.URL.Host, resp.Status, strings.TrimSpace(string(detail))))
+	}
+	return nil
+}
+
+const slackPostMessageURL = "https://slack.com/api/chat.postMessage"
+
+// NewSlackNotifier posts to the Slack incoming webhook at endpoint.
+func NewSlackNotifier(username, endpoint string) *SlackNotifier {
+	return &SlackNotifier{
+		username: username,
+		endpoint: &webhookURL{url: endpoint},
+		threads:  make(map[string]string),
+	}
+}
+
+func newSlackFromSettings(ui cli.ColoredUi, settings *core.NotifierSettings, user string) (BasicNotifier, error) {
+	if settings.TokenEnv != "" && settings.Channel == "" {
+		return nil, errors.New("channel is required with token_env")
+	}
+
+	endpoint, err := newWebhookURL(settings, SlackWebhookEnv)
+	if err != nil {
+		return nil, err
+	}
+	return &SlackNotifier{
+		username: user,
+		endpoint: endpoint,
+		channel:  settings.Channel,
+		tokenEnv: settings.TokenEnv,
+		threads:  make(map[string]string),
+	}, nil
+}
+
+// SlackNotifier posts one message per stage of an action. With a bot token
+// and a channel it goes through the Web API so that the stages after the
+// first are threaded under it; incoming webhooks can't thread.
+type SlackNotifier struct {
+	username string
+	endpoint *webhookURL
+	channel  string
+	tokenEnv string
+	// threads is the ts of the first message of each action.
+	threads map[string]string
+}
+
+type Payload struct {
+	Channel     string       `json:"channel,omitempty"`
+	ThreadTs    string       `json:"thread_ts,omitempty"`
+	Text        string       `json:"text"`
+	Username    string       `json:"username"`
+	IconEmoji   string       `json:"icon_emoji"`
+	MarkDown    bool         `json:"mrkdwn"`
+	Attachments []Attachment `json:"attachments"`
+}
+
+type Field struct {
+	Title string `json:"title"`
+	Value string `json:"value"`
+	Short bool   `json:"short"`
+}
+
+type Attachment struct {
+	Fallback   string  `json:"fallback"`
+	Color      string  `json:"color"`
+	AuthorName string  `json:"author_name"`
+	Title      string  `json:"title"`
+	Text       string  `json:"text"`
+	Fields     []Field `json:"fields"`
+}
+
+var outcomeTitles = map[string]string{
+	OutcomeStarted:    "started",
+	OutcomeSuccess:    "succeeded",
+	OutcomeFailure:    "failed",
+	OutcomeRolledBack: "rolled back",
+}
+
+func (n *SlackNotifier) Notify(action *DeployAction) error {
+
+	actionColors := make(map[string]string)
+	actionColors["deploy"] = "good"
+	actionColors["confirm"] = "good"
+	actionColors["canary"] = "good"
+	actionColors["rollout"] = "good"
+	actionColors["create"] = "#764FA5"
+	actionColors["launch"] = "#439FE0"
+	actionColors["clean"] = "#9E9E9E"
+	actionColors["sunset"] = "warning"
+	actionColors["rollback"] = "danger"
+
+	fields := []Field{
+		Field{Title: "App", Value: action.AppName, Short: true},
+		Field{Title: "Version", Value: action.LC, Short: true},
+		Field{Title: "Type", Value: action.CmdType, Short: true},
+		Field{Title: "# servers", Value: fmt.Sprintf("%d", action.NumServers), Short: true},
+	}
+	if action.PreviousLC != "" {
+		fields = append(fields, Field{Title: "Previous version", Value: action.PreviousLC, Short: true})
+	}
+	if action.Env != "" {
+		fields = append(fields, Field{Title: "Env", Value: action.Env, Short: true})
+	}
+	if action.Asg != "" {
+		fields = append(fields, Field{Title: "ASG", Value: action.Asg, Short: true})
+	}
+	if action.Finished() {
+		fields = append(fields, Field{Title: "Duration", Value: formatDuration(action.Duration), Short: true})
+	}
+	if action.Health != "" {
+		fields = append(fields, Field{Title: "Health", Value: action.Health, Short: false})
+	}
+	if action.Error != "" {
+		fields = append(fields, Field{Title: "Outcome", Value: fmt.Sprintf("%s: %s", action.Outcome, action.Error), Short: false})
+	}
+	if action.HistoryLink != "" {
+		fields = append(fields, Field{Title: "History", Value: action.HistoryLink, Short: false})
+	}
+
+	color := actionColors[action.CmdType]
+	switch action.Outcome {
+	case OutcomeStarted:
+		color = "#CCCCCC"
+	case OutcomeFailure:
+		color = "danger"
+	case OutcomeRolledBack:
+		color = "warning"
+	}
+
+	singleAttachment := Attachment{
+		AuthorName: n.username,
+		Title:      fmt.Sprintf("%s of %s %s", action.CmdType, action.AppName, outcomeTitles[action.Outcome]),
+		Fields:     fields,
+		Color:      color,
+		Fallback:   action.FallbackString(),
+	}
+
+	payload := &Payload{
+		Username:    "sanders",
+		IconEmoji:   ":rocket:",
+		MarkDown:    true,
+		Attachments: []Attachment{singleAttachment},
+	}
+
+	token := ""
+	if n.tokenEnv != "" {
+		token = os.Getenv(n.tokenEnv)
+	}
+	if token == "" {
+		buff, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		return postJSON("POST", n.endpoint, nil, buff)
+	}
+
+	payload.Channel = n.channel
+	payload.ThreadTs = n.threads[action.Id]
+	ts, err := n.postMessage(token, payload)
+	if err != nil {
+		return err
+	}
+	if _, ok := n.threads[action.Id]; !ok {
+		n.threads[action.Id] = ts
+	}
+	return nil
+}
+
+// postMessage sends payload through chat.postMessage and returns the ts of
+// the message.
+func (n *SlackNotifier) postMessage(token string, payload *Payload) (string, error) {
+	buff, err := json.Marshal(payload)
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequest("POST", slackPostMessageURL, bytes.NewReader(buff))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := notifyClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	result := struct {
+		Ok    bool   `json:"ok"`
+		Error string `json:"error"`
+		Ts    string `json:"ts"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", errors.New(fmt.Sprintf("slack returned %s: %s", resp.Status, err))
+	}
+	if !result.Ok {
+		return "", errors.New(fmt.Sprintf("slack returned %s", result.Error))
+	}
+	return result.Ts, nil
+}
+
+// Notification is what the webhook body template and the json format of
+// the stdout and file notifiers see. Id is the same for every stage of an
+// action.
+type Notification struct {
+	Id             string    `json:"id"`
+	At             time.Time `json:"at"`
+	User           string    `json:"user"`
+	Type           string    `json:"type"`
+	App            string    `json:"app"`
+	Env            string    `json:"env,omitempty"`
+	Asg            string    `json:"asg,omitempty"`
+	LC             string    `json:"lc,omitempty"`
+	PreviousLC     string    `json:"previous_lc,omitempty"`
+	CapacityBefore int64     `json:"capacity_before"`
+	CapacityAfter  int64     `json:"capacity_after"`
+	Duration       string    `json:"duration,omitempty"`
+	Health         string    `json:"health,omitempty"`
+	History        string    `json:"history,omitempty"`
+	Outcome        string    `json:"outcome"`
+	Error          string    `json:"error,omitempty"`
+	Summary        string    `json:"summary"`
+}
+
+func newNotification(action *DeployAction, user string) *Notification {
+	notification := &Notification{
+		Id:             action.Id,
+		At:             action.At,
+		User:           user,
+		Type:           action.CmdType,
+		App:            action.AppName,
+		Env:            action.Env,
+		Asg:            action.Asg,
+		LC:             action.LC,
+		PreviousLC:     action.PreviousLC,
+		CapacityBefore: action.CapacityBefore,
+		CapacityAfter:  action.NumServers,
+		Health:         action.Health,
+		History:        action.HistoryLink,
+		Outcome:        action.Outcome,
+		Error:          action.Error,
+		Summary:        summarize(action, user),
+	}
+	if action.Finished() {
+		notification.Duration = formatDuration(action.Duration)
+	}
+	return notification
+}
+
+func formatDuration(d time.Duration) string {
+	return (d - d%time.Second).String()
+}
+
+// summarize is a one line description of action.
+func summarize(action *DeployAction, user string) string {
+	parts := []string{action.At.Format(time.RFC3339), user, action.CmdType, action.AppName}
+	if action.Env != "" {
+		parts = append(parts, "in "+action.Env)
+	}
+	if action.Asg != "" {
+		parts = append(parts, fmt.Sprintf("%s %d -> %d", action.Asg, action.CapacityBefore, action.NumServers))
+	}
+	if action.PreviousLC != "" && action.LC != "" {
+		parts = append(parts, fmt.Sprintf("%s -> %s", action.PreviousLC, action.LC))
+	} else if action.LC != "" {
+		parts = append(parts, action.LC)
+	}
+	parts = append(parts, action.Outcome)
+	if action.Finished() {
+		parts = append(parts, "after "+formatDuration(action.Duration))
+	}
+	if action.Error != "" {
+		parts = append(parts, action.Error)
+	}
+	return strings.Join(parts, " ")
+}
+
+// WebhookNotifier posts every action to a URL. The body is a text/template
+// over a Notification, where {{json .Field}} quotes a value for JSON.
+type WebhookNotifier struct {
+	username string
+	method   string
+	endpoint *webhookURL
+	header   map[string]string
+	body     *template.Template
+}
+
+const defaultWebhookBody = `{{json .}}`
+
+func newWebhookFromSettings(ui cli.ColoredUi, settings *core.NotifierSettings, user string) (BasicNotifier, error) {
+	endpoint, err := newWebhookURL(settings, "")
+	if err != nil {
+		return nil, err
+	}
+
+	body := settings.Body
+	if body == "" {
+		body = defaultWebhookBody
+	}
+	tmpl, err := template.New(settings.Name).Option("missingkey=error").Funcs(template.FuncMap{
+		"json": func(v interface{}) (string, error) {
+			out, err := json.Marshal(v)
+			return string(out), err
+		},
+	}).Parse(body)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("body is not a valid template: %s", err))
+	}
+
+	method := settings.Method
+	if method == "" {
+		method = "POST"
+	}
+
+	return &WebhookNotifier{
+		username: user,
+		method:   method,
+		endpoint: endpoint,
+		header:   settings.Header,
+		body:     tmpl,
+	}, nil
+}
+
+func (n *WebhookNotifier) Notify(action *DeployAction) error {
+	buff := &bytes.Buffer{}
+	if err := n.body.Execute(buff, newNotification(action, n.username)); err != nil {
+		return err
+	}
+
+	var parsed interface{}
+	if err := json.Unmarshal(buff.Bytes(), &parsed); err != nil {
+		return errors.New(fmt.Sprintf("body template did not render valid JSON: %s", err))
+	}
+	return postJSON(n.method, n.endpoint, n.header, buff.Bytes())
+}
+
+// EmailNotifier sends every action by mail through an SMTP server.
+type EmailNotifier struct {
+	username string
+	addr     string
+	auth     smtp.Auth
+	from     string
+	to       []string
+}
+
+func newEmailFromSettings(ui cli.ColoredUi, settings *core.NotifierSettings, user string) (BasicNotifier, error) {
+	if settings.SMTPHost == "" {
+		return nil, errors.New("smtp_host is required")
+	}
+	if settings.From == "" || len(settings.To) == 0 {
+		return nil, errors.New("from and to are required")
+	}
+
+	port := settings.SMTPPort
+	if port == 0 {
+		port = 587
+	}
+
+	var auth smtp.Auth
+	if settings.Username != "" {
+		passwordEnv := settings.PasswordEnv
+		if passwordEnv == "" {
+			passwordEnv = defaultSMTPPasswordEnv
+		}
+		auth = smtp.PlainAuth("", settings.Username, os.Getenv(passwordEnv), settings.SMTPHost)
+	}
+
+	return &EmailNotifier{
+		username: user,
+		addr:     fmt.Sprintf("%s:%d", settings.SMTPHost, port),
+		auth:     auth,
+		from:     settings.From,
+		to:       settings.To,
+	}, nil
+}
+
+// Notify sends one mail per stage. The stages after the first reply to it
+// so mail clients thread them.
+func (n *EmailNotifier) Notify(action *DeployAction) error {
+	subject := fmt.Sprintf("[sanders] %s %s", action.CmdType, action.AppName)
+	if action.Env != "" {
+		subject += " in " + action.Env
+	}
+	threadId := fmt.Sprintf("<%s@sanders>", action.Id)
+
+	msg := &bytes.Buffer{}
+	fmt.Fprintf(msg, "From: %s\r\n", n.from)
+	fmt.Fprintf(msg, "To: %s\r\n", strings.Join(n.to, ", "))
+	fmt.Fprintf(msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	if action.Finished() {
+		fmt.Fprintf(msg, "Subject: Re: %s\r\n", subject)
+		fmt.Fprintf(msg, "Message-ID: <%s.%s@sanders>\r\n", action.Id, action.Outcome)
+		fmt.Fprintf(msg, "In-Reply-To: %s\r\n", threadId)
+		fmt.Fprintf(msg, "References: %s\r\n", threadId)
+	} else {
+		fmt.Fprintf(msg, "Subject: %s\r\n", subject)
+		fmt.Fprintf(msg, "Message-ID: %s\r\n", threadId)
+	}
+	fmt.Fprintf(msg, "Content-Type: text/plain; charset=utf-8\r\n\r\n")
+
+	notification := newNotification(action, n.username)
+	lines := [][2]string{
+		{"Outcome", notification.Outcome},
+		{"Error", notification.Error},
+		{"User", notification.User},
+		{"Version", notification.LC},
+		{"Previous version", notification.PreviousLC},
+		{"ASG", notification.Asg},
+		{"Capacity", fmt.Sprintf("%d -> %d", notification.CapacityBefore, notification.CapacityAfter)},
+		{"Duration", notification.Duration},
+		{"Health", notification.Health},
+		{"History", notification.History},
+	}
+	for _, line := range lines {
+		if line[1] != "" {
+			fmt.Fprintf(msg, "%s: %s\r\n", line[0], line[1])
+		}
+	}
+
+	return smtp.SendMail(n.addr, n.auth, n.from, n.to, msg.Bytes())
+}
+
+// StreamNotifier writes one line per action, as text or as JSON.
+type StreamNotifier struct {
+	username string
+	asJSON   bool
+	write    func(line string) error
+}
+
+func streamFormat(settings *core.NotifierSettings) (bool, error) {
+	switch settings.Format {
+	case "", "text":
+		return false, nil
+	case "json":
+		return true, nil
+	}
+	return false, errors.New(fmt.Sprintf("unknown format %q (text or json)", settings.Format))
+}
+
+func newStdoutFromSettings(ui cli.ColoredUi, settings *core.NotifierSettings, user string) (BasicNotifier, error) {
+	asJSON, err := streamFormat(settings)
+	if err != nil {
+		return nil, err
+	}
+	return &StreamNotifier{
+		username: user,
+		asJSON:   asJSON,
+		write: func(line string) error {
+			ui.Output(line)
+			return nil
+		},
+	}, nil
+}
+
+func newFileFromSettings(ui cli.ColoredUi, settings *core.NotifierSettings, user string) (BasicNotifier, error) {
+	asJSON, err := streamFormat(settings)
+	if err != nil {
+		return nil, err
+	}
+	if settings.Path == "" {
+		return nil, errors.New("path is required")
+	}
+
+	path := settings.Path
+	return &StreamNotifier{
+		username: user,
+		asJSON:   asJSON,
+		write: func(line string) error {
+			f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+			if err != nil {
+				return err
+			}
+			if _, err := f.WriteString(line + "\n"); err != nil {
+				f.Close()
+				return err
+			}
+			return f.Close()
+		},
+	}, nil
+}
+
+func (n *StreamNotifier) Notify(action *DeployAction) error {
+	notification := newNotification(action, n.username)
+	if !n.asJSON {
+		return n.write(notification.Summary)
+	}
+
+	line, err := json.Marshal(notification)
+	if err != nil {
+		return err
+	}
+	return n.write(string(line))
+}
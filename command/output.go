@@ -0,0 +1,112 @@
+package command
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/mitchellh/cli"
+	"gopkg.in/yaml.v2"
+	"strings"
+	"text/tabwriter"
+)
+
+const outputFlagsHelp = `-format	text (default, colored), table, json or yaml.`
+
+const (
+	formatText  = "text"
+	formatTable = "table"
+	formatJSON  = "json"
+	formatYAML  = "yaml"
+)
+
+// Tabular is implemented by what can be printed as a table. The json and yaml
+// formats use the json tags of the value itself.
+type Tabular interface {
+	Header() []string
+	Rows() [][]string
+}
+
+type outputFlags struct {
+	format *string
+}
+
+// addOutputFlags registers -format, shared by every command listing things.
+func addOutputFlags(cmdFlags *flag.FlagSet) *outputFlags {
+	return &outputFlags{
+		format: cmdFlags.String("format", formatText, "text, table, json or yaml"),
+	}
+}
+
+func (f *outputFlags) validate() error {
+	switch *f.format {
+	case formatText, formatTable, formatJSON, formatYAML:
+		return nil
+	}
+	return errors.New(fmt.Sprintf("Unknown format %q (text, table, json or yaml)", *f.format))
+}
+
+// text is true when the command prints its usual colored output. Other
+// formats are meant for scripts, so progress messages are left out.
+func (f *outputFlags) text() bool {
+	return *f.format == formatText
+}
+
+// render prints value in the selected format. text prints the colored
+// output of the command.
+func (f *outputFlags) render(ui cli.ColoredUi, value Tabular, text func()) error {
+	switch *f.format {
+	case formatJSON:
+		out, err := json.MarshalIndent(value, "", "  ")
+		if err != nil {
+			return err
+		}
+		ui.Output(string(out))
+	case formatYAML:
+		out, err := toYAML(value)
+		if err != nil {
+			return err
+		}
+		ui.Output(strings.TrimSuffix(string(out), "\n"))
+	case formatTable:
+		ui.Output(renderTable(value))
+	default:
+		text()
+	}
+	return nil
+}
+
+// toYAML goes through JSON so the keys match the json format.
+func toYAML(value interface{}) ([]byte, error) {
+	out, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	var generic interface{}
+	if err := yaml.Unmarshal(out, &generic); err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(generic)
+}
+
+func renderTable(value Tabular) string {
+	buff := &bytes.Buffer{}
+	w := tabwriter.NewWriter(buff, 0, 0, 2, ' ', 0)
+
+	header := value.Header()
+	fmt.Fprintln(w, strings.ToUpper(strings.Join(header, "\t")))
+	for _, row := range value.Rows() {
+		cells := make([]string, len(header))
+		for idx := range cells {
+			cells[idx] = "-"
+			if idx < len(row) && row[idx] != "" {
+				cells[idx] = row[idx]
+			}
+		}
+		fmt.Fprintln(w, strings.Join(cells, "\t"))
+	}
+	w.Flush()
+	return strings.TrimSuffix(buff.String(), "\n")
+}
@@ -0,0 +1,186 @@
+package command
+
+import (
+	"flag"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/hello/sanders/core"
+	"github.com/mitchellh/cli"
+)
+
+const dryRunFlagHelp = `-dry-run	Print the plan and exit without changing anything.`
+
+const planFlagsHelp = dryRunFlagHelp + `
+	-json		Print the plan as JSON.`
+
+type planFlags struct {
+	dryRun *bool
+	json   *bool
+	// output replaces -json for commands that also take -format.
+	output *outputFlags
+}
+
+// addPlanFlags registers -dry-run and -json, shared by every mutating
+// command.
+func addPlanFlags(cmdFlags *flag.FlagSet) *planFlags {
+	return &planFlags{
+		dryRun: cmdFlags.Bool("dry-run", false, "print the plan and exit"),
+		json:   cmdFlags.Bool("json", false, "print the plan as JSON"),
+	}
+}
+
+// addDryRunFlag registers -dry-run only, for commands taking -format: the
+// plan is printed as JSON with -format json.
+func addDryRunFlag(cmdFlags *flag.FlagSet, output *outputFlags) *planFlags {
+	return &planFlags{
+		dryRun: cmdFlags.Bool("dry-run", false, "print the plan and exit"),
+		output: output,
+	}
+}
+
+func (f *planFlags) printJSON() bool {
+	if f.output != nil {
+		return *f.output.format == formatJSON
+	}
+	return *f.json
+}
+
+// show prints the plan and returns true when the command must stop there.
+// Failing to print it is an error, so a dry run never looks successful
+// without its output.
+func (f *planFlags) show(ui cli.ColoredUi, plan *core.Plan) (bool, error) {
+	if f.printJSON() {
+		out, err := plan.JSON()
+		if err != nil {
+			return true, err
+		}
+		ui.Output(string(out))
+	} else {
+		plan.Render(ui)
+	}
+
+	if *f.dryRun {
+		ui.Info("Dry run, nothing was changed.")
+		return true, nil
+	}
+	return false, nil
+}
+
+func asgAttributes(lcName string, desired, min, max int64) map[string]string {
+	launchKey := "launch_configuration"
+	if core.IsLaunchTemplateRef(lcName) {
+		launchKey = "launch_template"
+	}
+	return map[string]string{
+		launchKey:          lcName,
+		"desired_capacity": fmt.Sprintf("%d", desired),
+		"min_size":         fmt.Sprintf("%d", min),
+		"max_size":         fmt.Sprintf("%d", max),
+	}
+}
+
+// scaleChange is the change scaleASG makes to asg.
+func scaleChange(asg *autoscaling.Group, lcName string, desiredCapacity int64) core.Change {
+	return core.Change{
+		Resource: "autoscaling_group",
+		Name:     *asg.AutoScalingGroupName,
+		Action:   core.ActionUpdate,
+		Before:   asgAttributes(core.GroupLaunchRef(asg), *asg.DesiredCapacity, *asg.MinSize, *asg.MaxSize),
+		After:    asgAttributes(lcName, desiredCapacity, desiredCapacity, desiredCapacity*2),
+	}
+}
+
+// sunsetChange is the change sunsetASG makes to asg.
+func sunsetChange(asg *autoscaling.Group) core.Change {
+	lcName := core.GroupLaunchRef(asg)
+	return core.Change{
+		Resource: "autoscaling_group",
+		Name:     *asg.AutoScalingGroupName,
+		Action:   core.ActionUpdate,
+		Before:   asgAttributes(lcName, *asg.DesiredCapacity, *asg.MinSize, *asg.MaxSize),
+		After:    asgAttributes(lcName, 0, 0, 0),
+	}
+}
+
+// launchConfigurationChange describes the creation of a launch configuration.
+func launchConfigurationChange(params *autoscaling.CreateLaunchConfigurationInput) core.Change {
+	return core.Change{
+		Resource: "launch_configuration",
+		Name:     *params.LaunchConfigurationName,
+		Action:   core.ActionCreate,
+		After: map[string]string{
+			"image_id":             aws.StringValue(params.ImageId),
+			"instance_type":        aws.StringValue(params.InstanceType),
+			"iam_instance_profile": aws.StringValue(params.IamInstanceProfile),
+			"key_name":             aws.StringValue(params.KeyName),
+			"security_groups":      fmt.Sprintf("%v", aws.StringValueSlice(params.SecurityGroups)),
+			"user_data":            fmt.Sprintf("%d bytes", len(aws.StringValue(params.UserData))),
+		},
+	}
+}
+
+// launchTemplateVersionChange describes the creation of a launch template
+// version.
+func launchTemplateVersionChange(ref, version string, data *ec2.RequestLaunchTemplateData) core.Change {
+	securityGroups := make([]string, 0)
+	for _, iface := range data.NetworkInterfaces {
+		securityGroups = append(securityGroups, aws.StringValueSlice(iface.Groups)...)
+	}
+	profile := ""
+	if data.IamInstanceProfile != nil {
+		profile = aws.StringValue(data.IamInstanceProfile.Name)
+	}
+
+	return core.Change{
+		Resource: "launch_template_version",
+		Name:     ref,
+		Action:   core.ActionCreate,
+		After: map[string]string{
+			"version_description":  version,
+			"image_id":             aws.StringValue(data.ImageId),
+			"instance_type":        aws.StringValue(data.InstanceType),
+			"iam_instance_profile": profile,
+			"key_name":             aws.StringValue(data.KeyName),
+			"security_groups":      fmt.Sprintf("%v", securityGroups),
+			"user_data":            fmt.Sprintf("%d bytes", len(aws.StringValue(data.UserData))),
+		},
+	}
+}
+
+// withBaseAmi adds the base AMI the user data of ami runs on to change.
+func withBaseAmi(change core.Change, ami *core.SelectedAmi) core.Change {
+	if ami.BaseName != "" {
+		change.After["base_ami"] = ami.BaseName
+	}
+	return change
+}
+
+// spotFleetChange describes the spot fleet request launch-spot makes. Its id
+// is only known once requested, so it is named after the would-be LC.
+func spotFleetChange(name string, config *ec2.SpotFleetRequestConfigData) core.Change {
+	after := map[string]string{
+		"spot_price":      aws.StringValue(config.SpotPrice),
+		"target_capacity": fmt.Sprintf("%d", aws.Int64Value(config.TargetCapacity)),
+	}
+	if len(config.LaunchSpecifications) > 0 {
+		spec := config.LaunchSpecifications[0]
+		after["image_id"] = aws.StringValue(spec.ImageId)
+		after["instance_type"] = aws.StringValue(spec.InstanceType)
+		after["key_name"] = aws.StringValue(spec.KeyName)
+	}
+
+	subnets := make([]string, 0)
+	for _, spec := range config.LaunchSpecifications {
+		subnets = append(subnets, aws.StringValue(spec.SubnetId))
+	}
+	after["subnets"] = fmt.Sprintf("%v", subnets)
+
+	return core.Change{
+		Resource: "spot_fleet_request",
+		Name:     name,
+		Action:   core.ActionCreate,
+		After:    after,
+	}
+}
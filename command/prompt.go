@@ -0,0 +1,20 @@
+package command
+
+import (
+	"github.com/mitchellh/cli"
+)
+
+// askOk asks the user to type 'ok' to proceed. When yes is set (-yes flag)
+// the prompt is skipped so the command can run from scripts and CI.
+func askOk(ui cli.ColoredUi, yes bool, question string) (bool, error) {
+	if yes {
+		ui.Output(question + "ok (-yes)")
+		return true, nil
+	}
+
+	ok, err := ui.Ask(question)
+	if err != nil {
+		return false, err
+	}
+	return ok == "ok", nil
+}
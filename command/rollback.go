@@ -0,0 +1,300 @@
+package command
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/autoscaling/autoscalingiface"
+	"github.com/hello/sanders/core"
+	"github.com/mitchellh/cli"
+	"strings"
+	"time"
+)
+
+type RollbackCommand struct {
+	Ui       cli.ColoredUi
+	Aws      *core.Clients
+	Notifier BasicNotifier
+	Apps     []core.SuripuApp
+	Envs     core.Environments
+	Lock     *Locking
+}
+
+func (c *RollbackCommand) Help() string {
+	helpText := `Usage: sanders rollback [-env prod] [-app name] [-asg name] [-yes]
+
+	Scales the failed ASG back to zero and restores the other ASG of the
+	app to its target capacity.
+
+	-env	Environment to roll back (default prod).
+	-app	App to roll back. Prompts if omitted.
+	-asg	The failed ASG. Prompts if omitted.
+	-yes	Don't ask for confirmation.
+	` + planFlagsHelp
+	return strings.TrimSpace(helpText)
+}
+
+func (c *RollbackCommand) Run(args []string) int {
+
+	cmdFlags := flag.NewFlagSet("rollback", flag.ContinueOnError)
+	cmdFlags.Usage = func() { c.Ui.Output(c.Help()) }
+	envName := envFlag(cmdFlags, "prod")
+	appName := cmdFlags.String("app", "", "app to roll back")
+	asgFlag := cmdFlags.String("asg", "", "failed autoscaling group")
+	yes := cmdFlags.Bool("yes", false, "don't ask for confirmation")
+	planFlags := addPlanFlags(cmdFlags)
+	if err := cmdFlags.Parse(args); err != nil {
+		c.Ui.Error(fmt.Sprintf("%s", err))
+		return 1
+	}
+
+	env, err := c.Envs.Get(*envName)
+	if err != nil {
+		c.Ui.Error(err.Error())
+		return 1
+	}
+
+	service := c.Aws.AutoScaling
+
+	appSelector := core.NewAppSelector(c.Ui, *appName)
+	selectedApp, err := appSelector.Choose(c.Apps)
+	if err != nil {
+		c.Ui.Error(err.Error())
+		return 1
+	}
+
+	resp, err := service.DescribeAutoScalingGroups(&autoscaling.DescribeAutoScalingGroupsInput{
+		AutoScalingGroupNames: env.GroupNames(selectedApp),
+	})
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("%s", err))
+		return 1
+	}
+
+	if len(resp.AutoScalingGroups) != 2 {
+		c.Ui.Error(fmt.Sprintf("Expected 2 ASGs for %s, found %d", selectedApp.Name, len(resp.AutoScalingGroups)))
+		return 1
+	}
+
+	failedAsg := *asgFlag
+	if failedAsg == "" {
+		c.Ui.Output("Which ASG failed?\n")
+		for idx, asg := range resp.AutoScalingGroups {
+			c.Ui.Info(fmt.Sprintf("[%d] %s (%d instances running %s)", idx, *asg.AutoScalingGroupName, len(asg.Instances), core.GroupLaunchRef(asg)))
+		}
+
+		choiceStr, err := c.Ui.Ask("Choice: #")
+		if err != nil {
+			c.Ui.Error(err.Error())
+			return 1
+		}
+		choice, err := core.ParseSelection(choiceStr, len(resp.AutoScalingGroups))
+		if err != nil {
+			c.Ui.Error(fmt.Sprintf("Incorrect ASG selection: %v", err))
+			return 1
+		}
+		failedAsg = *resp.AutoScalingGroups[choice].AutoScalingGroupName
+	}
+
+	restoreAsg := ""
+	for _, asg := range resp.AutoScalingGroups {
+		if *asg.AutoScalingGroupName != failedAsg {
+			restoreAsg = *asg.AutoScalingGroupName
+		}
+	}
+	if restoreAsg == failedAsg || restoreAsg == "" {
+		c.Ui.Error(fmt.Sprintf("ASG %s does not belong to %s", failedAsg, selectedApp.Name))
+		return 1
+	}
+
+	plan := core.NewPlan("rollback", selectedApp.Name, env.Name)
+	for _, asg := range resp.AutoScalingGroups {
+		if *asg.AutoScalingGroupName == failedAsg {
+			plan.Add(sunsetChange(asg))
+			continue
+		}
+		capacity := env.DesiredCapacity(selectedApp)
+		if *asg.DesiredCapacity > capacity {
+			capacity = *asg.DesiredCapacity
+		}
+		plan.Add(scaleChange(asg, core.GroupLaunchRef(asg), capacity))
+	}
+	stop, err := planFlags.show(c.Ui, plan)
+	if err != nil {
+		c.Ui.Error(err.Error())
+		return 1
+	}
+	if stop {
+		return 0
+	}
+
+	unlock, err := c.Lock.acquire(c.Ui, selectedApp, env, "rollback", 0)
+	if err != nil {
+		c.Ui.Error(err.Error())
+		return 1
+	}
+	defer unlock()
+
+	ok, err := approve(c.Ui, env, *yes, "'ok' if you agree, anything else to cancel: ")
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("%s", err))
+		return 1
+	}
+	if !ok {
+		c.Ui.Warn("Cancelled.")
+		return 0
+	}
+
+	if _, err := rollback(c.Ui, c.Notifier, service, selectedApp, env, failedAsg, restoreAsg); err != nil {
+		c.Ui.Error(err.Error())
+		return 1
+	}
+	return 0
+}
+
+func (c *RollbackCommand) Synopsis() string {
+	return "Restores the previous ASG and scales the failed one to zero"
+}
+
+// RollbackRecord describes what a rollback reverted.
+type RollbackRecord struct {
+	At               time.Time
+	FailedAsg        string
+	FailedLC         string
+	FailedCapacity   int64
+	RestoredAsg      string
+	RestoredLC       string
+	RestoredCapacity int64
+	PreviousCapacity int64
+}
+
+func (r *RollbackRecord) String() string {
+	return fmt.Sprintf("%s: %s (%s, %d instances) -> 0, %s (%s) %d -> %d",
+		r.At.UTC().Format(time.RFC3339),
+		r.FailedAsg, r.FailedLC, r.FailedCapacity,
+		r.RestoredAsg, r.RestoredLC, r.PreviousCapacity, r.RestoredCapacity)
+}
+
+// rollback restores restoreAsg to the app's capacity in env, scales failedAsg
+// to zero, tags both ASGs with what was reverted and notifies each stage of
+// the rollback.
+func rollback(ui cli.ColoredUi, notifier BasicNotifier, service autoscalingiface.AutoScalingAPI, app *core.SuripuApp, env *core.Environment, failedAsg, restoreAsg string) (*RollbackRecord, error) {
+	resp, err := service.DescribeAutoScalingGroups(&autoscaling.DescribeAutoScalingGroupsInput{
+		AutoScalingGroupNames: []*string{aws.String(failedAsg), aws.String(restoreAsg)},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	record := &RollbackRecord{
+		At:          time.Now(),
+		FailedAsg:   failedAsg,
+		RestoredAsg: restoreAsg,
+	}
+
+	for _, asg := range resp.AutoScalingGroups {
+		switch *asg.AutoScalingGroupName {
+		case failedAsg:
+			record.FailedLC = core.GroupLaunchRef(asg)
+			record.FailedCapacity = *asg.DesiredCapacity
+		case restoreAsg:
+			record.RestoredLC = core.GroupLaunchRef(asg)
+			record.PreviousCapacity = *asg.DesiredCapacity
+		}
+	}
+
+	if record.RestoredLC == "" {
+		return nil, errors.New(fmt.Sprintf("ASG %s has no launch configuration to restore", restoreAsg))
+	}
+
+	record.RestoredCapacity = env.DesiredCapacity(app)
+	if record.PreviousCapacity > record.RestoredCapacity {
+		record.RestoredCapacity = record.PreviousCapacity
+	}
+
+	action := NewAsgAction("rollback", app, env, failedAsg, record.FailedLC, record.FailedCapacity, 0)
+	notifier.Notify(action)
+
+	// Bring the previous version back before removing the failed one.
+	ui.Info(fmt.Sprintf("Restoring %s to %d instances of %s", restoreAsg, record.RestoredCapacity, record.RestoredLC))
+	if err := scaleASG(service, restoreAsg, record.RestoredLC, record.RestoredCapacity); err != nil {
+		notifier.Notify(action.Failed(err))
+		return nil, err
+	}
+
+	ui.Info(fmt.Sprintf("Scaling %s down to 0", failedAsg))
+	if err := sunsetASG(service, failedAsg); err != nil {
+		notifier.Notify(action.Failed(err))
+		return nil, err
+	}
+
+	tags := []core.Tag{
+		{
+			AsgName:   failedAsg,
+			TagName:   "Rolled Back",
+			TagValue:  record.String(),
+			Propagate: false,
+		},
+		{
+			AsgName:   restoreAsg,
+			TagName:   "Restored By Rollback",
+			TagValue:  record.String(),
+			Propagate: false,
+		},
+	}
+	if _, err := updateASGTags(service, tags); err != nil {
+		ui.Warn(fmt.Sprintf("Rollback done but failed to record it on the ASGs: %s", err))
+	}
+
+	ui.Warn(fmt.Sprintf("Rolled back: %s", record))
+	notifier.Notify(action.Succeeded())
+	return record, nil
+}
+
+// rollbackTarget returns the ASG -auto-rollback restores when asgName fails:
+// the other ASG of the app, which must have a launch configuration to bring
+// back.
+func rollbackTarget(groups []*autoscaling.Group, asgName string) (string, error) {
+	for _, asg := range groups {
+		if *asg.AutoScalingGroupName == asgName {
+			continue
+		}
+		if core.GroupLaunchRef(asg) == "" {
+			return "", errors.New(fmt.Sprintf("Can't auto-rollback %s: %s has no launch configuration to restore", asgName, *asg.AutoScalingGroupName))
+		}
+		return *asg.AutoScalingGroupName, nil
+	}
+	return "", errors.New(fmt.Sprintf("Can't auto-rollback %s: the app has no other ASG to restore", asgName))
+}
+
+// waitOrRollback waits for count instances of asgName running lcName to be
+// InService on the app's ELB in env, or in the ASG when it has none, and rolls back to restoreAsg when they don't
+// make it in time. action is finished accordingly.
+func waitOrRollback(ui cli.ColoredUi, notifier BasicNotifier, action *DeployAction, service autoscalingiface.AutoScalingAPI, lbs *core.LoadBalancers, app *core.SuripuApp, env *core.Environment, asgName, lcName, restoreAsg string, count int64, timeout time.Duration) error {
+	elbName, err := healthElbName(service, app, env)
+	if err != nil {
+		notifier.Notify(action.Failed(err))
+		return err
+	}
+
+	ui.Info(fmt.Sprintf("Waiting for %d instances of %s to be InService (auto-rollback after %s)", count, asgName, timeout))
+	report, waitErr := newHealthWaiter(ui, service, lbs).Wait(asgName, elbName, lcName, count, timeout)
+	if report != nil {
+		action.Health = report.String()
+	}
+	if waitErr == nil {
+		notifier.Notify(action.Succeeded())
+		return nil
+	}
+
+	ui.Error(waitErr.Error())
+	ui.Warn(fmt.Sprintf("Rolling back %s to %s", asgName, restoreAsg))
+	if _, err := rollback(ui, notifier, service, app, env, asgName, restoreAsg); err != nil {
+		notifier.Notify(action.Failed(waitErr))
+		return errors.New(fmt.Sprintf("Rollback failed: %s", err))
+	}
+	notifier.Notify(action.RolledBack(waitErr))
+	return waitErr
+}
@@ -0,0 +1,278 @@
+package command
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/autoscaling/autoscalingiface"
+	"github.com/hello/sanders/core"
+	"github.com/mitchellh/cli"
+	"strings"
+	"time"
+)
+
+var rolloutPhases = []string{"deploy", "confirm", "sunset"}
+
+type RolloutCommand struct {
+	Ui       cli.ColoredUi
+	Aws      *core.Clients
+	Notifier BasicNotifier
+	Apps     []core.SuripuApp
+	Envs     core.Environments
+	Lock     *Locking
+}
+
+func (c *RolloutCommand) Help() string {
+	helpText := `Usage: sanders rollout [-env prod] [-app name] [-lc name] [options]
+
+	Runs deploy, confirm and sunset one after the other, waiting for the
+	new instances to be InService on the ELB between each phase.
+
+	-env		Environment to roll out to (default prod).
+	-app		App to roll out. Prompts if omitted.
+	-lc		Launch configuration, or launch template version (name:version),
+			to roll out. Prompts if omitted.
+	-canary		Number of instances started in the deploy phase (default 1).
+	-timeout	How long to wait for instances to be InService (default 15m).
+	-pause		Ask before moving on to the next phase.
+	-from		Resume from a phase: deploy, confirm or sunset.
+	-yes		Don't ask for confirmation.
+	` + planFlagsHelp
+	return strings.TrimSpace(helpText)
+}
+
+func (c *RolloutCommand) Run(args []string) int {
+
+	cmdFlags := flag.NewFlagSet("rollout", flag.ContinueOnError)
+	cmdFlags.Usage = func() { c.Ui.Output(c.Help()) }
+	envName := envFlag(cmdFlags, "prod")
+	appName := cmdFlags.String("app", "", "app to roll out")
+	lc := cmdFlags.String("lc", "", "launch configuration to roll out")
+	canaryCount := cmdFlags.Int64("canary", 1, "number of instances in the deploy phase")
+	timeout := cmdFlags.Duration("timeout", 15*time.Minute, "time to wait for instances to be InService")
+	pause := cmdFlags.Bool("pause", false, "ask before each phase")
+	from := cmdFlags.String("from", "deploy", "phase to start from")
+	yes := cmdFlags.Bool("yes", false, "don't ask for confirmation")
+	planFlags := addPlanFlags(cmdFlags)
+	if err := cmdFlags.Parse(args); err != nil {
+		c.Ui.Error(fmt.Sprintf("%s", err))
+		return 1
+	}
+
+	env, err := c.Envs.Get(*envName)
+	if err != nil {
+		c.Ui.Error(err.Error())
+		return 1
+	}
+
+	startIdx := -1
+	for idx, phase := range rolloutPhases {
+		if phase == *from {
+			startIdx = idx
+		}
+	}
+	if startIdx < 0 {
+		c.Ui.Error(fmt.Sprintf("Unknown phase %s. Valid phases: %s", *from, strings.Join(rolloutPhases, ", ")))
+		return 1
+	}
+
+	service := c.Aws.AutoScaling
+	lbs := c.Aws.LoadBalancers()
+
+	appSelector := core.NewAppSelector(c.Ui, *appName)
+	selectedApp, err := appSelector.Choose(c.Apps)
+	if err != nil {
+		c.Ui.Error(err.Error())
+		return 1
+	}
+
+	lcSelector := core.NewLaunchConfigurationSelector(c.Ui, service, c.Aws.EC2, *lc)
+	lcName, err := lcSelector.Choose(selectedApp, env)
+	if err != nil {
+		c.Ui.Error(err.Error())
+		return 1
+	}
+
+	target, previous, err := c.pickGroups(service, selectedApp, env, lcName, rolloutPhases[startIdx])
+	if err != nil {
+		c.Ui.Error(err.Error())
+		return 1
+	}
+
+	elbName, err := healthElbName(service, selectedApp, env)
+	if err != nil {
+		c.Ui.Error(err.Error())
+		return 1
+	}
+	desiredCapacity := env.DesiredCapacity(selectedApp)
+
+	previousLC := ""
+	plan := core.NewPlan("rollout", selectedApp.Name, env.Name)
+	for _, asgName := range []string{target, previous} {
+		asg, err := describeGroup(service, asgName)
+		if err != nil {
+			c.Ui.Error(err.Error())
+			return 1
+		}
+		if asgName == target {
+			plan.Add(scaleChange(asg, lcName, desiredCapacity))
+		} else {
+			previousLC = core.GroupLaunchRef(asg)
+			plan.Add(sunsetChange(asg))
+		}
+	}
+	if startIdx == 0 {
+		c.Ui.Output(fmt.Sprintf("The deploy phase starts %d instances of %s before confirming.", *canaryCount, target))
+	}
+	stop, err := planFlags.show(c.Ui, plan)
+	if err != nil {
+		c.Ui.Error(err.Error())
+		return 1
+	}
+	if stop {
+		return 0
+	}
+
+	unlock, err := c.Lock.acquire(c.Ui, selectedApp, env, "rollout", *timeout*time.Duration(len(rolloutPhases)))
+	if err != nil {
+		c.Ui.Error(err.Error())
+		return 1
+	}
+	defer unlock()
+
+	ok, err := approve(c.Ui, env, *yes, "'ok' if you agree, anything else to cancel: ")
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("%s", err))
+		return 1
+	}
+	if !ok {
+		c.Ui.Warn("Cancelled.")
+		return 0
+	}
+
+	for idx := startIdx; idx < len(rolloutPhases); idx++ {
+		phase := rolloutPhases[idx]
+
+		if *pause && idx > startIdx {
+			ok, err := askOk(c.Ui, false, fmt.Sprintf("'ok' to continue with %s, anything else to pause: ", phase))
+			if err != nil {
+				c.Ui.Error(fmt.Sprintf("%s", err))
+				return 1
+			}
+			if !ok {
+				c.Ui.Warn("Paused. Resume with:")
+				c.Ui.Warn(fmt.Sprintf("\tsanders rollout -env %s -app %s -lc %s -from %s", env.Name, selectedApp.Name, lcName, phase))
+				return 0
+			}
+		}
+
+		c.Ui.Info(fmt.Sprintf("--> %s", phase))
+
+		switch phase {
+		case "deploy":
+			err = c.scale(service, lbs, selectedApp, env, target, elbName, lcName, previousLC, *canaryCount, *timeout, "deploy")
+		case "confirm":
+			err = c.scale(service, lbs, selectedApp, env, target, elbName, lcName, previousLC, desiredCapacity, *timeout, "confirm")
+		case "sunset":
+			err = c.sunset(service, lbs, selectedApp, env, previous, target, elbName, lcName, desiredCapacity)
+		}
+
+		if err != nil {
+			c.Ui.Error(err.Error())
+			c.Ui.Warn("Once fixed, resume with:")
+			c.Ui.Warn(fmt.Sprintf("\tsanders rollout -env %s -app %s -lc %s -from %s", env.Name, selectedApp.Name, lcName, phase))
+			return 1
+		}
+	}
+
+	c.Ui.Info(fmt.Sprintf("Rollout of %s complete", lcName))
+	return 0
+}
+
+func (c *RolloutCommand) scale(service autoscalingiface.AutoScalingAPI, lbs *core.LoadBalancers, app *core.SuripuApp, env *core.Environment, asgName, elbName, lcName, previousLC string, capacity int64, timeout time.Duration, cmdType string) error {
+	before, err := asgCapacity(service, asgName)
+	if err != nil {
+		return err
+	}
+
+	action := NewAsgAction(cmdType, app, env, asgName, lcName, before, capacity)
+	action.PreviousLC = previousLC
+	c.Notifier.Notify(action)
+
+	if err := scaleASG(service, asgName, lcName, capacity); err != nil {
+		c.Notifier.Notify(action.Failed(err))
+		return err
+	}
+
+	if _, err := updateASGTags(service, deployTags(asgName, app, env, lcName, packageChecksum(c.Ui, c.Aws, app, env, lcName))); err != nil {
+		c.Notifier.Notify(action.Failed(err))
+		return err
+	}
+
+	c.Ui.Info(fmt.Sprintf("Waiting for %d instances of %s to be InService", capacity, asgName))
+	return waitAndNotify(c.Ui, c.Notifier, action, service, lbs, asgName, elbName, lcName, capacity, timeout)
+}
+
+// sunset scales asgName to 0 once target has capacity instances of lcName
+// InService, the check the sunset command makes.
+func (c *RolloutCommand) sunset(service autoscalingiface.AutoScalingAPI, lbs *core.LoadBalancers, app *core.SuripuApp, env *core.Environment, asgName, target, elbName, lcName string, capacity int64) error {
+	report, err := newHealthWaiter(c.Ui, service, lbs).Check(target, elbName, lcName)
+	if err != nil {
+		return err
+	}
+	if report.Healthy < capacity {
+		return errors.New(fmt.Sprintf("Refusing to sunset %s, %s is not healthy: %s", asgName, target, report))
+	}
+	c.Ui.Info(report.String())
+
+	before, err := asgCapacity(service, asgName)
+	if err != nil {
+		return err
+	}
+
+	action := NewAsgAction("sunset", app, env, asgName, "-", before, 0)
+	action.Health = report.String()
+	c.Notifier.Notify(action)
+	if err := sunsetASG(service, asgName); err != nil {
+		c.Notifier.Notify(action.Failed(err))
+		return err
+	}
+	c.Notifier.Notify(action.Succeeded())
+	return nil
+}
+
+// pickGroups returns the ASG the LC is rolled out to and the one being
+// replaced. When resuming, the target is the ASG already running the LC.
+func (c *RolloutCommand) pickGroups(service autoscalingiface.AutoScalingAPI, app *core.SuripuApp, env *core.Environment, lcName, phase string) (string, string, error) {
+	resp, err := service.DescribeAutoScalingGroups(&autoscaling.DescribeAutoScalingGroupsInput{
+		AutoScalingGroupNames: env.GroupNames(app),
+	})
+	if err != nil {
+		return "", "", err
+	}
+
+	if len(resp.AutoScalingGroups) != 2 {
+		return "", "", errors.New(fmt.Sprintf("Expected 2 ASGs for %s, found %d", app.Name, len(resp.AutoScalingGroups)))
+	}
+
+	for idx, asg := range resp.AutoScalingGroups {
+		other := *resp.AutoScalingGroups[1-idx].AutoScalingGroupName
+
+		if phase == "deploy" && *asg.DesiredCapacity == 0 {
+			return *asg.AutoScalingGroupName, other, nil
+		}
+		if phase != "deploy" && core.GroupLaunchRef(asg) == lcName && *asg.DesiredCapacity > 0 {
+			return *asg.AutoScalingGroupName, other, nil
+		}
+	}
+
+	if phase == "deploy" {
+		return "", "", errors.New(fmt.Sprintf("No ASG with desired capacity 0 for %s. Has the previous version been sunset?", app.Name))
+	}
+	return "", "", errors.New(fmt.Sprintf("No running ASG with LC %s to resume from", lcName))
+}
+
+func (c *RolloutCommand) Synopsis() string {
+	return "Runs deploy, confirm and sunset for a new version"
+}
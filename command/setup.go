@@ -1,12 +1,11 @@
 package command
 
 import (
+	"flag"
 	"fmt"
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/autoscaling"
 	"github.com/aws/aws-sdk-go/service/ec2"
-	"github.com/aws/aws-sdk-go/service/elb"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
 	"github.com/hello/sanders/core"
 	"github.com/hello/sanders/setup"
 	"github.com/mitchellh/cli"
@@ -14,14 +13,44 @@ import (
 	"strings"
 )
 
+const (
+	lbClassic     = "classic"
+	lbApplication = "application"
+)
+
 type SetupCommand struct {
-	Ui     cli.ColoredUi
-	Config *aws.Config
-	Apps   []core.SuripuApp
+	Ui        cli.ColoredUi
+	Aws       *core.Clients
+	AccountId string
+	Apps      []core.SuripuApp
+	Envs      core.Environments
+	Lock      *Locking
 }
 
 func (c *SetupCommand) Help() string {
-	helpText := `Usage: setup`
+	helpText := `Usage: sanders setup [-env prod] [-app name] [-vpc id] [-subnets id,id]
+	                     [-lb classic|application] [-certificate arn] [-health-check path]
+	                     [-launch-template]
+
+	Creates the security groups, load balancer and ASGs of a new app in the
+	given environment (default prod).
+
+	-vpc and -subnets default to our us-east-1 VPC and must be set when
+	running against another region.
+
+	-lb application creates an ALB with an HTTPS listener using -certificate
+	and a target group, checked on -health-check (default /), that both ASGs
+	are attached to. The default is a classic ELB with a TCP listener.
+
+	-launch-template creates the launch template of the app instead of a
+	placeholder launch configuration. Set "launch_template": true on the app
+	so create adds versions to it.
+
+	-base-ami is the AMI id, or name pattern to take the latest of, of the
+	placeholder launch configuration or template. It defaults to the base AMI
+	configured for the app and environment, or ami-d06267ba.
+
+	` + planFlagsHelp
 	return strings.TrimSpace(helpText)
 }
 
@@ -33,56 +62,151 @@ func (c *SetupCommand) err(err error) int {
 
 func (c *SetupCommand) Run(args []string) int {
 
-	sess := session.New()
-	asg := autoscaling.New(sess, c.Config)
-	ec2srv := ec2.New(sess, c.Config)
-	elbsrv := elb.New(sess, c.Config)
+	cmdFlags := flag.NewFlagSet("setup", flag.ContinueOnError)
+	cmdFlags.Usage = func() { c.Ui.Output(c.Help()) }
+	envName := envFlag(cmdFlags, "prod")
+	appFlag := cmdFlags.String("app", "", "new application name")
+	vpcFlag := cmdFlags.String("vpc", "vpc-961464f3", "vpc to create the app in")
+	subnetsFlag := cmdFlags.String("subnets", "subnet-28c6565f,subnet-da02b383", "comma separated subnets, one per AZ")
+	lbFlag := cmdFlags.String("lb", lbClassic, "classic or application")
+	certificateFlag := cmdFlags.String("certificate", "", "ACM certificate ARN of the HTTPS listener")
+	healthCheckFlag := cmdFlags.String("health-check", "/", "health check path of the target group")
+	templateFlag := cmdFlags.Bool("launch-template", false, "create a launch template instead of a launch configuration")
+	baseAmiFlag := cmdFlags.String("base-ami", "", "base AMI id or name pattern")
+	planFlags := addPlanFlags(cmdFlags)
+	if err := cmdFlags.Parse(args); err != nil {
+		c.Ui.Error(fmt.Sprintf("%s", err))
+		return 1
+	}
+
+	env, err := c.Envs.Get(*envName)
+	if err != nil {
+		c.Ui.Error(err.Error())
+		return 1
+	}
+
+	switch *lbFlag {
+	case lbClassic:
+	case lbApplication:
+		if *certificateFlag == "" {
+			c.Ui.Error("-certificate is required with -lb application")
+			return 1
+		}
+	default:
+		c.Ui.Error(fmt.Sprintf("Unknown load balancer type %q (classic or application)", *lbFlag))
+		return 1
+	}
+
+	asg := c.Aws.AutoScaling
+	ec2srv := c.Aws.EC2
+	elbsrv := c.Aws.ELB
 
 	state := new(multistep.BasicStateBag)
 	state.Put("ui", c.Ui)
 	state.Put("asg", asg)
 	state.Put("ec2", ec2srv)
 	state.Put("elb", elbsrv)
+	state.Put("elbv2", c.Aws.ELBv2)
 
-	appName, err := c.Ui.Ask("New application name? Ex: suripu-service, supichi, …\n")
-	if err != nil {
-		return c.err(err)
+	appName := *appFlag
+	if appName == "" {
+		answer, err := c.Ui.Ask("New application name? Ex: suripu-service, supichi, …\n")
+		if err != nil {
+			return c.err(err)
+		}
+		appName = answer
 	}
-	vpcId := "vpc-961464f3"
+	vpcId := *vpcFlag
 	appInPort := int64(8080)
-	azs := []string{"us-east-1a", "us-east-1b"}
 	appName = strings.TrimSpace(appName)
-	subnets := []string{
-		"subnet-28c6565f", // 1A
-		"subnet-da02b383", // 1B
+	subnets := strings.Split(*subnetsFlag, ",")
+	newApp := &core.SuripuApp{Name: appName}
+
+	azs, err := subnetAzs(ec2srv, subnets)
+	if err != nil {
+		return c.err(err)
+	}
+
+	baseAmiSettings := core.SetupBaseAmi
+	if configured := env.ConfiguredBaseAmi(newApp); configured != nil {
+		baseAmiSettings = *configured
+	}
+	if *baseAmiFlag != "" {
+		baseAmiSettings = core.ParseBaseAmi(*baseAmiFlag)
+	}
+	baseAmi, err := core.ResolveBaseAmi(ec2srv, baseAmiSettings)
+	if err != nil {
+		return c.err(err)
+	}
+	var lbStep multistep.Step = &setup.StepCreateELB{
+		ElbName:    env.ElbName(newApp),
+		ElbOutPort: appInPort,
+		ElbInPort:  int64(443),
+		Subnets:    subnets,
+	}
+	if *lbFlag == lbApplication {
+		lbStep = &setup.StepCreateALB{
+			Name:            env.ElbName(newApp),
+			VpcId:           vpcId,
+			Subnets:         subnets,
+			Port:            int64(443),
+			TargetPort:      appInPort,
+			CertificateArn:  *certificateFlag,
+			HealthCheckPath: *healthCheckFlag,
+		}
+	}
+
+	var launchStep multistep.Step = &setup.StepLaunchConfiguration{
+		AppName:        appName,
+		ImageId:        *baseAmi.ImageId,
+		SecurityGroups: []string{},
+		KeyName:        "vpc-root",
+		InstanceType:   "c3.large",
 	}
+	if *templateFlag {
+		launchStep = &setup.StepLaunchTemplate{
+			Name:           env.LaunchTemplateName(newApp),
+			ImageId:        *baseAmi.ImageId,
+			SecurityGroups: []string{},
+			KeyName:        "vpc-root",
+			InstanceType:   "c3.large",
+		}
+	}
+
 	// Build the steps
 	steps := []multistep.Step{
 		&setup.StepCreateSecurityGroups{
 			AppName:   appName,
+			Env:       env.Name,
 			VpcId:     vpcId,
 			AppInPort: appInPort,
+			AccountId: c.AccountId,
 		},
-		&setup.StepCreateELB{
-			AppName:    appName,
-			ElbOutPort: appInPort,
-			ElbInPort:  int64(443),
-			Subnets:    subnets,
-		},
-		&setup.StepLaunchConfiguration{
-			AppName:        appName,
-			ImageId:        "ami-d06267ba",
-			SecurityGroups: []string{},
-			KeyName:        "vpc-root",
-			InstanceType:   "c3.large",
-		},
+		lbStep,
+		launchStep,
 		&setup.StepCreateAutoScalingGroups{
-			AppName: appName,
-			Azs:     azs,
-			Subnets: subnets,
+			AsgNames: aws.StringValueSlice(env.GroupNames(newApp)),
+			Azs:      azs,
+			Subnets:  subnets,
 		},
 	}
 
+	stop, err := planFlags.show(c.Ui, setupPlan(env, newApp, vpcId, subnets, *lbFlag, *templateFlag, baseAmi))
+	if err != nil {
+		c.Ui.Error(err.Error())
+		return 1
+	}
+	if stop {
+		return 0
+	}
+
+	unlock, err := c.Lock.acquire(c.Ui, newApp, env, "setup", 0)
+	if err != nil {
+		c.Ui.Error(err.Error())
+		return 1
+	}
+	defer unlock()
+
 	//
 	runner := &multistep.BasicRunner{
 		Steps: steps,
@@ -97,6 +221,85 @@ func (c *SetupCommand) Run(args []string) int {
 	return 0
 }
 
+// setupPlan lists the resources the setup steps create for app in env.
+func setupPlan(env *core.Environment, app *core.SuripuApp, vpcId string, subnets []string, lbType string, useTemplate bool, baseAmi *ec2.Image) *core.Plan {
+	plan := core.NewPlan("setup", app.Name, env.Name)
+	for _, sgName := range []string{fmt.Sprintf("elb-%s-%s", app.Name, env.Name), fmt.Sprintf("%s-%s", app.Name, env.Name)} {
+		plan.Add(core.Change{
+			Resource: "security_group",
+			Name:     sgName,
+			Action:   core.ActionCreate,
+			After:    map[string]string{"vpc_id": vpcId},
+		})
+	}
+	plan.Add(core.Change{
+		Resource: "load_balancer",
+		Name:     env.ElbName(app),
+		Action:   core.ActionCreate,
+		After:    map[string]string{"type": lbType, "subnets": strings.Join(subnets, ",")},
+	})
+	if lbType == lbApplication {
+		plan.Add(core.Change{
+			Resource: "target_group",
+			Name:     env.ElbName(app),
+			Action:   core.ActionCreate,
+			After:    map[string]string{"vpc_id": vpcId, "protocol": "HTTP"},
+		})
+		plan.Add(core.Change{
+			Resource: "listener",
+			Name:     fmt.Sprintf("%s:443", env.ElbName(app)),
+			Action:   core.ActionCreate,
+			After:    map[string]string{"protocol": "HTTPS"},
+		})
+	}
+	launchRef := fmt.Sprintf("%s-0.0.0", app.Name)
+	launchAttributes := map[string]string{
+		"image_id": aws.StringValue(baseAmi.ImageId),
+		"base_ami": aws.StringValue(baseAmi.Name),
+	}
+	if useTemplate {
+		launchRef = core.LaunchTemplateRef(env.LaunchTemplateName(app), 1)
+		plan.Add(core.Change{
+			Resource: "launch_template",
+			Name:     env.LaunchTemplateName(app),
+			Action:   core.ActionCreate,
+			After:    launchAttributes,
+		})
+	} else {
+		plan.Add(core.Change{
+			Resource: "launch_configuration",
+			Name:     launchRef,
+			Action:   core.ActionCreate,
+			After:    launchAttributes,
+		})
+	}
+	for _, asgName := range env.GroupNames(app) {
+		plan.Add(core.Change{
+			Resource: "autoscaling_group",
+			Name:     *asgName,
+			Action:   core.ActionCreate,
+			After:    asgAttributes(launchRef, 0, 0, 0),
+		})
+	}
+	return plan
+}
+
+// subnetAzs returns the availability zones of the given subnets.
+func subnetAzs(ec2srv ec2iface.EC2API, subnets []string) ([]string, error) {
+	resp, err := ec2srv.DescribeSubnets(&ec2.DescribeSubnetsInput{
+		SubnetIds: aws.StringSlice(subnets),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	azs := make([]string, 0)
+	for _, subnet := range resp.Subnets {
+		azs = append(azs, *subnet.AvailabilityZone)
+	}
+	return azs, nil
+}
+
 func (c *SetupCommand) Synopsis() string {
 	return "Creates a launch configuration based on selected parameters."
 }
@@ -1,11 +1,12 @@
 package command
 
 import (
+	"flag"
 	"fmt"
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
 	"github.com/aws/aws-sdk-go/service/ec2"
-	"github.com/aws/aws-sdk-go/service/elb"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
 	"github.com/hello/sanders/core"
 	"github.com/mitchellh/cli"
 	"strings"
@@ -13,94 +14,253 @@ import (
 
 type StatusCommand struct {
 	Ui       cli.ColoredUi
+	Aws      *core.Clients
 	Notifier BasicNotifier
 	Apps     []core.SuripuApp
+	Envs     core.Environments
 }
 
 func (c *StatusCommand) Help() string {
-	helpText := `Usage: sanders status`
+	helpText := `Usage: sanders status [-app name] [-env name] [-format text]
+
+	Shows the instances behind the load balancers of every app, found from
+	the LoadBalancerNames and target groups of its ASGs, classic ELBs as well
+	as ALBs and NLBs. Apps without a load balancer show the
+	ASG health of their instances instead.
+
+	-app	Only show this app.
+	-env	Only show this environment.
+	` + outputFlagsHelp
 	return strings.TrimSpace(helpText)
 }
 
+// Status is the health of the instances behind ElbName, or of the instances
+// of Asg when the app has no load balancer.
 type Status struct {
-	ElbName  string
-	Statuses []HostStatus
-	Error    error
+	App      string       `json:"app,omitempty"`
+	Env      string       `json:"env,omitempty"`
+	ElbName  string       `json:"elb,omitempty"`
+	Asg      string       `json:"asg,omitempty"`
+	Statuses []HostStatus `json:"instances"`
+	Error    error        `json:"-"`
+}
+
+// Name is the load balancer, or the ASG for apps without load balancer.
+func (s *Status) Name() string {
+	if s.ElbName != "" {
+		return core.LoadBalancerLabel(s.ElbName)
+	}
+	return s.Asg
 }
 
 type HostStatus struct {
-	Hostname       string
-	Version        string
-	InstanceId     string
-	State          string
-	Reason         string
-	Description    string
-	Launched       string
-	PrivateDnsName string
+	Hostname       string `json:"hostname"`
+	Version        string `json:"version"`
+	InstanceId     string `json:"instance_id"`
+	State          string `json:"state"`
+	Reason         string `json:"reason,omitempty"`
+	Description    string `json:"description,omitempty"`
+	Launched       string `json:"launched"`
+	PrivateDnsName string `json:"private_dns_name"`
 }
 
-func fetch(elbName string, service *elb.ELB, ec2Service *ec2.EC2, statuses chan *Status) {
-	statuses <- elbStatus(elbName, service, ec2Service)
+// ElbStatuses is what status and monitor print, one row per instance in
+// tables.
+type ElbStatuses []*Status
+
+func (s ElbStatuses) Header() []string {
+	return []string{"App", "Env", "ELB/ASG", "Version", "ID", "State", "Reason", "Launched", "Hostname", "Private DNS"}
 }
 
-func (c *StatusCommand) Run(args []string) int {
+func (s ElbStatuses) Rows() [][]string {
+	rows := make([][]string, 0)
+	for _, status := range s {
+		for _, host := range status.Statuses {
+			reason := host.Reason
+			if reason == "N/A" {
+				reason = ""
+			}
+			rows = append(rows, []string{status.App, status.Env, status.Name(), host.Version, host.InstanceId, host.State, reason, host.Launched, host.Hostname, host.PrivateDnsName})
+		}
+	}
+	return rows
+}
 
-	config := &aws.Config{
-		Region: aws.String("us-east-1"),
+// fetch sends the statuses of every ELB of target, or of its ASGs when it
+// has none.
+func fetch(target *statusTarget, lbs *core.LoadBalancers, ec2Service ec2iface.EC2API, statuses chan []*Status) {
+	results := make([]*Status, 0)
+	if len(target.ElbNames) > 0 {
+		for _, elbName := range target.ElbNames {
+			results = append(results, elbStatus(elbName, lbs, ec2Service))
+		}
+	} else {
+		for _, group := range target.Groups {
+			if len(group.Instances) == 0 && aws.Int64Value(group.DesiredCapacity) == 0 {
+				continue
+			}
+			results = append(results, asgStatus(group, ec2Service))
+		}
 	}
 
-	service := elb.New(session.New(), config)
-	ec2Service := ec2.New(session.New(), config)
+	for _, status := range results {
+		status.App = target.App
+		status.Env = target.Env
+	}
+	statuses <- results
+}
+
+func (c *StatusCommand) Run(args []string) int {
 
-	elbs := []string{
-		"suripu-service-prod",
-		"suripu-app-prod",
-		"suripu-app-canary",
-		"suripu-admin-prod",
-		"messeji-prod",
-		"hello-time-prod",
-		"taimurain-prod",
+	cmdFlags := flag.NewFlagSet("status", flag.ContinueOnError)
+	cmdFlags.Usage = func() { c.Ui.Output(c.Help()) }
+	appName := cmdFlags.String("app", "", "app to show")
+	envName := envFlag(cmdFlags, "")
+	output := addOutputFlags(cmdFlags)
+	if err := cmdFlags.Parse(args); err != nil {
+		c.Ui.Error(fmt.Sprintf("%s", err))
+		return 1
+	}
+	if err := output.validate(); err != nil {
+		c.Ui.Error(err.Error())
+		return 1
 	}
 
-	statuses := make(chan *Status, 0)
+	lbs := c.Aws.LoadBalancers()
+	ec2Service := c.Aws.EC2
 
-	for _, elbName := range elbs {
-		go fetch(elbName, service, ec2Service, statuses)
-		c.Ui.Info(fmt.Sprintf("Fetching: ELB %s", elbName))
+	targets, err := discoverTargets(c.Aws.AutoScaling, c.Apps, c.Envs, *appName, *envName)
+	if err != nil {
+		c.Ui.Error(err.Error())
+		return 1
 	}
 
-	c.Ui.Output("")
-	results := make(map[string]*Status)
+	statuses := make(chan []*Status, 0)
 
-	remaining := len(elbs)
-	for status := range statuses {
-		if status.Error != nil {
-			c.Ui.Error(fmt.Sprintf("%s", status.Error))
-			return 1
+	for _, target := range targets {
+		go fetch(target, lbs, ec2Service, statuses)
+		if output.text() {
+			for _, label := range target.labels() {
+				c.Ui.Info(fmt.Sprintf("Fetching: %s", label))
+			}
 		}
-		results[status.ElbName] = status
+	}
 
-		remaining -= 1
-		if remaining == 0 {
-			break
+	if output.text() {
+		c.Ui.Output("")
+	}
+	results := make(map[string][]*Status)
+
+	remaining := len(targets)
+	for remaining > 0 {
+		batch := <-statuses
+		for _, status := range batch {
+			if status.Error != nil {
+				c.Ui.Error(fmt.Sprintf("%s", status.Error))
+				return 1
+			}
+			key := status.App + "/" + status.Env
+			results[key] = append(results[key], status)
 		}
+		remaining -= 1
 	}
 
-	for _, elb := range elbs {
-		status, _ := results[elb]
-		printStatus(c.Ui, status)
+	close(statuses)
+
+	ordered := make(ElbStatuses, 0)
+	for _, target := range targets {
+		ordered = append(ordered, results[target.App+"/"+target.Env]...)
 	}
 
-	close(statuses)
-	c.Ui.Output("")
+	err = output.render(c.Ui, ordered, func() {
+		if len(ordered) == 0 {
+			c.Ui.Warn("No instance found for the selected apps.")
+			return
+		}
+		for _, status := range ordered {
+			printStatus(c.Ui, status)
+		}
+		c.Ui.Output("")
+	})
+	if err != nil {
+		c.Ui.Error(err.Error())
+		return 1
+	}
 	return 0
 }
 
-func elbStatus(elbName string, service *elb.ELB, ec2Service *ec2.EC2) *Status {
-	req := &elb.DescribeInstanceHealthInput{
-		LoadBalancerName: &elbName,
+// lcVersion is the version in a launch configuration name,
+// <app>-<env>-<version>. Launch template references are returned as is.
+func lcVersion(lcName string) string {
+	if core.IsLaunchTemplateRef(lcName) {
+		return lcName
+	}
+	parts := strings.SplitAfterN(lcName, "-", 4)
+	return strings.TrimSuffix(parts[len(parts)-1], "-")
+}
+
+// amiVersion is the version in an AMI name, <app>-<build>-<version>.
+func amiVersion(amiName string) string {
+	parts := strings.SplitAfterN(amiName, "-", 4)
+	if len(parts) < 3 {
+		return ""
 	}
+	return strings.TrimSuffix(parts[2], "-")
+}
+
+// asgStatus reports the ASG lifecycle and health of the instances of group,
+// for apps without a load balancer.
+func asgStatus(group *autoscaling.Group, ec2Service ec2iface.EC2API) *Status {
+	status := &Status{
+		Asg:      *group.AutoScalingGroupName,
+		Statuses: make([]HostStatus, 0),
+	}
+	if len(group.Instances) == 0 {
+		return status
+	}
+
+	instanceIds := make([]*string, 0)
+	for _, instance := range group.Instances {
+		instanceIds = append(instanceIds, instance.InstanceId)
+	}
+
+	resp, err := ec2Service.DescribeInstances(&ec2.DescribeInstancesInput{
+		InstanceIds: instanceIds,
+	})
+	if err != nil {
+		status.Error = err
+		return status
+	}
+
+	details := make(map[string]*ec2.Instance)
+	for _, reservation := range resp.Reservations {
+		for _, instance := range reservation.Instances {
+			details[*instance.InstanceId] = instance
+		}
+	}
+
+	for _, instance := range group.Instances {
+		hostStatus := HostStatus{
+			Version:    lcVersion(core.InstanceLaunchRef(instance)),
+			InstanceId: *instance.InstanceId,
+			State:      aws.StringValue(instance.LifecycleState),
+		}
+		if health := aws.StringValue(instance.HealthStatus); health != "Healthy" {
+			hostStatus.Reason = health
+		}
+		if detail, found := details[*instance.InstanceId]; found {
+			hostStatus.Hostname = aws.StringValue(detail.PublicDnsName)
+			hostStatus.PrivateDnsName = aws.StringValue(detail.PrivateDnsName)
+			hostStatus.Launched = fmt.Sprintf("%s", aws.TimeValue(detail.LaunchTime))
+		}
+		status.Statuses = append(status.Statuses, hostStatus)
+	}
+	return status
+}
 
+// elbStatus reports the instances behind a classic ELB, an ALB or NLB, or a
+// target group ARN.
+func elbStatus(elbName string, lbs *core.LoadBalancers, ec2Service ec2iface.EC2API) *Status {
 	statuses := make([]HostStatus, 0)
 
 	status := &Status{
@@ -109,16 +269,19 @@ func elbStatus(elbName string, service *elb.ELB, ec2Service *ec2.EC2) *Status {
 		Error:    nil,
 	}
 
-	lbResp, err := service.DescribeInstanceHealth(req)
+	health, err := lbs.Health(elbName)
 	if err != nil {
 		status.Error = err
 		return status
 	}
+	if len(health) == 0 {
+		return status
+	}
 
 	instanceIds := make([]*string, 0)
 
-	for _, state := range lbResp.InstanceStates {
-		instanceIds = append(instanceIds, state.InstanceId)
+	for _, state := range health {
+		instanceIds = append(instanceIds, aws.String(state.InstanceId))
 	}
 
 	instanceReq := &ec2.DescribeInstancesInput{
@@ -159,30 +322,25 @@ func elbStatus(elbName string, service *elb.ELB, ec2Service *ec2.EC2) *Status {
 		amisNames[*ami.ImageId] = *ami.Name
 	}
 
-	for _, state := range lbResp.InstanceStates {
-		res, _ := publicNames[*state.InstanceId]
-		amiId, _ := amis[*state.InstanceId]
+	for _, state := range health {
+		res, _ := publicNames[state.InstanceId]
+		amiId, _ := amis[state.InstanceId]
 		amiName, _ := amisNames[amiId]
-		launchTime, _ := instanceLaunchTimes[*state.InstanceId]
-		privateDnsName, _ := privateDnsNames[*state.InstanceId]
-
-		parts := make([]string, 0)
-		imageVersion := ""
-		if lcNames[*state.InstanceId] != "" {
-			parts = strings.SplitAfterN(lcNames[*state.InstanceId], "-", 4)
-			imageVersion = parts[len(parts)-1]
-		} else {
-			parts = strings.SplitAfterN(amiName, "-", 4)
-			imageVersion = parts[2]
+		launchTime, _ := instanceLaunchTimes[state.InstanceId]
+		privateDnsName, _ := privateDnsNames[state.InstanceId]
+
+		imageVersion := amiVersion(amiName)
+		if lcNames[state.InstanceId] != "" {
+			imageVersion = lcVersion(lcNames[state.InstanceId])
 		}
 
 		hostStatus := HostStatus{
-			Version:        strings.TrimSuffix(imageVersion, "-"),
-			InstanceId:     *state.InstanceId,
-			State:          *state.State,
+			Version:        imageVersion,
+			InstanceId:     state.InstanceId,
+			State:          state.State,
 			Launched:       launchTime,
-			Description:    *state.Description,
-			Reason:         *state.ReasonCode,
+			Description:    state.Description,
+			Reason:         state.ReasonCode,
 			Hostname:       res,
 			PrivateDnsName: privateDnsName,
 		}
@@ -193,10 +351,18 @@ func elbStatus(elbName string, service *elb.ELB, ec2Service *ec2.EC2) *Status {
 }
 
 func printStatus(ui cli.ColoredUi, status *Status) {
-	ui.Info(status.ElbName)
+	if status.ElbName != "" {
+		ui.Info(status.Name())
+	} else {
+		ui.Info(fmt.Sprintf("%s (no load balancer)", status.Asg))
+	}
+	if len(status.Statuses) == 0 {
+		ui.Warn("\tNo instance.")
+		ui.Output("")
+	}
 	for _, status := range status.Statuses {
 
-		if status.State == "InService" {
+		if status.State == "InService" && (status.Reason == "" || status.Reason == "N/A") {
 			ui.Info(fmt.Sprintf("\tVersion: %s", status.Version))
 			ui.Info(fmt.Sprintf("\tID: %s", status.InstanceId))
 			ui.Info(fmt.Sprintf("\tState: %s", status.State))
@@ -204,7 +370,7 @@ func printStatus(ui cli.ColoredUi, status *Status) {
 			ui.Info(fmt.Sprintf("\tHostname: %s", status.Hostname))
 			ui.Info(fmt.Sprintf("\tPrivate DNS: %s", status.PrivateDnsName))
 
-		} else if status.Reason == "Instance is in pending state" {
+		} else if status.Reason == "Instance is in pending state" || strings.HasPrefix(status.State, "Pending") {
 			ui.Warn(fmt.Sprintf("\tVersion: %s", status.Version))
 			ui.Warn(fmt.Sprintf("\tID: %s", status.InstanceId))
 			ui.Warn(fmt.Sprintf("\tState: %s", status.State))
@@ -228,5 +394,5 @@ func printStatus(ui cli.ColoredUi, status *Status) {
 }
 
 func (c *StatusCommand) Synopsis() string {
-	return "See ELB status"
+	return "See the instances of each app, behind their ELBs or in their ASGs"
 }
@@ -1,69 +1,81 @@
 package command
 
 import (
-	"github.com/aws/aws-sdk-go/aws"
+	"flag"
 	"github.com/aws/aws-sdk-go/service/autoscaling"
 	"github.com/mitchellh/cli"
 	// "github.com/mitchellh/packer/packer"
 	"fmt"
 	// "sort"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/hello/sanders/core"
-	"strconv"
 	"strings"
+	"time"
 )
 
 type SunsetCommand struct {
 	Ui       cli.ColoredUi
+	Aws      *core.Clients
 	Notifier BasicNotifier
 	Apps     []core.SuripuApp
+	Envs     core.Environments
+	Lock     *Locking
 }
 
 func (c *SunsetCommand) Help() string {
-	helpText := `Usage: hello sunset`
+	helpText := `Usage: sanders sunset [-env prod] [-app name] [-asg name] [-force] [-yes] [-wait] [-timeout 15m]
+
+	Refuses to sunset unless the other ASG has the target number of
+	instances InService on the ELB, or InService and Healthy in the ASG for
+	apps without load balancer.
+
+	-env		Environment to sunset in (default prod).
+	-app		App to sunset. Prompts if omitted.
+	-asg		ASG to sunset. Prompts if omitted.
+	-force		Sunset even if not all ASGs are at desired capacity.
+	-yes		Don't ask for confirmation.
+	-wait		Wait for the other ASG to be healthy instead of refusing.
+	-timeout	How long to wait (default 15m).
+	` + planFlagsHelp
 	return strings.TrimSpace(helpText)
 }
 
 func (c *SunsetCommand) Run(args []string) int {
 
-	plan := `
-
-Plan:
---- ASG: %s
---- LC: %s
---- # of servers: %d
-
-`
-
-	config := &aws.Config{
-		Region: aws.String("us-east-1"),
+	cmdFlags := flag.NewFlagSet("sunset", flag.ContinueOnError)
+	cmdFlags.Usage = func() { c.Ui.Output(c.Help()) }
+	envName := envFlag(cmdFlags, "prod")
+	appName := cmdFlags.String("app", "", "app to sunset")
+	asgFlag := cmdFlags.String("asg", "", "autoscaling group to sunset")
+	force := cmdFlags.Bool("force", false, "override desired capacity check")
+	yes := cmdFlags.Bool("yes", false, "don't ask for confirmation")
+	wait := cmdFlags.Bool("wait", false, "wait for the other ASG to be healthy")
+	timeout := cmdFlags.Duration("timeout", 15*time.Minute, "time to wait for the other ASG")
+	planFlags := addPlanFlags(cmdFlags)
+	if err := cmdFlags.Parse(args); err != nil {
+		c.Ui.Error(fmt.Sprintf("%s", err))
+		return 1
 	}
 
-	service := autoscaling.New(session.New(), config)
-
-	c.Ui.Output("Which of the following apps do you want to sunset?\n")
-
-	for idx, app := range c.Apps {
-		c.Ui.Output(fmt.Sprintf("[%d] %s", idx, app.Name))
+	env, err := c.Envs.Get(*envName)
+	if err != nil {
+		c.Ui.Error(err.Error())
+		return 1
 	}
-	appSel, err := c.Ui.Ask("Select an app #: ")
-	appIdx, _ := strconv.Atoi(appSel)
 
-	if err != nil || appIdx >= len(c.Apps) {
-		c.Ui.Error(fmt.Sprintf("Incorrect app selection: %s\n", err))
+	service := c.Aws.AutoScaling
+	lbs := c.Aws.LoadBalancers()
+
+	appSelector := core.NewAppSelector(c.Ui, *appName)
+	selectedApp, err := appSelector.Choose(c.Apps)
+	if err != nil {
+		c.Ui.Error(err.Error())
 		return 1
 	}
 
-	c.Ui.Info(fmt.Sprintf("--> proceeding to sunset app: %s\n", c.Apps[appIdx].Name))
-
-	groupnames := make([]*string, 2)
-	one := fmt.Sprintf("%s-prod", c.Apps[appIdx].Name)
-	two := fmt.Sprintf("%s-prod-green", c.Apps[appIdx].Name)
-	groupnames[0] = &one
-	groupnames[1] = &two
+	c.Ui.Info(fmt.Sprintf("--> proceeding to sunset app: %s\n", selectedApp.Name))
 
 	describeASGreq := &autoscaling.DescribeAutoScalingGroupsInput{
-		AutoScalingGroupNames: groupnames,
+		AutoScalingGroupNames: env.GroupNames(selectedApp),
 	}
 
 	describeASGResp, err := service.DescribeAutoScalingGroups(describeASGreq)
@@ -81,90 +93,140 @@ Plan:
 		instancesPerASG[asgName] = asg
 	}
 
+	desiredCapacity := env.DesiredCapacity(selectedApp)
 	allASGsAtDesiredCapacity := true
-	c.Ui.Output(fmt.Sprintf("ASG matching app : %s\n", c.Apps[appIdx].Name))
+	c.Ui.Output(fmt.Sprintf("ASG matching app : %s\n", selectedApp.Name))
 	for idx, asgName := range asgs {
 		asg, _ := instancesPerASG[asgName]
-		parts := strings.Split(*asg.LaunchConfigurationName, "-prod-")
-		c.Ui.Info(fmt.Sprintf("[%d] %s (%d instances running %s)", idx, asgName, len(asg.Instances), parts[1]))
-		if len(asg.Instances) < int(c.Apps[appIdx].TargetDesiredCapacity) {
+		version := env.VersionFromLC(selectedApp, core.GroupLaunchRef(asg))
+		c.Ui.Info(fmt.Sprintf("[%d] %s (%d instances running %s)", idx, asgName, len(asg.Instances), version))
+		if len(asg.Instances) < int(desiredCapacity) {
 			allASGsAtDesiredCapacity = false
 		}
 	}
 
 	if allASGsAtDesiredCapacity == false {
 		c.Ui.Output("")
-		c.Ui.Error(fmt.Sprintf("All ASGs are not at desired capacity (%d). Ensure you have confirmed your deploy.", c.Apps[appIdx].TargetDesiredCapacity))
+		c.Ui.Error(fmt.Sprintf("All ASGs are not at desired capacity (%d). Ensure you have confirmed your deploy.", desiredCapacity))
 
 		c.Ui.Warn("Would you like to override and sunset an ASG anyway?")
-		ok, err := c.Ui.Ask("'ok' if you would like to override, anything else to cancel: ")
+		ok, err := askOk(c.Ui, *force, "'ok' if you would like to override, anything else to cancel: ")
 		if err != nil {
 			c.Ui.Error(fmt.Sprintf("%s", err))
 			return 1
 		}
 
-		if ok != "ok" {
+		if !ok {
 			c.Ui.Warn("Cancelled.")
 			return 0
 		}
 	}
 
-	c.Ui.Output("")
-	choiceStr, err := c.Ui.Ask("Choice: #")
-	if err != nil {
-		c.Ui.Error(fmt.Sprintf("%v", err))
+	sunsetAsg := *asgFlag
+	if sunsetAsg == "" {
+		c.Ui.Output("")
+		choiceStr, err := c.Ui.Ask("Choice: #")
+		if err != nil {
+			c.Ui.Error(fmt.Sprintf("%v", err))
+			return 1
+		}
+
+		choice, err := core.ParseSelection(choiceStr, len(asgs))
+		if err != nil {
+			c.Ui.Error(fmt.Sprintf("Incorrect ASG selection: %v", err))
+			return 1
+		}
+
+		sunsetAsg = asgs[choice]
+	}
+
+	asg, found := instancesPerASG[sunsetAsg]
+	if !found {
+		c.Ui.Error(fmt.Sprintf("ASG %s does not belong to %s", sunsetAsg, selectedApp.Name))
 		return 1
 	}
 
-	choice, _ := strconv.Atoi(choiceStr)
-	if choice >= len(asgs) {
-		c.Ui.Error(fmt.Sprintf("Error reading app #: %s", err))
+	if len(asg.Instances) == 0 {
+		c.Ui.Warn(fmt.Sprintf("ASG %s already has 0 instances, bailing.", sunsetAsg))
+		return 0
+	}
+
+	remainingAsg := otherGroup(describeASGResp.AutoScalingGroups, sunsetAsg)
+	if remainingAsg == "" {
+		c.Ui.Error(fmt.Sprintf("Refusing to sunset %s: no other ASG to take over", sunsetAsg))
 		return 1
 	}
 
-	sunsetAsg := asgs[choice]
+	elbName, err := healthElbName(service, selectedApp, env)
+	if err != nil {
+		c.Ui.Error(err.Error())
+		return 1
+	}
+	remainingLC := core.GroupLaunchRef(instancesPerASG[remainingAsg])
+	waiter := newHealthWaiter(c.Ui, service, lbs)
+
+	var report *core.HealthReport
+	if *wait {
+		report, err = waiter.Wait(remainingAsg, elbName, remainingLC, desiredCapacity, *timeout)
+	} else {
+		report, err = waiter.Check(remainingAsg, elbName, remainingLC)
+	}
+	if err != nil {
+		c.Ui.Error(err.Error())
+		return 1
+	}
 
-	asg := instancesPerASG[sunsetAsg]
+	if report.Healthy < desiredCapacity {
+		c.Ui.Error(fmt.Sprintf("Refusing to sunset %s, the other ASG is not healthy: %s", sunsetAsg, report))
+		c.Ui.Error("Use -wait to wait for it.")
+		return 1
+	}
+	c.Ui.Info(report.String())
 
-	if len(asg.Instances) == 0 {
-		c.Ui.Warn(fmt.Sprintf("ASG %s already has 0 instances, bailing.", sunsetAsg))
+	plan := core.NewPlan("sunset", selectedApp.Name, env.Name)
+	plan.Add(sunsetChange(asg))
+	stop, err := planFlags.show(c.Ui, plan)
+	if err != nil {
+		c.Ui.Error(err.Error())
+		return 1
+	}
+	if stop {
 		return 0
 	}
 
-	completePlan := fmt.Sprintf(plan, sunsetAsg, "N/A", 0)
-	c.Ui.Warn(completePlan)
+	unlock, err := c.Lock.acquire(c.Ui, selectedApp, env, "sunset", *timeout)
+	if err != nil {
+		c.Ui.Error(err.Error())
+		return 1
+	}
+	defer unlock()
 
-	ok, err := c.Ui.Ask("'ok' if you agree, anything else to cancel: ")
+	ok, err := approve(c.Ui, env, *yes, "'ok' if you agree, anything else to cancel: ")
 	if err != nil {
 		c.Ui.Error(fmt.Sprintf("%s", err))
 		return 1
 	}
 
-	if ok != "ok" {
+	if !ok {
 		c.Ui.Warn("Cancelled.")
 		return 0
 	}
 
-	numServers := int64(0)
-	updateReq := &autoscaling.UpdateAutoScalingGroupInput{
-		DesiredCapacity:      &numServers,
-		AutoScalingGroupName: &sunsetAsg,
-		MinSize:              &numServers,
-		MaxSize:              &numServers,
-	}
-
-	deployAction := NewDeployAction("sunset", sunsetAsg, "-", numServers)
+	deployAction := NewAsgAction("sunset", selectedApp, env, sunsetAsg, "-", *asg.DesiredCapacity, 0)
+	deployAction.PreviousLC = core.GroupLaunchRef(asg)
+	deployAction.Health = report.String()
 
-	c.Ui.Info("Executing plan:")
-	c.Ui.Info(fmt.Sprintf(plan, sunsetAsg, "N/A", *updateReq.DesiredCapacity))
-	_, err = service.UpdateAutoScalingGroup(updateReq)
+	c.Ui.Info("Executing plan...")
+	c.Notifier.Notify(deployAction)
+	err = sunsetASG(service, sunsetAsg)
 	if err != nil {
+		c.Notifier.Notify(deployAction.Failed(err))
 		c.Ui.Error(fmt.Sprintf("%s", err))
 		return 1
 	}
 	c.Ui.Info("Update autoscaling group request acknowledged")
 
-	c.Notifier.Notify(deployAction)
+	c.Notifier.Notify(deployAction.Succeeded())
 	c.Ui.Info("Run: `sanders status` to monitor servers being attached to ELB")
 	return 0
 }
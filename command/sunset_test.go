@@ -0,0 +1,69 @@
+package command
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/hello/sanders/core"
+	"github.com/hello/sanders/fakes"
+	"testing"
+)
+
+// confirmedCloud runs 2 instances of suripu-app 1.0.0 and 2 of 1.0.1 in
+// staging, ready to sunset 1.0.0.
+func confirmedCloud(elbName string) *fakes.Cloud {
+	cloud := stagingCloud(elbName)
+	cloud.AddGroup("suripu-app-staging-green", "suripu-app-staging-1.0.1", elbName, 2)
+	return cloud
+}
+
+func newSunsetCommand(cloud *fakes.Cloud) (*SunsetCommand, *recordingNotifier) {
+	notifier := &recordingNotifier{}
+	return &SunsetCommand{
+		Ui:       testUi(),
+		Aws:      cloud.Clients(),
+		Notifier: notifier,
+		Apps:     []core.SuripuApp{testApp},
+		Envs:     core.DefaultEnvironments,
+	}, notifier
+}
+
+func TestSunset(t *testing.T) {
+	for _, elbName := range []string{"suripu-app-staging", ""} {
+		cloud := confirmedCloud(elbName)
+		c, notifier := newSunsetCommand(cloud)
+
+		expectRun(t, c, c.Ui, 0, "-env", "staging", "-app", "suripu-app", "-asg", "suripu-app-staging", "-yes")
+
+		expectCapacity(t, cloud, "suripu-app-staging", 0, 0, 0)
+		expectLaunchRef(t, cloud, "suripu-app-staging", "suripu-app-staging-1.0.0")
+		expectCapacity(t, cloud, "suripu-app-staging-green", 2, 2, 4)
+		expectLaunchRef(t, cloud, "suripu-app-staging-green", "suripu-app-staging-1.0.1")
+
+		action := notifier.last(t, "sunset")
+		if action.Outcome != OutcomeSuccess || action.PreviousLC != "suripu-app-staging-1.0.0" || action.Health == "" {
+			t.Errorf("sunset with ELB %q notified %s of %s with health %q", elbName, action.Outcome, action.PreviousLC, action.Health)
+		}
+	}
+}
+
+func TestSunsetRefusesUnhealthyGroup(t *testing.T) {
+	cloud := confirmedCloud("suripu-app-staging")
+	cloud.SetHealth(*cloud.Groups["suripu-app-staging-green"].Instances[0].InstanceId, fakes.OutOfService)
+	c, notifier := newSunsetCommand(cloud)
+
+	expectRun(t, c, c.Ui, 1, "-env", "staging", "-app", "suripu-app", "-asg", "suripu-app-staging", "-yes")
+
+	expectCapacity(t, cloud, "suripu-app-staging", 2, 2, 4)
+	if len(notifier.actions) != 0 {
+		t.Errorf("sunset notified %v after refusing", notifier.actions)
+	}
+}
+
+func TestSunsetRefusesUnhealthyGroupWithoutLoadBalancer(t *testing.T) {
+	cloud := confirmedCloud("")
+	cloud.Groups["suripu-app-staging-green"].Instances[0].HealthStatus = aws.String("Unhealthy")
+	c, _ := newSunsetCommand(cloud)
+
+	expectRun(t, c, c.Ui, 1, "-env", "staging", "-app", "suripu-app", "-asg", "suripu-app-staging", "-yes")
+
+	expectCapacity(t, cloud, "suripu-app-staging", 2, 2, 4)
+}
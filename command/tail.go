@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
 	"github.com/hello/sanders/core"
 	"github.com/mitchellh/cli"
 	"strings"
@@ -13,11 +14,12 @@ import (
 type TailCommand struct {
 	Ui   cli.ColoredUi
 	Apps []core.SuripuApp
-	Srv  *ec2.EC2
+	Srv  ec2iface.EC2API
+	Envs core.Environments
 }
 
 func (c *TailCommand) Help() string {
-	helpText := `Usage: sanders tail`
+	helpText := `Usage: sanders tail [-query query] [-env prod] [-app name] [-instance id|ip]`
 	return strings.TrimSpace(helpText)
 }
 
@@ -26,12 +28,21 @@ func (c *TailCommand) Run(args []string) int {
 	cmdFlags := flag.NewFlagSet("tail", flag.ContinueOnError)
 	cmdFlags.Usage = func() { c.Ui.Output(c.Help()) }
 	var query = cmdFlags.String("query", "ERROR", "query to search in papertrail")
+	var envName = envFlag(cmdFlags, "prod")
+	var appName = cmdFlags.String("app", "", "app to tail")
+	var instance = cmdFlags.String("instance", "", "instance id or private ip to tail")
 	if err := cmdFlags.Parse(args); err != nil {
 		c.Ui.Error(fmt.Sprintf("%s", err))
 		return 1
 	}
 
-	appSelector := core.NewCliAppSelector(c.Ui)
+	env, err := c.Envs.Get(*envName)
+	if err != nil {
+		c.Ui.Error(err.Error())
+		return 1
+	}
+
+	appSelector := core.NewAppSelector(c.Ui, *appName)
 	selectedApp, err := appSelector.Choose(c.Apps)
 	if err != nil {
 		c.Ui.Error(err.Error())
@@ -43,7 +54,7 @@ func (c *TailCommand) Run(args []string) int {
 			&ec2.Filter{
 				Name: aws.String("tag:Name"),
 				Values: []*string{
-					aws.String(selectedApp.Name + "-prod"),
+					aws.String(env.InstanceName(selectedApp)),
 				},
 			},
 		},
@@ -63,7 +74,7 @@ func (c *TailCommand) Run(args []string) int {
 		}
 	}
 
-	selector := core.NewCliInstanceSelector(c.Ui)
+	selector := core.NewInstanceSelector(c.Ui, *instance)
 	selected, err := selector.Choose(instances)
 	if err != nil {
 		c.Ui.Error(err.Error())
@@ -0,0 +1,135 @@
+package command
+
+import (
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/autoscaling/autoscalingiface"
+	"github.com/hello/sanders/core"
+)
+
+// describeGroupsBatch is the most ASG names DescribeAutoScalingGroups takes
+// at once.
+const describeGroupsBatch = 50
+
+// statusTarget is what status and monitor look at for one app in one
+// environment: the load balancers its ASGs are attached to, or the ASGs
+// themselves when there are none. ElbNames holds classic ELB names and
+// target group ARNs.
+type statusTarget struct {
+	App      string
+	Env      string
+	ElbNames []string
+	Groups   []*autoscaling.Group
+}
+
+// labels names what the target shows, ELBs or ASGs.
+func (t *statusTarget) labels() []string {
+	names := make([]string, 0)
+	if len(t.ElbNames) > 0 {
+		for _, elbName := range t.ElbNames {
+			names = append(names, core.LoadBalancerLabel(elbName))
+		}
+		return names
+	}
+
+	for _, group := range t.Groups {
+		names = append(names, fmt.Sprintf("%s (no load balancer)", *group.AutoScalingGroupName))
+	}
+	return names
+}
+
+// describeGroupsByName returns the ASGs that exist among names, by name.
+func describeGroupsByName(service autoscalingiface.AutoScalingAPI, names []*string) (map[string]*autoscaling.Group, error) {
+	groups := make(map[string]*autoscaling.Group)
+	for start := 0; start < len(names); start += describeGroupsBatch {
+		end := start + describeGroupsBatch
+		if end > len(names) {
+			end = len(names)
+		}
+
+		err := service.DescribeAutoScalingGroupsPages(&autoscaling.DescribeAutoScalingGroupsInput{
+			AutoScalingGroupNames: names[start:end],
+		}, func(page *autoscaling.DescribeAutoScalingGroupsOutput, lastPage bool) bool {
+			for _, group := range page.AutoScalingGroups {
+				groups[*group.AutoScalingGroupName] = group
+			}
+			return true
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return groups, nil
+}
+
+// discoverTargets finds the load balancers of every registered app from the
+// LoadBalancerNames and TargetGroupARNs of its ASGs. Empty appName or envName match every app or
+// environment. Apps without ASGs in an environment are left out.
+func discoverTargets(service autoscalingiface.AutoScalingAPI, apps []core.SuripuApp, envs core.Environments, appName, envName string) ([]*statusTarget, error) {
+	selectedEnvs := envs
+	if envName != "" {
+		env, err := envs.Get(envName)
+		if err != nil {
+			return nil, err
+		}
+		selectedEnvs = core.Environments{*env}
+	}
+
+	selectedApps := make([]*core.SuripuApp, 0)
+	for idx := range apps {
+		if appName == "" || apps[idx].Name == appName {
+			selectedApps = append(selectedApps, &apps[idx])
+		}
+	}
+	if len(selectedApps) == 0 {
+		return nil, errors.New(fmt.Sprintf("Unknown app: %s", appName))
+	}
+
+	names := make([]*string, 0)
+	for idx := range selectedEnvs {
+		for _, app := range selectedApps {
+			names = append(names, selectedEnvs[idx].GroupNames(app)...)
+		}
+	}
+
+	groups, err := describeGroupsByName(service, names)
+	if err != nil {
+		return nil, err
+	}
+
+	targets := make([]*statusTarget, 0)
+	for idx := range selectedEnvs {
+		env := &selectedEnvs[idx]
+		for _, app := range selectedApps {
+			target := &statusTarget{
+				App:      app.Name,
+				Env:      env.Name,
+				ElbNames: make([]string, 0),
+				Groups:   make([]*autoscaling.Group, 0),
+			}
+
+			seen := make(map[string]bool)
+			for _, name := range env.GroupNames(app) {
+				group, found := groups[*name]
+				if !found {
+					continue
+				}
+				target.Groups = append(target.Groups, group)
+				refs := append(aws.StringValueSlice(group.LoadBalancerNames), aws.StringValueSlice(group.TargetGroupARNs)...)
+				for _, elbName := range refs {
+					if !seen[elbName] {
+						seen[elbName] = true
+						target.ElbNames = append(target.ElbNames, elbName)
+					}
+				}
+			}
+
+			if len(target.Groups) > 0 {
+				targets = append(targets, target)
+			}
+		}
+	}
+	return targets, nil
+}
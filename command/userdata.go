@@ -0,0 +1,398 @@
+package command
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/hello/sanders/core"
+	"github.com/mitchellh/cli"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type UserDataRenderCommand struct {
+	Ui        cli.ColoredUi
+	Generator *core.UserMetaDataGenerator
+	Region    string
+	Apps      []core.SuripuApp
+	Envs      core.Environments
+}
+
+func (c *UserDataRenderCommand) Help() string {
+	helpText := `Usage: sanders userdata render -app name -version 1.2.3 [-env prod]
+
+	Prints the user data create would give the instances of an app, decoded.
+	Nothing is created.
+
+	-app		App to render the user data of.
+	-version	Version of the app.
+	-env		Environment (default prod).`
+	return strings.TrimSpace(helpText)
+}
+
+func (c *UserDataRenderCommand) Run(args []string) int {
+	cmdFlags := flag.NewFlagSet("userdata render", flag.ContinueOnError)
+	cmdFlags.Usage = func() { c.Ui.Output(c.Help()) }
+	envName := envFlag(cmdFlags, "prod")
+	appName := cmdFlags.String("app", "", "app to render the user data of")
+	version := cmdFlags.String("version", "", "version of the app")
+	if err := cmdFlags.Parse(args); err != nil {
+		c.Ui.Error(fmt.Sprintf("%s", err))
+		return 1
+	}
+
+	if *appName == "" || *version == "" {
+		c.Ui.Error("-app and -version are required")
+		return 1
+	}
+
+	env, err := c.Envs.Get(*envName)
+	if err != nil {
+		c.Ui.Error(err.Error())
+		return 1
+	}
+
+	app, err := core.NewAppSelector(c.Ui, *appName).Choose(c.Apps)
+	if err != nil {
+		c.Ui.Error(err.Error())
+		return 1
+	}
+	if app.UsesPacker {
+		c.Ui.Error(fmt.Sprintf("%s uses packer AMIs, its instances don't get user data from sanders", app.Name))
+		return 1
+	}
+
+	userData, err := c.Generator.Render(core.NewUserMetaDataInput(app, env, *version, c.Region))
+	if err != nil {
+		c.Ui.Error(err.Error())
+		return 1
+	}
+	c.Ui.Output(userData)
+	return 0
+}
+
+func (c *UserDataRenderCommand) Synopsis() string {
+	return "Prints the user data of an app version"
+}
+
+// userDataSources are a user data template, its local file and what is on
+// S3.
+type userDataSources struct {
+	template *core.UserDataTemplate
+	file     string
+	local    []byte
+	remote   []byte
+	onS3     bool
+}
+
+// templateSource returns the local file of t: file when set, otherwise its
+// source, relative to the directory of the config file. Without a config
+// file there is nothing to resolve it against and file is required.
+func templateSource(t *core.UserDataTemplate, file, configPath string) (string, error) {
+	if file != "" {
+		return file, nil
+	}
+	if t.Source == "" {
+		return "", errors.New(fmt.Sprintf("User data template %s has no source file, use -file", t.Name))
+	}
+	if filepath.IsAbs(t.Source) {
+		return t.Source, nil
+	}
+	if _, err := os.Stat(configPath); os.IsNotExist(err) {
+		return "", errors.New(fmt.Sprintf("No config file at %s to find %s from, use -file", configPath, t.Source))
+	}
+	return filepath.Join(filepath.Dir(configPath), t.Source), nil
+}
+
+// loadUserData reads the local file of the named template, file when set,
+// and downloads the template from S3.
+func loadUserData(service s3iface.S3API, templates []core.UserDataTemplate, configPath, name, file string) (*userDataSources, error) {
+	t, err := core.FindUserDataTemplate(templates, name)
+	if err != nil {
+		return nil, err
+	}
+	file, err = templateSource(t, file, configPath)
+	if err != nil {
+		return nil, err
+	}
+
+	local, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	remote, onS3, err := core.FetchUserDataTemplate(service, t)
+	if err != nil {
+		return nil, err
+	}
+	return &userDataSources{template: t, file: file, local: local, remote: remote, onS3: onS3}, nil
+}
+
+// showHashes prints the hash of the local file, of the S3 object and the
+// pinned one.
+func (u *userDataSources) showHashes(ui cli.ColoredUi) {
+	remote := "(missing)"
+	if u.onS3 {
+		remote = core.UserDataSha256(u.remote)
+	}
+	pinned := u.template.Sha256
+	if pinned == "" {
+		pinned = "(none)"
+	}
+	ui.Output(fmt.Sprintf("%-8s %s  %s", "local:", core.UserDataSha256(u.local), u.file))
+	ui.Output(fmt.Sprintf("%-8s %s  %s", "s3:", remote, u.template.Location()))
+	ui.Output(fmt.Sprintf("%-8s %s", "pinned:", pinned))
+}
+
+// showDiff prints the lines removed from before in red and the ones added
+// in after in green.
+func showDiff(ui cli.ColoredUi, before, after []byte) {
+	a := strings.Split(strings.TrimSuffix(string(before), "\n"), "\n")
+	b := strings.Split(strings.TrimSuffix(string(after), "\n"), "\n")
+	if len(before) == 0 {
+		a = []string{}
+	}
+
+	// lcs[i][j] is the longest common subsequence of a[i:] and b[j:].
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			ui.Output("  " + a[i])
+			i++
+			j++
+		case j < len(b) && (i == len(a) || lcs[i][j+1] >= lcs[i+1][j]):
+			ui.Info("+ " + b[j])
+			j++
+		default:
+			ui.Error("- " + a[i])
+			i++
+		}
+	}
+}
+
+type UserDataDiffCommand struct {
+	Ui         cli.ColoredUi
+	S3         s3iface.S3API
+	Templates  []core.UserDataTemplate
+	ConfigPath string
+}
+
+func (c *UserDataDiffCommand) Help() string {
+	helpText := `Usage: sanders userdata diff [-name default] [-file path]
+
+	Shows what changed between a user data template on S3 and its local
+	file, and which one is pinned in the config file.
+
+	-name	User data template (default "default").
+	-file	Local file to compare, instead of the source of the template
+		(relative to the config file).`
+	return strings.TrimSpace(helpText)
+}
+
+func (c *UserDataDiffCommand) Run(args []string) int {
+	cmdFlags := flag.NewFlagSet("userdata diff", flag.ContinueOnError)
+	cmdFlags.Usage = func() { c.Ui.Output(c.Help()) }
+	name := cmdFlags.String("name", core.DefaultUserDataTemplate, "user data template")
+	file := cmdFlags.String("file", "", "local file to compare")
+	if err := cmdFlags.Parse(args); err != nil {
+		c.Ui.Error(fmt.Sprintf("%s", err))
+		return 1
+	}
+
+	sources, err := loadUserData(c.S3, c.Templates, c.ConfigPath, *name, *file)
+	if err != nil {
+		c.Ui.Error(err.Error())
+		return 1
+	}
+
+	sources.showHashes(c.Ui)
+	c.Ui.Output("")
+	if bytes.Equal(sources.local, sources.remote) {
+		c.Ui.Info(fmt.Sprintf("%s is identical to %s.", sources.file, sources.template.Location()))
+	} else {
+		c.Ui.Output(fmt.Sprintf("--- %s", sources.template.Location()))
+		c.Ui.Output(fmt.Sprintf("+++ %s", sources.file))
+		showDiff(c.Ui, sources.remote, sources.local)
+	}
+	if sources.onS3 && core.UserDataSha256(sources.remote) != sources.template.Sha256 {
+		c.Ui.Warn("The template on S3 isn't the pinned one, create will refuse to use it.")
+	}
+	return 0
+}
+
+func (c *UserDataDiffCommand) Synopsis() string {
+	return "Compares a user data template on S3 with its local file"
+}
+
+type UserDataPublishCommand struct {
+	Ui         cli.ColoredUi
+	S3         s3iface.S3API
+	Templates  []core.UserDataTemplate
+	ConfigPath string
+	Lock       *Locking
+}
+
+func (c *UserDataPublishCommand) Help() string {
+	helpText := `Usage: sanders userdata publish [-name default] [-file path] [-yes]
+
+	Uploads the local file of a user data template to S3 and pins its SHA-256
+	in the config file, so create accepts it.
+
+	-name	User data template (default "default").
+	-file	Local file to upload, instead of the source of the template
+		(relative to the config file).
+	-yes	Don't ask for confirmation.
+	` + planFlagsHelp
+	return strings.TrimSpace(helpText)
+}
+
+func (c *UserDataPublishCommand) Run(args []string) int {
+	cmdFlags := flag.NewFlagSet("userdata publish", flag.ContinueOnError)
+	cmdFlags.Usage = func() { c.Ui.Output(c.Help()) }
+	name := cmdFlags.String("name", core.DefaultUserDataTemplate, "user data template")
+	file := cmdFlags.String("file", "", "local file to upload")
+	yes := cmdFlags.Bool("yes", false, "don't ask for confirmation")
+	planFlags := addPlanFlags(cmdFlags)
+	if err := cmdFlags.Parse(args); err != nil {
+		c.Ui.Error(fmt.Sprintf("%s", err))
+		return 1
+	}
+
+	sources, err := loadUserData(c.S3, c.Templates, c.ConfigPath, *name, *file)
+	if err != nil {
+		c.Ui.Error(err.Error())
+		return 1
+	}
+	t := sources.template
+
+	if err := core.CheckUserDataTemplate(t.Name, string(sources.local)); err != nil {
+		c.Ui.Error(fmt.Sprintf("%s: %s", sources.file, err))
+		return 1
+	}
+
+	hash := core.UserDataSha256(sources.local)
+	upload := !sources.onS3 || !bytes.Equal(sources.local, sources.remote)
+	if !upload && t.Sha256 == hash {
+		c.Ui.Info(fmt.Sprintf("%s is already published and pinned (%s).", sources.file, hash))
+		return 0
+	}
+
+	if upload && !*planFlags.json {
+		c.Ui.Output(fmt.Sprintf("--- %s", t.Location()))
+		c.Ui.Output(fmt.Sprintf("+++ %s", sources.file))
+		showDiff(c.Ui, sources.remote, sources.local)
+		c.Ui.Output("")
+	}
+
+	plan := core.NewPlan("userdata publish", "", "")
+	if upload {
+		change := core.Change{
+			Resource: "s3_object",
+			Name:     t.Location(),
+			Action:   core.ActionCreate,
+			After:    map[string]string{"sha256": hash},
+		}
+		if sources.onS3 {
+			change.Action = core.ActionUpdate
+			change.Before = map[string]string{"sha256": core.UserDataSha256(sources.remote)}
+		}
+		plan.Add(change)
+	}
+	plan.Add(core.Change{
+		Resource: "user_data_template",
+		Name:     t.Name,
+		Action:   core.ActionUpdate,
+		Before:   map[string]string{"sha256": t.Sha256},
+		After:    map[string]string{"sha256": hash},
+	})
+	stop, err := planFlags.show(c.Ui, plan)
+	if err != nil {
+		c.Ui.Error(err.Error())
+		return 1
+	}
+	if stop {
+		return 0
+	}
+
+	if upload {
+		versioning, err := c.S3.GetBucketVersioning(&s3.GetBucketVersioningInput{
+			Bucket: aws.String(t.Bucket),
+		})
+		if err != nil {
+			c.Ui.Error(err.Error())
+			return 1
+		}
+		if aws.StringValue(versioning.Status) != s3.BucketVersioningStatusEnabled {
+			c.Ui.Warn(fmt.Sprintf("Versioning isn't enabled on %s, the current template can't be restored once replaced.", t.Bucket))
+		}
+	}
+
+	ok, err := askOk(c.Ui, *yes, "Type ok to publish.")
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("%v", err))
+		return 1
+	}
+	if !ok {
+		c.Ui.Warn("Cancelled.")
+		return 0
+	}
+
+	unlock, err := c.Lock.acquireNamed(c.Ui, "userdata", t.Name, "userdata publish", 0)
+	if err != nil {
+		c.Ui.Error(err.Error())
+		return 1
+	}
+	defer unlock()
+
+	if upload {
+		resp, err := c.S3.PutObject(&s3.PutObjectInput{
+			Body:     bytes.NewReader(sources.local),
+			Bucket:   aws.String(t.Bucket),
+			Key:      aws.String(t.Key),
+			Metadata: map[string]*string{"sha256": aws.String(hash)},
+		})
+		if err != nil {
+			c.Ui.Error(err.Error())
+			return 1
+		}
+		c.Ui.Info(fmt.Sprintf("Uploaded %s to %s (version %s).", sources.file, t.Location(), aws.StringValue(resp.VersionId)))
+	}
+
+	if _, err := os.Stat(c.ConfigPath); os.IsNotExist(err) {
+		c.Ui.Warn(fmt.Sprintf("No config file at %s. Add this to it so create accepts the new template:", c.ConfigPath))
+		c.Ui.Output(fmt.Sprintf("  \"user_data_templates\": [{\"name\": %q, \"sha256\": %q}]", t.Name, hash))
+		return 0
+	}
+	if err := core.PinUserDataTemplate(c.ConfigPath, t.Name, hash); err != nil {
+		c.Ui.Error(fmt.Sprintf("Published, but failed to pin %s in %s: %s", hash, c.ConfigPath, err))
+		return 1
+	}
+	c.Ui.Info(fmt.Sprintf("Pinned %s for %s in %s.", hash, t.Name, c.ConfigPath))
+	return 0
+}
+
+func (c *UserDataPublishCommand) Synopsis() string {
+	return "Uploads a user data template and pins its hash"
+}
@@ -0,0 +1,47 @@
+package command
+
+import (
+	"github.com/aws/aws-sdk-go/service/autoscaling/autoscalingiface"
+	"github.com/hello/sanders/core"
+	"github.com/hello/sanders/ui"
+	"github.com/mitchellh/cli"
+	"os"
+	"time"
+)
+
+func newHealthWaiter(cui cli.ColoredUi, service autoscalingiface.AutoScalingAPI, lbs *core.LoadBalancers) *core.HealthWaiter {
+	progressUi := &ui.ProgressUi{
+		Writer: os.Stdout,
+		Ui:     cui,
+	}
+	return core.NewHealthWaiter(progressUi, service, lbs)
+}
+
+// healthElbName returns the load balancer or target group ARN attached to
+// the ASGs of app in env that their health is read from, or "" when they
+// have none and the health waiter goes by the ASG health checks instead.
+func healthElbName(service autoscalingiface.AutoScalingAPI, app *core.SuripuApp, env *core.Environment) (string, error) {
+	targets, err := discoverTargets(service, []core.SuripuApp{*app}, core.Environments{*env}, app.Name, env.Name)
+	if err != nil {
+		return "", err
+	}
+	if len(targets) == 0 || len(targets[0].ElbNames) == 0 {
+		return "", nil
+	}
+	return targets[0].ElbNames[0], nil
+}
+
+// waitAndNotify waits for count instances of asgName running lcName to be
+// InService on elbName, or in the ASG when elbName is empty, then finishes action with the health of the ASG.
+func waitAndNotify(cui cli.ColoredUi, notifier BasicNotifier, action *DeployAction, service autoscalingiface.AutoScalingAPI, lbs *core.LoadBalancers, asgName, elbName, lcName string, count int64, timeout time.Duration) error {
+	report, err := newHealthWaiter(cui, service, lbs).Wait(asgName, elbName, lcName, count, timeout)
+	if report != nil {
+		action.Health = report.String()
+	}
+	if err != nil {
+		notifier.Notify(action.Failed(err))
+		return err
+	}
+	notifier.Notify(action.Succeeded())
+	return nil
+}
@@ -1,18 +1,10 @@
 package main
 
 import (
-	"fmt"
 	"os"
 	"os/signal"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/autoscaling"
-	"github.com/aws/aws-sdk-go/service/ec2"
-	"github.com/aws/aws-sdk-go/service/iam"
-	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/hello/sanders/command"
-	"github.com/hello/sanders/core"
 	"github.com/mitchellh/cli"
 )
 
@@ -22,12 +14,11 @@ var Commands map[string]cli.CommandFactory
 var (
 	// UIColorBlack is black color for colored UI
 	UIColorBlack = cli.UiColor{Code: 37, Bold: false}
-
-	//This hash should be updated anytime default_userdata.sh is updated on S3
-	expectedUserDataHash = "0011ed8a3aeaffa830620d16e39f84549cb0c6cb"
 )
 
-func init() {
+// initCommands registers every command. Commands are built lazily when they
+// run, so AWS and the config file are only touched by those needing them.
+func initCommands(globals map[string]string) {
 
 	cui := cli.ColoredUi{
 		InfoColor:  cli.UiColorGreen,
@@ -39,154 +30,382 @@ func init() {
 		},
 	}
 
-	sess := session.New()
-
-	config := &aws.Config{
-		Region: aws.String("us-east-1"),
-	}
-	s3KeyConfig := &aws.Config{
-		Region: aws.String("us-west-1"),
-	}
-
-	s3service := s3.New(sess, config)
-	asgService := autoscaling.New(sess, config)
-	ec2service := ec2.New(sess, config)
-	s3KeyService := s3.New(sess, s3KeyConfig)
-	iamService := iam.New(sess, config)
-
-	userDataGenerator := core.NewUserMetaDataGenerator(
-		expectedUserDataHash,
-		"hello-deploy",
-		"userdata/default_userdata.sh",
-		s3service,
-	)
-
-	keyService := core.NewS3KeyService(
-		s3KeyService,
-		ec2service,
-		"hello-keys",
-	)
-
-	amiSelector := core.NewSuripuAppAmiSelector(
-		cui,
-		ec2service,
-		s3service,
-		userDataGenerator,
-	)
-
-	fleetManager := core.NewFleetManager(cui, ec2service)
-	getUserReq := &iam.GetUserInput{}
-
-	resp, err := iamService.GetUser(getUserReq)
-
-	if err != nil {
-		cui.Ui.Error(fmt.Sprintln(err.Error()))
-		return
-	}
-
-	user := *resp.User.UserName
-	// cpui := ui.ProgressUi{
-	// 	Writer: os.Stdout,
-	// 	Ui:     cui,
-	// }
-
-	notifier := command.NewSlackNotifier(user)
+	path := configPath()
+	d := newDeps(cui, globals, path)
 
 	Commands = map[string]cli.CommandFactory{
-		"cancel-spot": func() (cli.Command, error) {
+		"apps list": d.lazy(&command.AppsListCommand{}, func() (cli.Command, error) {
+			config, source, err := d.Config()
+			if err != nil {
+				return nil, err
+			}
+			return &command.AppsListCommand{
+				Ui:     cui,
+				Apps:   config.Apps,
+				Envs:   config.Environments,
+				Source: source,
+			}, nil
+		}),
+		"apps validate": func() (cli.Command, error) {
+			return &command.AppsValidateCommand{
+				Ui:         cui,
+				ConfigPath: path,
+			}, nil
+		},
+		"base-ami list": d.lazy(&command.BaseAmiListCommand{}, func() (cli.Command, error) {
+			config, clients, err := d.ForQueries()
+			if err != nil {
+				return nil, err
+			}
+			return &command.BaseAmiListCommand{
+				Ui:   cui,
+				Aws:  clients,
+				Apps: config.Apps,
+				Envs: config.Environments,
+			}, nil
+		}),
+		"canary": d.lazy(&command.CanaryCommand{}, func() (cli.Command, error) {
+			config, clients, notifier, locking, err := d.ForMutations()
+			if err != nil {
+				return nil, err
+			}
+			amiSelector, err := d.AmiSelector()
+			if err != nil {
+				return nil, err
+			}
+			keyService, err := d.KeyService()
+			if err != nil {
+				return nil, err
+			}
+			return &command.CanaryCommand{
+				Ui:          cui,
+				Aws:         clients,
+				Notifier:    notifier,
+				AmiSelector: amiSelector,
+				KeyService:  keyService,
+				Apps:        config.Apps,
+				Envs:        config.Environments,
+				Lock:        locking,
+			}, nil
+		}),
+		"cancel-spot": d.lazy(&command.CancelCommand{}, func() (cli.Command, error) {
+			config, _, _, locking, err := d.ForMutations()
+			if err != nil {
+				return nil, err
+			}
+			fleetManager, err := d.FleetManager()
+			if err != nil {
+				return nil, err
+			}
 			return &command.CancelCommand{
 				Ui:           cui,
-				Notifier:     notifier,
-				Apps:         suripuApps,
+				Apps:         config.Apps,
 				FleetManager: fleetManager,
+				Lock:         locking,
 			}, nil
-		},
-		"clean": func() (cli.Command, error) {
+		}),
+		"clean": d.lazy(&command.CleanCommand{}, func() (cli.Command, error) {
+			config, clients, notifier, locking, err := d.ForMutations()
+			if err != nil {
+				return nil, err
+			}
+			keyService, err := d.KeyService()
+			if err != nil {
+				return nil, err
+			}
 			return &command.CleanCommand{
-				Ui:   cui,
-				Apps: suripuApps,
+				Ui:         cui,
+				Aws:        clients,
+				KeyService: keyService,
+				Apps:       config.Apps,
+				Envs:       config.Environments,
+				Notifier:   notifier,
+				Lock:       locking,
 			}, nil
-		},
-		"confirm": func() (cli.Command, error) {
+		}),
+		"confirm": d.lazy(&command.ConfirmCommand{}, func() (cli.Command, error) {
+			config, clients, notifier, locking, err := d.ForMutations()
+			if err != nil {
+				return nil, err
+			}
 			return &command.ConfirmCommand{
 				Ui:       cui,
+				Aws:      clients,
 				Notifier: notifier,
-				Apps:     suripuApps,
+				Apps:     config.Apps,
+				Envs:     config.Environments,
+				Lock:     locking,
 			}, nil
-		},
-		"create": func() (cli.Command, error) {
+		}),
+		"create": d.lazy(&command.CreateCommand{}, func() (cli.Command, error) {
+			config, clients, notifier, locking, err := d.ForMutations()
+			if err != nil {
+				return nil, err
+			}
+			amiSelector, err := d.AmiSelector()
+			if err != nil {
+				return nil, err
+			}
+			keyService, err := d.KeyService()
+			if err != nil {
+				return nil, err
+			}
 			return &command.CreateCommand{
 				Ui:          cui,
 				Notifier:    notifier,
 				AmiSelector: amiSelector,
 				KeyService:  keyService,
-				Ec2Service:  ec2service,
-				S3Service:   s3service,
-				AsgService:  asgService,
-				Apps:        suripuApps,
+				Ec2Service:  clients.EC2,
+				S3Service:   clients.S3,
+				AsgService:  clients.AutoScaling,
+				Apps:        config.Apps,
+				Envs:        config.Environments,
+				Lock:        locking,
 			}, nil
-		},
-		"deploy": func() (cli.Command, error) {
+		}),
+		"deploy": d.lazy(&command.DeployCommand{}, func() (cli.Command, error) {
+			config, clients, notifier, locking, err := d.ForMutations()
+			if err != nil {
+				return nil, err
+			}
 			return &command.DeployCommand{
 				Ui:       cui,
+				Aws:      clients,
 				Notifier: notifier,
-				Apps:     suripuApps,
+				Apps:     config.Apps,
+				Envs:     config.Environments,
+				Lock:     locking,
 			}, nil
-		},
-		"hosts": func() (cli.Command, error) {
+		}),
+		"history": d.lazy(&command.HistoryCommand{}, func() (cli.Command, error) {
+			store, err := d.HistoryStore()
+			if err != nil {
+				return nil, err
+			}
+			return &command.HistoryCommand{
+				Ui:    cui,
+				Store: store,
+			}, nil
+		}),
+		"hosts": d.lazy(&command.HostsCommand{}, func() (cli.Command, error) {
+			config, clients, err := d.ForQueries()
+			if err != nil {
+				return nil, err
+			}
 			return &command.HostsCommand{
-				Ui:       cui,
-				Notifier: notifier,
-				Apps:     suripuApps,
+				Ui:   cui,
+				Aws:  clients,
+				Apps: config.Apps,
+				Envs: config.Environments,
 			}, nil
-		},
-		"launch-spot": func() (cli.Command, error) {
+		}),
+		"launch-spot": d.lazy(&command.LaunchCommand{}, func() (cli.Command, error) {
+			config, _, notifier, locking, err := d.ForMutations()
+			if err != nil {
+				return nil, err
+			}
+			amiSelector, err := d.AmiSelector()
+			if err != nil {
+				return nil, err
+			}
+			keyService, err := d.KeyService()
+			if err != nil {
+				return nil, err
+			}
+			fleetManager, err := d.FleetManager()
+			if err != nil {
+				return nil, err
+			}
 			return &command.LaunchCommand{
 				Ui:           cui,
 				Notifier:     notifier,
 				AmiSelector:  amiSelector,
 				KeyService:   keyService,
-				Apps:         suripuApps,
+				Apps:         config.Apps,
 				FleetManager: fleetManager,
+				Envs:         config.Environments,
+				Lock:         locking,
 			}, nil
-		},
-		"monitor": func() (cli.Command, error) {
+		}),
+		"lc list": d.lazy(&command.LCListCommand{}, func() (cli.Command, error) {
+			config, clients, err := d.ForQueries()
+			if err != nil {
+				return nil, err
+			}
+			return &command.LCListCommand{
+				Ui:   cui,
+				Aws:  clients,
+				Apps: config.Apps,
+				Envs: config.Environments,
+			}, nil
+		}),
+		"lock status": d.lazy(&command.LockStatusCommand{}, func() (cli.Command, error) {
+			locker, err := d.Locker()
+			if err != nil {
+				return nil, err
+			}
+			return &command.LockStatusCommand{
+				Ui:     cui,
+				Locker: locker,
+			}, nil
+		}),
+		"monitor": d.lazy(&command.MonitorCommand{}, func() (cli.Command, error) {
+			config, clients, err := d.ForQueries()
+			if err != nil {
+				return nil, err
+			}
 			return &command.MonitorCommand{
+				Ui:   cui,
+				Aws:  clients,
+				Apps: config.Apps,
+				Envs: config.Environments,
+			}, nil
+		}),
+
+		"rollback": d.lazy(&command.RollbackCommand{}, func() (cli.Command, error) {
+			config, clients, notifier, locking, err := d.ForMutations()
+			if err != nil {
+				return nil, err
+			}
+			return &command.RollbackCommand{
 				Ui:       cui,
+				Aws:      clients,
 				Notifier: notifier,
-				Apps:     suripuApps,
+				Apps:     config.Apps,
+				Envs:     config.Environments,
+				Lock:     locking,
 			}, nil
-		},
+		}),
+		"rollout": d.lazy(&command.RolloutCommand{}, func() (cli.Command, error) {
+			config, clients, notifier, locking, err := d.ForMutations()
+			if err != nil {
+				return nil, err
+			}
+			return &command.RolloutCommand{
+				Ui:       cui,
+				Aws:      clients,
+				Notifier: notifier,
+				Apps:     config.Apps,
+				Envs:     config.Environments,
+				Lock:     locking,
+			}, nil
+		}),
 
-		"setup": func() (cli.Command, error) {
+		"setup": d.lazy(&command.SetupCommand{}, func() (cli.Command, error) {
+			config, clients, _, locking, err := d.ForMutations()
+			if err != nil {
+				return nil, err
+			}
+			accountId, err := d.AccountId()
+			if err != nil {
+				return nil, err
+			}
 			return &command.SetupCommand{
-				Ui:     cui,
-				Config: config,
-				Apps:   suripuApps,
+				Ui:        cui,
+				Aws:       clients,
+				AccountId: accountId,
+				Apps:      config.Apps,
+				Envs:      config.Environments,
+				Lock:      locking,
 			}, nil
-		},
-		"status": func() (cli.Command, error) {
+		}),
+		"status": d.lazy(&command.StatusCommand{}, func() (cli.Command, error) {
+			config, clients, err := d.ForQueries()
+			if err != nil {
+				return nil, err
+			}
 			return &command.StatusCommand{
-				Ui:       cui,
-				Notifier: notifier,
-				Apps:     suripuApps,
+				Ui:   cui,
+				Aws:  clients,
+				Apps: config.Apps,
+				Envs: config.Environments,
 			}, nil
-		},
-		"sunset": func() (cli.Command, error) {
+		}),
+		"sunset": d.lazy(&command.SunsetCommand{}, func() (cli.Command, error) {
+			config, clients, notifier, locking, err := d.ForMutations()
+			if err != nil {
+				return nil, err
+			}
 			return &command.SunsetCommand{
 				Ui:       cui,
+				Aws:      clients,
 				Notifier: notifier,
-				Apps:     suripuApps,
+				Apps:     config.Apps,
+				Envs:     config.Environments,
+				Lock:     locking,
 			}, nil
-		},
+		}),
 
-		"tail": func() (cli.Command, error) {
+		"tail": d.lazy(&command.TailCommand{}, func() (cli.Command, error) {
+			config, clients, err := d.ForQueries()
+			if err != nil {
+				return nil, err
+			}
 			return &command.TailCommand{
 				Ui:   cui,
-				Apps: suripuApps,
-				Srv:  ec2service,
+				Apps: config.Apps,
+				Srv:  clients.EC2,
+				Envs: config.Environments,
 			}, nil
-		},
+		}),
+
+		"unlock": d.lazy(&command.UnlockCommand{}, func() (cli.Command, error) {
+			locker, err := d.Locker()
+			if err != nil {
+				return nil, err
+			}
+			return &command.UnlockCommand{
+				Ui:     cui,
+				Locker: locker,
+			}, nil
+		}),
+
+		"userdata diff": d.lazy(&command.UserDataDiffCommand{}, func() (cli.Command, error) {
+			config, clients, err := d.ForQueries()
+			if err != nil {
+				return nil, err
+			}
+			return &command.UserDataDiffCommand{
+				Ui:         cui,
+				S3:         clients.S3,
+				Templates:  config.UserDataTemplates,
+				ConfigPath: path,
+			}, nil
+		}),
+		"userdata publish": d.lazy(&command.UserDataPublishCommand{}, func() (cli.Command, error) {
+			config, clients, _, locking, err := d.ForMutations()
+			if err != nil {
+				return nil, err
+			}
+			return &command.UserDataPublishCommand{
+				Ui:         cui,
+				S3:         clients.S3,
+				Templates:  config.UserDataTemplates,
+				ConfigPath: path,
+				Lock:       locking,
+			}, nil
+		}),
+		"userdata render": d.lazy(&command.UserDataRenderCommand{}, func() (cli.Command, error) {
+			config, _, err := d.Config()
+			if err != nil {
+				return nil, err
+			}
+			generator, err := d.UserDataGenerator()
+			if err != nil {
+				return nil, err
+			}
+			region, err := d.Region()
+			if err != nil {
+				return nil, err
+			}
+			return &command.UserDataRenderCommand{
+				Ui:        cui,
+				Generator: generator,
+				Region:    region,
+				Apps:      config.Apps,
+				Envs:      config.Environments,
+			}, nil
+		}),
 
 		"version": func() (cli.Command, error) {
 			return &command.VersionCommand{
@@ -1,22 +1,27 @@
 package core
 
 import (
+	"encoding/base64"
 	"fmt"
 	"github.com/aws/aws-sdk-go/aws"
 	// "github.com/aws/aws-sdk-go/aws/session"
 	// "github.com/aws/aws-sdk-go/service/autoscaling"
 	"errors"
 	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
 	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
 	"github.com/mitchellh/cli"
 	"sort"
-	"strconv"
 	"strings"
 	"time"
 )
 
+// AmiSelector picks the AMI (and user data) for a given app. When version is
+// empty the user is prompted for one. baseAmi, an AMI id or name pattern,
+// overrides the base AMI of apps installing their package at boot.
 type AmiSelector interface {
-	Select(app SuripuApp, environment string) (*SelectedAmi, error)
+	Select(app SuripuApp, env *Environment, version, baseAmi string) (*SelectedAmi, error)
 }
 
 type SuripuAppAmiSelector struct {
@@ -24,15 +29,18 @@ type SuripuAppAmiSelector struct {
 	lc     *LcAmiSelector
 }
 
-func (a *SuripuAppAmiSelector) Select(app SuripuApp, environment string) (*SelectedAmi, error) {
+func (a *SuripuAppAmiSelector) Select(app SuripuApp, env *Environment, version, baseAmi string) (*SelectedAmi, error) {
 	if app.UsesPacker {
-		return a.packer.Select(app, environment)
+		if baseAmi != "" {
+			return nil, errors.New(fmt.Sprintf("%s uses packer AMIs, they have no base AMI to override", app.Name))
+		}
+		return a.packer.Select(app, env, version)
 	}
 
-	return a.lc.Select(app, environment)
+	return a.lc.Select(app, env, version, baseAmi)
 }
 
-func NewSuripuAppAmiSelector(ui cli.ColoredUi, ec2service *ec2.EC2, s3service *s3.S3, userDataGenerator *UserMetaDataGenerator) *SuripuAppAmiSelector {
+func NewSuripuAppAmiSelector(ui cli.ColoredUi, ec2service ec2iface.EC2API, s3service s3iface.S3API, userDataGenerator *UserMetaDataGenerator, region string) *SuripuAppAmiSelector {
 	return &SuripuAppAmiSelector{
 		packer: &PackerAmiSelector{
 			Ui:         ui,
@@ -43,111 +51,198 @@ func NewSuripuAppAmiSelector(ui cli.ColoredUi, ec2service *ec2.EC2, s3service *s
 			ec2Service:        ec2service,
 			s3Service:         s3service,
 			userdataGenerator: userDataGenerator,
+			region:            region,
 		},
 	}
 }
 
 type LcAmiSelector struct {
 	Ui                cli.ColoredUi
-	ec2Service        *ec2.EC2
-	s3Service         *s3.S3
+	ec2Service        ec2iface.EC2API
+	s3Service         s3iface.S3API
 	userdataGenerator *UserMetaDataGenerator
+	// region is where instances run, the default_region of user data.
+	region string
 }
 
 type PackerAmiSelector struct {
 	Ui         cli.ColoredUi
-	ec2Service *ec2.EC2
+	ec2Service ec2iface.EC2API
 }
 
-func (a *LcAmiSelector) Select(app SuripuApp, environment string) (*SelectedAmi, error) {
-	canaryPath := ""
-	if environment == "canary" {
-		canaryPath = "canary/"
+func (a *LcAmiSelector) Select(app SuripuApp, env *Environment, version, baseAmi string) (*SelectedAmi, error) {
+	canaryPath := env.PackagePrefix
+
+	amiVersion := version
+	if amiVersion == "" || IsVersionPattern(amiVersion) {
+		selected, err := a.chooseVersion(app, canaryPath, amiVersion)
+		if err != nil {
+			return nil, err
+		}
+		amiVersion = selected
+	}
+
+	//Get the userdata template from S3 for instance startup using cloud-init
+	metadataInput := NewUserMetaDataInput(&app, env, amiVersion, a.region)
+
+	userData, err := a.userdataGenerator.Render(metadataInput)
+	if err != nil {
+		return nil, err
+	}
+
+	// Instances that can't download what they install never get InService,
+	// better find out before creating anything.
+	artifact, err := VerifyPackage(a.s3Service, &app, env, amiVersion)
+	if err != nil {
+		return nil, err
+	}
+	if artifact.Sha256 != "" {
+		a.Ui.Info(fmt.Sprintf("Verified %s (sha256 %s)", artifact.Location(), artifact.Sha256))
+	} else {
+		a.Ui.Warn(fmt.Sprintf("Found %s, no .sha256 to verify it against", artifact.Location()))
+	}
+	if err := VerifyUserDataObjects(a.s3Service, userData); err != nil {
+		return nil, err
+	}
+
+	settings := env.BaseAmiFor(&app)
+	if baseAmi != "" {
+		settings = ParseBaseAmi(baseAmi)
 	}
+	base, err := ResolveBaseAmi(a.ec2Service, settings)
+	if err != nil {
+		return nil, err
+	}
+
+	selectedAmi := SelectedAmi{
+		Id:       *base.ImageId,
+		Name:     fmt.Sprintf("a cloud-init deploy based on the AMI: %s", aws.StringValue(base.Name)),
+		BaseName: aws.StringValue(base.Name),
+		Version:  amiVersion,
+		UserData: base64.StdEncoding.EncodeToString([]byte(userData)),
+		Package:  artifact,
+	}
+	return &selectedAmi, nil
+}
+
+// packagePageSize is how many versions the package prompt shows at once.
+const packagePageSize = 10
+
+// PackageVersion is a package of an app on S3.
+type PackageVersion struct {
+	Version      string
+	Key          string
+	LastModified time.Time
+}
+
+// packageVersions lists the packages of app in hello-deploy, newest version
+// first. Only keys laid out as <prefix><version>/<app>_<version>_amd64.deb
+// count, so the canary packages don't show up for prod.
+func (a *LcAmiSelector) packageVersions(app SuripuApp, canaryPath string) ([]*PackageVersion, error) {
 	pkgPrefix := fmt.Sprintf("packages/%s/%s/%s", app.PackagePath, app.Name, canaryPath)
 
-	a.Ui.Info(pkgPrefix)
-	a.Ui.Output("")
 	//retrieve package list from S3 for selectedApp
 	s3ListParams := &s3.ListObjectsV2Input{
-		Bucket: aws.String("hello-deploy"), // Required
-		//Delimiter:    aws.String("/"),
-		//EncodingType: aws.String("EncodingType"),
-		//Marker:       aws.String("Marker"),
-		MaxKeys: aws.Int64(100000),
-		Prefix:  aws.String(pkgPrefix),
+		Bucket: aws.String(PackageBucket), // Required
+		Prefix: aws.String(pkgPrefix),
 	}
 
-	availablePackages := make([]*s3.Object, 0)
+	packages := make([]*PackageVersion, 0)
 
 	err := a.s3Service.ListObjectsV2Pages(s3ListParams,
 		func(page *s3.ListObjectsV2Output, lastPage bool) bool {
 			for _, item := range page.Contents {
-				if strings.HasSuffix(*item.Key, ".deb") {
-					availablePackages = append(availablePackages, item)
+				chunks := strings.Split(strings.TrimPrefix(*item.Key, pkgPrefix), "/")
+				if len(chunks) != 2 || chunks[1] != fmt.Sprintf("%s_%s_amd64.deb", app.Name, chunks[0]) {
+					continue
 				}
+				packages = append(packages, &PackageVersion{
+					Version:      chunks[0],
+					Key:          *item.Key,
+					LastModified: aws.TimeValue(item.LastModified),
+				})
 			}
-			return !lastPage
+			return true
 		})
 
 	if err != nil {
 		return nil, err
 	}
 
-	sort.Sort(sort.Reverse(ByObjectLastModified(availablePackages)))
-
-	versions := make([]string, 0)
+	sort.Sort(sort.Reverse(ByPackageVersion(packages)))
+	return packages, nil
+}
 
-	a.Ui.Info(fmt.Sprintf("Latest 10 packages available for %s:", app.Name))
-	a.Ui.Info(" #\tVersion:  \tLast Modified:")
-	a.Ui.Info("---|----------------|---------------")
-	numImages := Min(len(availablePackages), 10)
-	for idx := 0; idx < numImages; idx++ {
-		objectKeyChunks := strings.Split(*availablePackages[idx].Key, "/")
-		versionNumber := objectKeyChunks[len(objectKeyChunks)-2]
-		versions = append(versions, versionNumber)
-		a.Ui.Output(fmt.Sprintf("[%d]\t%s\t\t%s", idx, versionNumber, availablePackages[idx].LastModified.Format(time.UnixDate)))
+// chooseVersion prompts for one of the package versions matching pattern,
+// or any version when pattern is empty, a page at a time.
+func (a *LcAmiSelector) chooseVersion(app SuripuApp, canaryPath, pattern string) (string, error) {
+	packages, err := a.packageVersions(app, canaryPath)
+	if err != nil {
+		return "", err
 	}
 
-	ver, err := a.Ui.Ask("Select a version #: ")
-	verIdx, _ := strconv.Atoi(ver)
+	matching := make([]*PackageVersion, 0)
+	for _, pkg := range packages {
+		if pattern == "" || MatchVersion(pattern, pkg.Version) {
+			matching = append(matching, pkg)
+		}
+	}
 
-	if err != nil {
-		return nil, err
-	} else if verIdx >= len(availablePackages) {
-		return nil, errors.New(fmt.Sprintf("Incorrect AMI selection: %s\n", err))
+	switch {
+	case len(matching) == 0 && pattern != "":
+		return "", errors.New(fmt.Sprintf("No package of %s matches %s", app.Name, pattern))
+	case len(matching) == 0:
+		return "", errors.New(fmt.Sprintf("No package found for %s in packages/%s/%s/%s", app.Name, app.PackagePath, app.Name, canaryPath))
+	case len(matching) == 1 && pattern != "":
+		a.Ui.Info(fmt.Sprintf("Only %s matches %s", matching[0].Version, pattern))
+		return matching[0].Version, nil
 	}
 
-	amiVersion := versions[verIdx]
+	pages := (len(matching) + packagePageSize - 1) / packagePageSize
+	for page := 0; ; {
+		start := page * packagePageSize
+		end := Min(start+packagePageSize, len(matching))
 
-	//Get the userdata template from S3 for instance startup using cloud-init
-	metadataInput := UserMetaDataInput{
-		AmiVersion:    amiVersion,
-		AppName:       app.Name,
-		PackagePath:   app.PackagePath,
-		CanaryPath:    canaryPath,
-		DefaultRegion: "us-east-1",
-		JavaVersion:   app.JavaVersion,
-	}
+		a.Ui.Info(fmt.Sprintf("Packages available for %s, newest first (page %d/%d):", app.Name, page+1, pages))
+		a.Ui.Info(" #\tVersion:  \tLast Modified:")
+		a.Ui.Info("---|----------------|---------------")
+		for idx := start; idx < end; idx++ {
+			a.Ui.Output(fmt.Sprintf("[%d]\t%s\t\t%s", idx, matching[idx].Version, matching[idx].LastModified.Format(time.UnixDate)))
+		}
 
-	userData, err := a.userdataGenerator.Parse(&metadataInput)
-	if err != nil {
-		return nil, err
-	}
+		question := "Select a version #: "
+		if pages > 1 {
+			question = "Select a version # (n: next page, p: previous page): "
+		}
+		ver, err := a.Ui.Ask(question)
+		if err != nil {
+			return "", err
+		}
 
-	amiName := "a cloud-init deploy based on the AMI: Base-2016-12-02"
-	amiId := "ami-16d5ee01"
+		switch strings.TrimSpace(ver) {
+		case "n":
+			if page < pages-1 {
+				page++
+			}
+			continue
+		case "p":
+			if page > 0 {
+				page--
+			}
+			continue
+		}
 
-	selectedAmi := SelectedAmi{
-		Id:       amiId,
-		Name:     amiName,
-		Version:  amiVersion,
-		UserData: userData,
+		// Versions are numbered across pages, only the ones shown can be
+		// picked.
+		verIdx, err := ParseSelection(ver, end)
+		if err == nil && verIdx >= start {
+			return matching[verIdx].Version, nil
+		}
+		return "", errors.New(fmt.Sprintf("Incorrect version selection %q, expected a number between %d and %d", ver, start, end-1))
 	}
-	return &selectedAmi, nil
 }
 
-func (a *PackerAmiSelector) Select(app SuripuApp, environment string) (*SelectedAmi, error) {
+func (a *PackerAmiSelector) Select(app SuripuApp, env *Environment, version string) (*SelectedAmi, error) {
 	a.Ui.Warn(fmt.Sprintf("%s not yet handled by Packer-free deployment. Proceeding with Packer-created AMI selection.", app.Name))
 
 	ec2ParamsAll := &ec2.DescribeImagesInput{
@@ -178,6 +273,19 @@ func (a *PackerAmiSelector) Select(app SuripuApp, environment string) (*Selected
 
 	sort.Sort(sort.Reverse(ByImageTime(validImages)))
 
+	if version != "" {
+		for _, image := range validImages {
+			if packerAmiVersion(app, *image.Name) == version {
+				return &SelectedAmi{
+					Id:      *image.ImageId,
+					Name:    *image.Name,
+					Version: version,
+				}, nil
+			}
+		}
+		return nil, errors.New(fmt.Sprintf("No AMI found for %s version %s", app.Name, version))
+	}
+
 	a.Ui.Output("Which AMI should be used?")
 	numImages := Min(len(validImages), 10)
 	for idx := 0; idx < numImages; idx++ {
@@ -185,26 +293,35 @@ func (a *PackerAmiSelector) Select(app SuripuApp, environment string) (*Selected
 	}
 
 	ami, err := a.Ui.Ask("Select an AMI #: ")
-	amiIdx, _ := strconv.Atoi(ami)
-
-	if err != nil || amiIdx >= len(validImages) {
+	if err != nil {
+		return nil, err
+	}
+	amiIdx, err := ParseSelection(ami, numImages)
+	if err != nil {
 		return nil, err
 	}
 
 	amiName := *validImages[amiIdx].Name
 	amiId := *validImages[amiIdx].ImageId
-	//Parse out version number
-	amiNameInfo := strings.Split(amiName, "-")
-	amiVersion := amiNameInfo[2]
-	if app.Name == "taimurain" {
-		amiVersion = amiNameInfo[1]
-	}
 
 	selectedAmi := SelectedAmi{
 		Id:      amiId,
 		Name:    amiName,
-		Version: amiVersion,
+		Version: packerAmiVersion(app, amiName),
 	}
 
 	return &selectedAmi, nil
 }
+
+// packerAmiVersion parses the version number out of a packer AMI name.
+func packerAmiVersion(app SuripuApp, amiName string) string {
+	amiNameInfo := strings.Split(amiName, "-")
+	idx := 2
+	if app.Name == "taimurain" {
+		idx = 1
+	}
+	if len(amiNameInfo) <= idx {
+		return ""
+	}
+	return amiNameInfo[idx]
+}
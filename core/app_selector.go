@@ -4,7 +4,6 @@ import (
 	"errors"
 	"fmt"
 	"github.com/mitchellh/cli"
-	"strconv"
 )
 
 type AppSelector interface {
@@ -29,14 +28,38 @@ func (c *CliAppSelector) Choose(apps []SuripuApp) (*SuripuApp, error) {
 	}
 
 	appSel, err := c.Ui.Ask("Select an app #: ")
-	appIdx, _ := strconv.Atoi(appSel)
-
 	if err != nil {
 		return nil, err
-	} else if appIdx >= len(apps) {
-		return nil, errors.New(fmt.Sprintf("Incorrect app selection: %s\n", err))
+	}
+	appIdx, err := ParseSelection(appSel, len(apps))
+	if err != nil {
+		return nil, err
 	}
 
 	selectedApp := apps[appIdx]
 	return &selectedApp, nil
 }
+
+// FlagAppSelector picks the app by name, for use from scripts and CI.
+type FlagAppSelector struct {
+	Name string
+}
+
+func (f *FlagAppSelector) Choose(apps []SuripuApp) (*SuripuApp, error) {
+	for _, app := range apps {
+		if app.Name == f.Name {
+			selectedApp := app
+			return &selectedApp, nil
+		}
+	}
+	return nil, errors.New(fmt.Sprintf("Unknown app: %s", f.Name))
+}
+
+// NewAppSelector returns a FlagAppSelector when name is set and falls back
+// to prompting otherwise.
+func NewAppSelector(ui cli.ColoredUi, name string) AppSelector {
+	if name != "" {
+		return &FlagAppSelector{Name: name}
+	}
+	return NewCliAppSelector(ui)
+}
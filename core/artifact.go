@@ -0,0 +1,154 @@
+package core
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// PackageBucket holds the packages instances install at boot.
+const PackageBucket = "hello-deploy"
+
+// PackageChecksumTag is the ASG tag, propagated to instances, holding the
+// SHA-256 of the package they run.
+const PackageChecksumTag = "Package SHA256"
+
+// s3Reference matches the s3:// URLs of user data.
+var s3Reference = regexp.MustCompile(`s3://([a-z0-9.-]+)/([^\s"';|&]+)`)
+
+// PackageArtifact is the package a version of an app installs.
+type PackageArtifact struct {
+	Bucket string
+	Key    string
+	Size   int64
+	// Sha256 is the checksum from the .sha256 next to the package, empty
+	// when there is none.
+	Sha256 string
+}
+
+func (p *PackageArtifact) Location() string {
+	return fmt.Sprintf("s3://%s/%s", p.Bucket, p.Key)
+}
+
+// PackageKey is the key of the package of version of app in env, the one
+// the default user data downloads.
+func PackageKey(app *SuripuApp, env *Environment, version string) string {
+	return fmt.Sprintf("packages/%s/%s/%s%s/%s_%s_amd64.deb", app.PackagePath, app.Name, env.PackagePrefix, version, app.Name, version)
+}
+
+func isNotFound(err error) bool {
+	return err != nil && (strings.Contains(err.Error(), "NotFound") || strings.Contains(err.Error(), "NoSuchKey"))
+}
+
+// VerifyPackage makes sure the package of version of app in env exists. When
+// a <package>.sha256 sits next to it, the package is downloaded and must
+// match it.
+func VerifyPackage(srv s3iface.S3API, app *SuripuApp, env *Environment, version string) (*PackageArtifact, error) {
+	artifact := &PackageArtifact{
+		Bucket: PackageBucket,
+		Key:    PackageKey(app, env, version),
+	}
+
+	head, err := srv.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(artifact.Bucket),
+		Key:    aws.String(artifact.Key),
+	})
+	if isNotFound(err) {
+		return nil, errors.New(fmt.Sprintf("Package %s not found. Is %s the right version?", artifact.Location(), version))
+	}
+	if err != nil {
+		return nil, err
+	}
+	artifact.Size = aws.Int64Value(head.ContentLength)
+
+	expected, err := packageChecksum(srv, artifact.Bucket, artifact.Key)
+	if err != nil || expected == "" {
+		return artifact, err
+	}
+
+	resp, err := srv.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(artifact.Bucket),
+		Key:    aws.String(artifact.Key),
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, resp.Body); err != nil {
+		return nil, errors.New(fmt.Sprintf("Failed to download %s: %s", artifact.Location(), err))
+	}
+	if actual := fmt.Sprintf("%x", hash.Sum(nil)); actual != expected {
+		return nil, errors.New(fmt.Sprintf("%s has SHA-256 %s but its .sha256 says %s", artifact.Location(), actual, expected))
+	}
+	artifact.Sha256 = expected
+	return artifact, nil
+}
+
+// PackageChecksum returns the SHA-256 recorded next to the package of
+// version of app in env, empty when there is none.
+func PackageChecksum(srv s3iface.S3API, app *SuripuApp, env *Environment, version string) (string, error) {
+	return packageChecksum(srv, PackageBucket, PackageKey(app, env, version))
+}
+
+// packageChecksum reads <key>.sha256, in the format of sha256sum.
+func packageChecksum(srv s3iface.S3API, bucket, key string) (string, error) {
+	resp, err := srv.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key + ".sha256"),
+	})
+	if isNotFound(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	buf := new(bytes.Buffer)
+	if _, err := buf.ReadFrom(resp.Body); err != nil {
+		return "", err
+	}
+	fields := strings.Fields(buf.String())
+	if len(fields) == 0 || !sha256Regexp.MatchString(strings.ToLower(fields[0])) {
+		return "", errors.New(fmt.Sprintf("s3://%s/%s.sha256 doesn't hold a SHA-256", bucket, key))
+	}
+	return strings.ToLower(fields[0]), nil
+}
+
+// VerifyUserDataObjects makes sure every S3 object user data downloads
+// exists, like the kenko and papertrail packages. URLs built from shell
+// variables can't be checked and are skipped.
+func VerifyUserDataObjects(srv s3iface.S3API, userData string) error {
+	missing := make([]string, 0)
+	for _, match := range s3Reference.FindAllStringSubmatch(userData, -1) {
+		bucket, key := match[1], strings.TrimSuffix(match[2], "/")
+		if strings.ContainsAny(key, "${}*") || key == "" {
+			continue
+		}
+
+		_, err := srv.HeadObject(&s3.HeadObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key),
+		})
+		if isNotFound(err) {
+			missing = append(missing, match[0])
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if len(missing) > 0 {
+		return errors.New(fmt.Sprintf("The user data downloads missing objects: %s", strings.Join(missing, ", ")))
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package core
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"strings"
+)
+
+const (
+	DefaultRegion    = "us-east-1"
+	DefaultKeyRegion = "us-west-1"
+)
+
+// AwsContext carries the region and account sanders operates on. Every
+// command builds its AWS clients from it instead of hardcoding a region.
+type AwsContext struct {
+	Region    string
+	KeyRegion string
+	Profile   string
+	RoleArn   string
+
+	sess      *session.Session
+	accountId string
+	userName  string
+}
+
+// NewAwsContext creates the session for the given profile, assuming roleArn
+// when set.
+func NewAwsContext(region, keyRegion, profile, roleArn string) (*AwsContext, error) {
+	if region == "" {
+		region = DefaultRegion
+	}
+	if keyRegion == "" {
+		keyRegion = DefaultKeyRegion
+	}
+
+	sess, err := session.NewSessionWithOptions(session.Options{
+		Profile:           profile,
+		SharedConfigState: session.SharedConfigEnable,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if roleArn != "" {
+		sess = sess.Copy(&aws.Config{
+			Credentials: stscreds.NewCredentials(sess, roleArn),
+		})
+	}
+
+	return &AwsContext{
+		Region:    region,
+		KeyRegion: keyRegion,
+		Profile:   profile,
+		RoleArn:   roleArn,
+		sess:      sess,
+	}, nil
+}
+
+func (c *AwsContext) Session() *session.Session {
+	return c.sess
+}
+
+// Config is the client config for the region being managed.
+func (c *AwsContext) Config() *aws.Config {
+	return &aws.Config{
+		Region: aws.String(c.Region),
+	}
+}
+
+// KeyConfig is the client config for the bucket holding the key pairs.
+func (c *AwsContext) KeyConfig() *aws.Config {
+	return &aws.Config{
+		Region: aws.String(c.KeyRegion),
+	}
+}
+
+// AccountId returns the id of the account the credentials belong to.
+func (c *AwsContext) AccountId() (string, error) {
+	if err := c.resolveIdentity(); err != nil {
+		return "", err
+	}
+	return c.accountId, nil
+}
+
+// UserName returns the IAM user name, or the role session name when running
+// with assumed role credentials.
+func (c *AwsContext) UserName() (string, error) {
+	if err := c.resolveIdentity(); err != nil {
+		return "", err
+	}
+	return c.userName, nil
+}
+
+func (c *AwsContext) resolveIdentity() error {
+	if c.accountId != "" {
+		return nil
+	}
+
+	identity, err := sts.New(c.sess, c.Config()).GetCallerIdentity(&sts.GetCallerIdentityInput{})
+	if err != nil {
+		return err
+	}
+
+	c.accountId = *identity.Account
+
+	// GetUser only works with IAM user credentials.
+	userResp, err := iam.New(c.sess, c.Config()).GetUser(&iam.GetUserInput{})
+	if err == nil {
+		c.userName = *userResp.User.UserName
+		return nil
+	}
+
+	// arn:aws:sts::123456789012:assumed-role/role-name/session-name
+	parts := strings.Split(*identity.Arn, "/")
+	c.userName = parts[len(parts)-1]
+	return nil
+}
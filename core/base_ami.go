@@ -0,0 +1,122 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"sort"
+	"strings"
+)
+
+// BaseAmiSettings picks the AMI instances installing their package at boot
+// start from: a pinned id, or the latest image matching a name pattern and
+// tags.
+type BaseAmiSettings struct {
+	Id   string            `json:"id,omitempty"`
+	Name string            `json:"name,omitempty"`
+	Tags map[string]string `json:"tags,omitempty"`
+	// Owner is the account owning the images, self by default.
+	Owner string `json:"owner,omitempty"`
+}
+
+// DefaultBaseAmi is used by apps and environments that don't set one. It is
+// pinned so new base images are only picked up by opting in with base_ami
+// or -base-ami.
+var DefaultBaseAmi = BaseAmiSettings{Id: "ami-16d5ee01"}
+
+// SetupBaseAmi is the AMI of the placeholder launch configuration or template
+// setup creates, unless the app or environment sets a base AMI.
+var SetupBaseAmi = BaseAmiSettings{Id: "ami-d06267ba"}
+
+func (s *BaseAmiSettings) String() string {
+	if s.Id != "" {
+		return s.Id
+	}
+	query := make([]string, 0)
+	if s.Name != "" {
+		query = append(query, fmt.Sprintf("name=%s", s.Name))
+	}
+	keys := make([]string, 0, len(s.Tags))
+	for key := range s.Tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		query = append(query, fmt.Sprintf("tag:%s=%s", key, s.Tags[key]))
+	}
+	if s.Owner != "" {
+		query = append(query, fmt.Sprintf("owner=%s", s.Owner))
+	}
+	return strings.Join(query, ", ")
+}
+
+// ConfiguredBaseAmi returns the base AMI settings of app in env from the
+// config file, nil when neither sets one. The environment wins over the app,
+// like user data variables.
+func (e *Environment) ConfiguredBaseAmi(app *SuripuApp) *BaseAmiSettings {
+	if e.BaseAmi != nil {
+		return e.BaseAmi
+	}
+	return app.BaseAmi
+}
+
+// BaseAmiFor returns the base AMI settings of app in env, DefaultBaseAmi
+// unless the config file sets one.
+func (e *Environment) BaseAmiFor(app *SuripuApp) BaseAmiSettings {
+	if configured := e.ConfiguredBaseAmi(app); configured != nil {
+		return *configured
+	}
+	return DefaultBaseAmi
+}
+
+// ParseBaseAmi turns the -base-ami flag into settings: an AMI id pins it,
+// anything else is a name pattern.
+func ParseBaseAmi(flag string) BaseAmiSettings {
+	if strings.HasPrefix(flag, "ami-") {
+		return BaseAmiSettings{Id: flag}
+	}
+	return BaseAmiSettings{Name: flag}
+}
+
+// ResolveBaseAmi returns the image settings point at, the most recently
+// created one when several match.
+func ResolveBaseAmi(service ec2iface.EC2API, settings BaseAmiSettings) (*ec2.Image, error) {
+	input := &ec2.DescribeImagesInput{}
+	if settings.Id != "" {
+		input.ImageIds = []*string{aws.String(settings.Id)}
+	} else {
+		if settings.Name == "" && len(settings.Tags) == 0 {
+			return nil, errors.New("Base AMI settings need an id, a name or tags")
+		}
+		owner := settings.Owner
+		if owner == "" {
+			owner = "self"
+		}
+		input.Owners = []*string{aws.String(owner)}
+		if settings.Name != "" {
+			input.Filters = append(input.Filters, &ec2.Filter{
+				Name:   aws.String("name"),
+				Values: []*string{aws.String(settings.Name)},
+			})
+		}
+		for key, value := range settings.Tags {
+			input.Filters = append(input.Filters, &ec2.Filter{
+				Name:   aws.String("tag:" + key),
+				Values: []*string{aws.String(value)},
+			})
+		}
+	}
+
+	resp, err := service.DescribeImages(input)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Images) == 0 {
+		return nil, errors.New(fmt.Sprintf("No base AMI matches %s", settings.String()))
+	}
+
+	sort.Sort(sort.Reverse(ByImageTime(resp.Images)))
+	return resp.Images[0], nil
+}
@@ -0,0 +1,50 @@
+package core
+
+import (
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/autoscaling/autoscalingiface"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/aws/aws-sdk-go/service/elb"
+	"github.com/aws/aws-sdk-go/service/elb/elbiface"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+	"github.com/aws/aws-sdk-go/service/elbv2/elbv2iface"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+// Clients holds every AWS client sanders talks to. Commands and core types
+// only see the SDK interfaces, so the fakes package can stand in for AWS.
+type Clients struct {
+	AutoScaling autoscalingiface.AutoScalingAPI
+	EC2         ec2iface.EC2API
+	ELB         elbiface.ELBAPI
+	ELBv2       elbv2iface.ELBV2API
+	S3          s3iface.S3API
+	// KeyS3 is S3 in the key region, where key pairs are uploaded.
+	KeyS3    s3iface.S3API
+	DynamoDB dynamodbiface.DynamoDBAPI
+}
+
+// NewClients creates the clients for the region and account of awsContext.
+func NewClients(awsContext *AwsContext) *Clients {
+	sess := awsContext.Session()
+	config := awsContext.Config()
+
+	return &Clients{
+		AutoScaling: autoscaling.New(sess, config),
+		EC2:         ec2.New(sess, config),
+		ELB:         elb.New(sess, config),
+		ELBv2:       elbv2.New(sess, config),
+		S3:          s3.New(sess, config),
+		KeyS3:       s3.New(sess, awsContext.KeyConfig()),
+		DynamoDB:    dynamodb.New(sess, config),
+	}
+}
+
+// LoadBalancers reads instance health from classic ELBs, ALBs and NLBs.
+func (c *Clients) LoadBalancers() *LoadBalancers {
+	return NewLoadBalancers(c.ELB, c.ELBv2)
+}
@@ -0,0 +1,528 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultJavaVersion     = 8
+	defaultPackagePath     = "com/hello"
+	defaultDesiredCapacity = int64(1)
+)
+
+var appNameRegexp = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+var sha256Regexp = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// userDataVarRegexp matches variable names usable as {{.name}} in user data
+// templates.
+var userDataVarRegexp = regexp.MustCompile(`^[a-z_][a-z0-9_]*$`)
+
+// Config is the content of the sanders config file. Anything left out of the
+// file falls back to what is built into the binary.
+type Config struct {
+	Apps         []SuripuApp        `json:"apps"`
+	Environments Environments       `json:"environments,omitempty"`
+	History      *HistorySettings   `json:"history,omitempty"`
+	Lock         *LockSettings      `json:"lock,omitempty"`
+	Notifiers    []NotifierSettings `json:"notifiers,omitempty"`
+	// UserDataTemplates pins the user data templates on S3.
+	UserDataTemplates []UserDataTemplate `json:"user_data_templates,omitempty"`
+}
+
+// LoadConfig reads and validates the config file at path. Unknown keys are
+// rejected so typos don't silently turn into defaults.
+func LoadConfig(path string) (*Config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	config := &Config{}
+	decoder := json.NewDecoder(f)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(config); err != nil {
+		return nil, errors.New(fmt.Sprintf("Failed to parse %s: %s", path, err))
+	}
+
+	config.applyDefaults()
+
+	if errs := config.Validate(); len(errs) > 0 {
+		return nil, &ValidationError{Path: path, Errors: errs}
+	}
+	return config, nil
+}
+
+func (c *Config) applyDefaults() {
+	c.Environments = DefaultEnvironments.merge(c.Environments)
+	c.UserDataTemplates = mergeUserDataTemplates(DefaultUserDataTemplates, c.UserDataTemplates)
+
+	for idx := range c.Apps {
+		app := &c.Apps[idx]
+		if app.InstanceProfile == "" {
+			app.InstanceProfile = app.Name
+		}
+		if app.TargetDesiredCapacity == 0 {
+			app.TargetDesiredCapacity = defaultDesiredCapacity
+		}
+		if app.JavaVersion == 0 {
+			app.JavaVersion = defaultJavaVersion
+		}
+		if app.PackagePath == "" {
+			app.PackagePath = defaultPackagePath
+		}
+	}
+
+	for idx := range c.Notifiers {
+		if c.Notifiers[idx].Name == "" {
+			c.Notifiers[idx].Name = c.Notifiers[idx].Type
+		}
+	}
+}
+
+// Validate returns every problem found in the config rather than stopping at
+// the first one.
+func (c *Config) Validate() []error {
+	errs := make([]error, 0)
+
+	if len(c.Apps) == 0 {
+		errs = append(errs, errors.New("apps: at least one app is required"))
+	}
+
+	seen := make(map[string]bool)
+	for idx, app := range c.Apps {
+		prefix := fmt.Sprintf("apps[%d]", idx)
+		if app.Name != "" {
+			prefix = fmt.Sprintf("apps[%d] (%s)", idx, app.Name)
+		}
+
+		if !appNameRegexp.MatchString(app.Name) {
+			errs = append(errs, fmt.Errorf("%s: name must be lowercase alphanumeric with dashes", prefix))
+		}
+		if seen[app.Name] {
+			errs = append(errs, fmt.Errorf("%s: duplicate app name", prefix))
+		}
+		seen[app.Name] = true
+
+		if !strings.HasPrefix(app.SecurityGroup, "sg-") {
+			errs = append(errs, fmt.Errorf("%s: security_group must be a security group id (sg-...)", prefix))
+		}
+		if app.InstanceType == "" {
+			errs = append(errs, fmt.Errorf("%s: instance_type is required", prefix))
+		}
+		if app.TargetDesiredCapacity < 0 {
+			errs = append(errs, fmt.Errorf("%s: target_desired_capacity must be positive", prefix))
+		}
+		if app.JavaVersion < 7 {
+			errs = append(errs, fmt.Errorf("%s: java_version %d is not supported", prefix, app.JavaVersion))
+		}
+		if strings.HasPrefix(app.PackagePath, "/") || strings.HasSuffix(app.PackagePath, "/") {
+			errs = append(errs, fmt.Errorf("%s: package_path must not start or end with /", prefix))
+		}
+		if app.Spot != nil {
+			if _, err := strconv.ParseFloat(app.Spot.Price, 64); err != nil {
+				errs = append(errs, fmt.Errorf("%s: spot.price %q is not a number", prefix, app.Spot.Price))
+			}
+		}
+		errs = append(errs, validateUserDataVars(prefix, app.UserData)...)
+		errs = append(errs, validateBaseAmi(prefix, app.BaseAmi)...)
+	}
+
+	envSeen := make(map[string]bool)
+	for idx, env := range c.Environments {
+		prefix := fmt.Sprintf("environments[%d]", idx)
+		if env.Name != "" {
+			prefix = fmt.Sprintf("environments[%d] (%s)", idx, env.Name)
+		}
+
+		if !appNameRegexp.MatchString(env.Name) {
+			errs = append(errs, fmt.Errorf("%s: name must be lowercase alphanumeric with dashes", prefix))
+		}
+		if envSeen[env.Name] {
+			errs = append(errs, fmt.Errorf("%s: duplicate environment name", prefix))
+		}
+		envSeen[env.Name] = true
+
+		if len(env.GroupTemplates) == 0 || len(env.GroupTemplates) > 2 {
+			errs = append(errs, fmt.Errorf("%s: asg_names must have one or two entries", prefix))
+		}
+		for _, template := range env.GroupTemplates {
+			if !strings.Contains(template, "{app}") {
+				errs = append(errs, fmt.Errorf("%s: asg_names entry %q must contain {app}", prefix, template))
+			}
+		}
+		if !strings.Contains(env.ElbTemplate, "{app}") {
+			errs = append(errs, fmt.Errorf("%s: elb_name must contain {app}", prefix))
+		}
+		if env.PackagePrefix != "" && !strings.HasSuffix(env.PackagePrefix, "/") {
+			errs = append(errs, fmt.Errorf("%s: package_prefix must end with /", prefix))
+		}
+		if env.DefaultCapacity < 0 {
+			errs = append(errs, fmt.Errorf("%s: default_capacity must be positive", prefix))
+		}
+		errs = append(errs, validateUserDataVars(prefix, env.UserData)...)
+		errs = append(errs, validateBaseAmi(prefix, env.BaseAmi)...)
+		for appName, capacity := range env.Capacity {
+			if !seen[appName] {
+				errs = append(errs, fmt.Errorf("%s: capacity set for unknown app %s", prefix, appName))
+			}
+			if capacity < 0 {
+				errs = append(errs, fmt.Errorf("%s: capacity for %s must be positive", prefix, appName))
+			}
+		}
+	}
+
+	templateSeen := make(map[string]bool)
+	for idx, t := range c.UserDataTemplates {
+		prefix := fmt.Sprintf("user_data_templates[%d]", idx)
+		if t.Name != "" {
+			prefix = fmt.Sprintf("user_data_templates[%d] (%s)", idx, t.Name)
+		}
+
+		if !appNameRegexp.MatchString(t.Name) {
+			errs = append(errs, fmt.Errorf("%s: name must be lowercase alphanumeric with dashes", prefix))
+		}
+		if templateSeen[t.Name] {
+			errs = append(errs, fmt.Errorf("%s: duplicate user data template name", prefix))
+		}
+		templateSeen[t.Name] = true
+
+		if t.Sha256 != "" && !sha256Regexp.MatchString(t.Sha256) {
+			errs = append(errs, fmt.Errorf("%s: sha256 must be 64 lowercase hex characters", prefix))
+		}
+	}
+	for idx, app := range c.Apps {
+		if app.UserDataTemplate != "" && !templateSeen[app.UserDataTemplate] {
+			errs = append(errs, fmt.Errorf("apps[%d] (%s): unknown user_data_template %s", idx, app.Name, app.UserDataTemplate))
+		}
+	}
+
+	if c.History != nil {
+		switch c.History.Backend {
+		case "", "file":
+		case "s3":
+			if c.History.Bucket == "" {
+				errs = append(errs, errors.New("history: bucket is required for the s3 backend"))
+			}
+		case "dynamodb":
+			if c.History.Table == "" {
+				errs = append(errs, errors.New("history: table is required for the dynamodb backend"))
+			}
+		default:
+			errs = append(errs, fmt.Errorf("history: unknown backend %q (file, s3 or dynamodb)", c.History.Backend))
+		}
+	}
+
+	if c.Lock != nil {
+		switch c.Lock.Backend {
+		case "", "file":
+		case "dynamodb":
+			if c.Lock.Table == "" {
+				errs = append(errs, errors.New("lock: table is required for the dynamodb backend"))
+			}
+		default:
+			errs = append(errs, fmt.Errorf("lock: unknown backend %q (file or dynamodb)", c.Lock.Backend))
+		}
+		if c.Lock.TTL != "" {
+			if _, err := time.ParseDuration(c.Lock.TTL); err != nil {
+				errs = append(errs, fmt.Errorf("lock: ttl %q is not a duration (ex: 30m)", c.Lock.TTL))
+			}
+		}
+	}
+
+	for idx, notifier := range c.Notifiers {
+		prefix := fmt.Sprintf("notifiers[%d]", idx)
+		if notifier.Name != "" {
+			prefix = fmt.Sprintf("notifiers[%d] (%s)", idx, notifier.Name)
+		}
+
+		if notifier.Type == "" {
+			errs = append(errs, fmt.Errorf("%s: type is required", prefix))
+		}
+		for _, envName := range notifier.Envs {
+			if !envSeen[envName] {
+				errs = append(errs, fmt.Errorf("%s: unknown environment %s", prefix, envName))
+			}
+		}
+	}
+
+	return errs
+}
+
+type ValidationError struct {
+	Path   string
+	Errors []error
+}
+
+func (v *ValidationError) Error() string {
+	lines := make([]string, 0)
+	for _, err := range v.Errors {
+		lines = append(lines, "\t"+err.Error())
+	}
+	return fmt.Sprintf("%s is invalid:\n%s", v.Path, strings.Join(lines, "\n"))
+}
+
+// validateUserDataVars checks the user_data variables of an app or
+// environment.
+func validateUserDataVars(prefix string, vars map[string]string) []error {
+	errs := make([]error, 0)
+	names := make([]string, 0, len(vars))
+	for name := range vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if !userDataVarRegexp.MatchString(name) {
+			errs = append(errs, fmt.Errorf("%s: user_data variable %q must be lowercase alphanumeric with underscores", prefix, name))
+		}
+		for _, builtin := range UserDataBuiltins {
+			if name == builtin {
+				errs = append(errs, fmt.Errorf("%s: user_data variable %q is set by sanders", prefix, name))
+			}
+		}
+	}
+	return errs
+}
+
+// validateBaseAmi checks the base_ami of an app or environment.
+func validateBaseAmi(prefix string, settings *BaseAmiSettings) []error {
+	errs := make([]error, 0)
+	if settings == nil {
+		return errs
+	}
+	if settings.Id == "" && settings.Name == "" && len(settings.Tags) == 0 {
+		errs = append(errs, fmt.Errorf("%s: base_ami needs an id, a name or tags", prefix))
+	}
+	if settings.Id != "" && !strings.HasPrefix(settings.Id, "ami-") {
+		errs = append(errs, fmt.Errorf("%s: base_ami.id must be an AMI id (ami-...)", prefix))
+	}
+	if settings.Id != "" && (settings.Name != "" || len(settings.Tags) > 0) {
+		errs = append(errs, fmt.Errorf("%s: base_ami.id pins the AMI, name and tags can't be used with it", prefix))
+	}
+	return errs
+}
+
+// mergeUserDataTemplates overrides the default templates with the ones from
+// the config file, matching by name. Fields left out of an override keep
+// their default, and templates of their own are stored as
+// userdata/<name>.sh in hello-deploy.
+func mergeUserDataTemplates(defaults, overrides []UserDataTemplate) []UserDataTemplate {
+	merged := make([]UserDataTemplate, 0)
+	merged = append(merged, defaults...)
+
+	for _, override := range overrides {
+		t := UserDataTemplate{
+			Name:   override.Name,
+			Bucket: "hello-deploy",
+			Key:    fmt.Sprintf("userdata/%s.sh", override.Name),
+			Source: fmt.Sprintf("resources/%s.sh", override.Name),
+		}
+		replaced := -1
+		for idx := range merged {
+			if merged[idx].Name == override.Name {
+				t = merged[idx]
+				replaced = idx
+			}
+		}
+
+		if override.Bucket != "" {
+			t.Bucket = override.Bucket
+		}
+		if override.Key != "" {
+			t.Key = override.Key
+		}
+		if override.Source != "" {
+			t.Source = override.Source
+		}
+		t.Sha256 = override.Sha256
+
+		if replaced >= 0 {
+			merged[replaced] = t
+		} else {
+			merged = append(merged, t)
+		}
+	}
+	return merged
+}
+
+// PinUserDataTemplate records sha256 as the accepted hash of the named user
+// data template in the config file at path. Only that hash is changed, the
+// rest of the file is kept byte for byte.
+func PinUserDataTemplate(path, name, sha256 string) error {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	out, err := pinUserDataTemplate(content, name, sha256)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Failed to parse %s: %s", path, err))
+	}
+	return ioutil.WriteFile(path, out, 0644)
+}
+
+func pinUserDataTemplate(content []byte, name, sha256 string) ([]byte, error) {
+	keys, spans, err := jsonValues(content)
+	if err != nil {
+		return nil, err
+	}
+
+	for idx, key := range keys {
+		if key != "user_data_templates" {
+			continue
+		}
+		list := spans[idx]
+		_, entries, err := jsonValues(content[list.start:list.end])
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			start, end := list.start+entry.start, list.start+entry.end
+			t := UserDataTemplate{}
+			if err := json.Unmarshal(content[start:end], &t); err != nil {
+				return nil, err
+			}
+			if t.Name != name {
+				continue
+			}
+			pinned, err := setJSONMember(content[start:end], "sha256", sha256)
+			if err != nil {
+				return nil, err
+			}
+			return spliceJSON(content, start, end, pinned), nil
+		}
+
+		templates, err := appendJSONValue(content[list.start:list.end], "", UserDataTemplate{Name: name, Sha256: sha256})
+		if err != nil {
+			return nil, err
+		}
+		return spliceJSON(content, list.start, list.end, templates), nil
+	}
+
+	return appendJSONValue(content, "user_data_templates", []UserDataTemplate{{Name: name, Sha256: sha256}})
+}
+
+// jsonSpan is where a value starts and ends in a JSON document.
+type jsonSpan struct {
+	start, end int
+}
+
+// jsonValues returns the spans of the values of the JSON object or array
+// raw, in order, with their keys when raw is an object.
+func jsonValues(raw []byte) ([]string, []jsonSpan, error) {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	delim, err := dec.Token()
+	if err != nil {
+		return nil, nil, err
+	}
+	isObject := delim == json.Delim('{')
+	if !isObject && delim != json.Delim('[') {
+		return nil, nil, errors.New(fmt.Sprintf("expected an object or an array, found %v", delim))
+	}
+
+	keys := make([]string, 0)
+	spans := make([]jsonSpan, 0)
+	for dec.More() {
+		key := ""
+		if isObject {
+			token, err := dec.Token()
+			if err != nil {
+				return nil, nil, err
+			}
+			key = token.(string)
+		}
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			return nil, nil, err
+		}
+		end := int(dec.InputOffset())
+		keys = append(keys, key)
+		spans = append(spans, jsonSpan{start: end - len(value), end: end})
+	}
+	return keys, spans, nil
+}
+
+// setJSONMember sets key to value in the JSON object raw, in place when
+// raw already has it.
+func setJSONMember(raw []byte, key string, value interface{}) ([]byte, error) {
+	keys, spans, err := jsonValues(raw)
+	if err != nil {
+		return nil, err
+	}
+	for idx := range keys {
+		if keys[idx] == key {
+			encoded, err := json.Marshal(value)
+			if err != nil {
+				return nil, err
+			}
+			return spliceJSON(raw, spans[idx].start, spans[idx].end, encoded), nil
+		}
+	}
+	return appendJSONValue(raw, key, value)
+}
+
+// appendJSONValue adds value after the last one of the JSON object raw under
+// key, or of the JSON array raw when key is empty, laid out like the first
+// one.
+func appendJSONValue(raw []byte, key string, value interface{}) ([]byte, error) {
+	_, spans, err := jsonValues(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	open := bytes.IndexAny(raw, "{[")
+	first := open + 1
+	for first < len(raw) && strings.ContainsRune(" \t\r\n", rune(raw[first])) {
+		first++
+	}
+	separator := string(raw[open+1 : first])
+
+	var encoded []byte
+	if newline := strings.LastIndex(separator, "\n"); newline >= 0 {
+		encoded, err = json.MarshalIndent(value, separator[newline+1:], "  ")
+	} else {
+		encoded, err = json.Marshal(value)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	member := ""
+	if key != "" {
+		name, err := json.Marshal(key)
+		if err != nil {
+			return nil, err
+		}
+		member = string(name) + ":"
+		if separator != "" {
+			member += " "
+		}
+	}
+	member += string(encoded)
+
+	if len(spans) == 0 {
+		return spliceJSON(raw, open+1, open+1, []byte(member)), nil
+	}
+	last := spans[len(spans)-1].end
+	return spliceJSON(raw, last, last, []byte(","+separator+member)), nil
+}
+
+// spliceJSON returns raw with the bytes from start to end replaced.
+func spliceJSON(raw []byte, start, end int, replacement []byte) []byte {
+	out := make([]byte, 0, len(raw)-(end-start)+len(replacement))
+	out = append(out, raw[:start]...)
+	out = append(out, replacement...)
+	return append(out, raw[end:]...)
+}
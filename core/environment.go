@@ -0,0 +1,202 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"strings"
+)
+
+// Environment describes how an app is laid out in one environment. Name
+// templates use {app} and {env} as placeholders.
+type Environment struct {
+	Name             string           `json:"name"`
+	GroupTemplates   []string         `json:"asg_names"`
+	ElbTemplate      string           `json:"elb_name"`
+	PackagePrefix    string           `json:"package_prefix"`
+	DefaultCapacity  int64            `json:"default_capacity,omitempty"`
+	Capacity         map[string]int64 `json:"capacity,omitempty"`
+	RequiresApproval bool             `json:"requires_approval"`
+	// UserData are variables for the user data template of every app in
+	// the environment. They override the ones of the app.
+	UserData map[string]string `json:"user_data,omitempty"`
+	// BaseAmi is the AMI instances start from in the environment,
+	// overriding the one of the app.
+	BaseAmi *BaseAmiSettings `json:"base_ami,omitempty"`
+}
+
+// DefaultEnvironments are available even without a config file.
+var DefaultEnvironments = Environments{
+	{
+		Name:             "prod",
+		GroupTemplates:   []string{"{app}-prod", "{app}-prod-green"},
+		ElbTemplate:      "{app}-prod",
+		RequiresApproval: true,
+	},
+	{
+		Name:             "canary",
+		GroupTemplates:   []string{"{app}-canary"},
+		ElbTemplate:      "{app}-canary",
+		PackagePrefix:    "canary/",
+		DefaultCapacity:  1,
+		RequiresApproval: true,
+	},
+	{
+		Name:           "staging",
+		GroupTemplates: []string{"{app}-staging", "{app}-staging-green"},
+		ElbTemplate:    "{app}-staging",
+	},
+	{
+		Name:           "dev",
+		GroupTemplates: []string{"{app}-dev", "{app}-dev-green"},
+		ElbTemplate:    "{app}-dev",
+	},
+}
+
+func (e *Environment) expand(template string, app *SuripuApp) string {
+	name := strings.Replace(template, "{app}", app.Name, -1)
+	return strings.Replace(name, "{env}", e.Name, -1)
+}
+
+// GroupNames returns the ASG names of the app, blue first.
+func (e *Environment) GroupNames(app *SuripuApp) []*string {
+	names := make([]*string, 0)
+	for _, template := range e.GroupTemplates {
+		names = append(names, aws.String(e.expand(template, app)))
+	}
+	return names
+}
+
+func (e *Environment) ElbName(app *SuripuApp) string {
+	return e.expand(e.ElbTemplate, app)
+}
+
+// InstanceName is the Name tag of instances of the app.
+func (e *Environment) InstanceName(app *SuripuApp) string {
+	return fmt.Sprintf("%s-%s", app.Name, e.Name)
+}
+
+func (e *Environment) LaunchConfigName(app *SuripuApp, version string) string {
+	return fmt.Sprintf("%s-%s-%s", app.Name, e.Name, version)
+}
+
+// LaunchTemplateName is the launch template of the app. Each create adds a
+// version to it.
+func (e *Environment) LaunchTemplateName(app *SuripuApp) string {
+	return fmt.Sprintf("%s-%s", app.Name, e.Name)
+}
+
+// VersionFromLC extracts the version from a launch configuration name built
+// by LaunchConfigName. Launch template references are returned as is, their
+// version lives in the version description.
+func (e *Environment) VersionFromLC(app *SuripuApp, lcName string) string {
+	if IsLaunchTemplateRef(lcName) {
+		return lcName
+	}
+	return strings.TrimPrefix(lcName, fmt.Sprintf("%s-%s-", app.Name, e.Name))
+}
+
+// UserDataVars are the user data template variables of app in this
+// environment.
+func (e *Environment) UserDataVars(app *SuripuApp) map[string]string {
+	vars := make(map[string]string)
+	for key, value := range app.UserData {
+		vars[key] = value
+	}
+	for key, value := range e.UserData {
+		vars[key] = value
+	}
+	return vars
+}
+
+// DesiredCapacity is the number of instances the app runs once confirmed.
+func (e *Environment) DesiredCapacity(app *SuripuApp) int64 {
+	if capacity, found := e.Capacity[app.Name]; found {
+		return capacity
+	}
+	if e.DefaultCapacity > 0 {
+		return e.DefaultCapacity
+	}
+	return app.TargetDesiredCapacity
+}
+
+type Environments []Environment
+
+func (envs Environments) Get(name string) (*Environment, error) {
+	for idx := range envs {
+		if envs[idx].Name == name {
+			return &envs[idx], nil
+		}
+	}
+
+	names := make([]string, 0)
+	for _, env := range envs {
+		names = append(names, env.Name)
+	}
+	return nil, errors.New(fmt.Sprintf("Unknown environment %s. Known environments: %s", name, strings.Join(names, ", ")))
+}
+
+// AppForLC returns the app a launch configuration or launch template version
+// of this environment was created for. The longest matching app name wins so
+// suripu-app doesn't shadow suripu-app-foo.
+func (e *Environment) AppForLC(apps []SuripuApp, lcName string) (*SuripuApp, error) {
+	if name, _, ok := ParseLaunchTemplateRef(lcName); ok {
+		for idx := range apps {
+			if e.LaunchTemplateName(&apps[idx]) == name {
+				return &apps[idx], nil
+			}
+		}
+		return nil, errors.New(fmt.Sprintf("No app matching launch template %s in %s", lcName, e.Name))
+	}
+
+	var found *SuripuApp
+	for idx := range apps {
+		prefix := fmt.Sprintf("%s-%s-", apps[idx].Name, e.Name)
+		if strings.HasPrefix(lcName, prefix) && (found == nil || len(apps[idx].Name) > len(found.Name)) {
+			found = &apps[idx]
+		}
+	}
+	if found == nil {
+		return nil, errors.New(fmt.Sprintf("No app matching launch configuration %s in %s", lcName, e.Name))
+	}
+	return found, nil
+}
+
+// LCOwner returns the app and environment a launch configuration name built
+// by LaunchConfigName belongs to, matching <app>-<env>- exactly. The longest
+// match wins so suripu-app-canary-1.0 is never taken for suripu-app in an
+// environment named canary when an app suripu-app-canary exists in another.
+// ok is false for launch configurations of unknown apps or environments.
+func (envs Environments) LCOwner(apps []SuripuApp, lcName string) (app *SuripuApp, env *Environment, ok bool) {
+	longest := 0
+	for envIdx := range envs {
+		for appIdx := range apps {
+			prefix := envs[envIdx].LaunchConfigName(&apps[appIdx], "")
+			if len(prefix) > longest && len(lcName) > len(prefix) && strings.HasPrefix(lcName, prefix) {
+				app, env, longest = &apps[appIdx], &envs[envIdx], len(prefix)
+			}
+		}
+	}
+	return app, env, app != nil
+}
+
+// merge overrides the default environments with the ones from the config
+// file, matching by name.
+func (envs Environments) merge(overrides Environments) Environments {
+	merged := make(Environments, 0)
+	merged = append(merged, envs...)
+
+	for _, override := range overrides {
+		replaced := false
+		for idx := range merged {
+			if merged[idx].Name == override.Name {
+				merged[idx] = override
+				replaced = true
+			}
+		}
+		if !replaced {
+			merged = append(merged, override)
+		}
+	}
+	return merged
+}
@@ -0,0 +1,159 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/autoscaling/autoscalingiface"
+	"github.com/hello/sanders/ui"
+	"time"
+)
+
+// HealthWaiter polls an ASG and its load balancer until enough instances
+// running a given launch configuration are InService.
+type HealthWaiter struct {
+	ui       *ui.ProgressUi
+	asg      autoscalingiface.AutoScalingAPI
+	lbs      *LoadBalancers
+	Interval time.Duration
+}
+
+func NewHealthWaiter(progressUi *ui.ProgressUi, asg autoscalingiface.AutoScalingAPI, lbs *LoadBalancers) *HealthWaiter {
+	return &HealthWaiter{
+		ui:       progressUi,
+		asg:      asg,
+		lbs:      lbs,
+		Interval: 10 * time.Second,
+	}
+}
+
+type HealthReport struct {
+	AsgName  string
+	ElbName  string
+	LCName   string
+	Healthy  int64
+	Launched int64
+	Activity string
+	Failed   bool
+}
+
+func (h *HealthReport) String() string {
+	lc := h.LCName
+	if lc == "" {
+		lc = "any LC"
+	}
+	if h.ElbName == "" {
+		return fmt.Sprintf("%s: %d/%d instances (%s) InService and Healthy", h.AsgName, h.Healthy, h.Launched, lc)
+	}
+	return fmt.Sprintf("%s: %d/%d instances (%s) InService on %s", h.AsgName, h.Healthy, h.Launched, lc, LoadBalancerLabel(h.ElbName))
+}
+
+// Check reports how many instances of asgName running lcName are InService on
+// elbName. An empty lcName matches every instance of the ASG. An empty elbName
+// is for ASGs without load balancer: instances count once the ASG has them
+// InService and Healthy.
+func (w *HealthWaiter) Check(asgName, elbName, lcName string) (*HealthReport, error) {
+	report := &HealthReport{
+		AsgName: asgName,
+		ElbName: elbName,
+		LCName:  lcName,
+	}
+
+	asgResp, err := w.asg.DescribeAutoScalingGroups(&autoscaling.DescribeAutoScalingGroupsInput{
+		AutoScalingGroupNames: []*string{aws.String(asgName)},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(asgResp.AutoScalingGroups) == 0 {
+		return nil, errors.New(fmt.Sprintf("ASG not found: %s", asgName))
+	}
+
+	instances := make(map[string]bool)
+	for _, instance := range asgResp.AutoScalingGroups[0].Instances {
+		if lcName != "" && InstanceLaunchRef(instance) != lcName {
+			continue
+		}
+		instances[*instance.InstanceId] = true
+	}
+	report.Launched = int64(len(instances))
+
+	activityResp, err := w.asg.DescribeScalingActivities(&autoscaling.DescribeScalingActivitiesInput{
+		AutoScalingGroupName: aws.String(asgName),
+		MaxRecords:           aws.Int64(1),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(activityResp.Activities) > 0 {
+		activity := activityResp.Activities[0]
+		report.Activity = fmt.Sprintf("%s: %s", *activity.StatusCode, aws.StringValue(activity.Description))
+		report.Failed = *activity.StatusCode == autoscaling.ScalingActivityStatusCodeFailed
+	}
+
+	if len(instances) == 0 {
+		return report, nil
+	}
+
+	if elbName == "" {
+		for _, instance := range asgResp.AutoScalingGroups[0].Instances {
+			if instances[*instance.InstanceId] &&
+				aws.StringValue(instance.LifecycleState) == autoscaling.LifecycleStateInService &&
+				aws.StringValue(instance.HealthStatus) == "Healthy" {
+				report.Healthy++
+			}
+		}
+		return report, nil
+	}
+
+	// Instances not yet registered with the ELB make DescribeInstanceHealth
+	// fail when listed explicitly, so fetch everything and filter.
+	health, err := w.lbs.Health(elbName)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, state := range health {
+		if instances[state.InstanceId] && state.State == "InService" {
+			report.Healthy++
+		}
+	}
+
+	return report, nil
+}
+
+// Wait blocks until count instances of asgName running lcName are InService
+// on elbName, or until timeout expires.
+func (w *HealthWaiter) Wait(asgName, elbName, lcName string, count int64, timeout time.Duration) (*HealthReport, error) {
+	deadline := time.Now().Add(timeout)
+	start := time.Now()
+
+	for {
+		report, err := w.Check(asgName, elbName, lcName)
+		if err != nil {
+			return nil, err
+		}
+
+		w.ui.Progress(fmt.Sprintf("[%s] %d/%d InService, %d launched. %s", time.Since(start)/time.Second*time.Second, report.Healthy, count, report.Launched, report.Activity))
+
+		if report.Healthy >= count {
+			w.ui.Output("")
+			w.ui.Info(report.String())
+			return report, nil
+		}
+
+		if report.Failed {
+			w.ui.Output("")
+			w.ui.Warn(report.Activity)
+		}
+
+		if time.Now().After(deadline) {
+			w.ui.Output("")
+			return report, errors.New(fmt.Sprintf("Timed out after %s waiting for %d instances of %s to be InService", timeout, count, asgName))
+		}
+		time.Sleep(w.Interval)
+	}
+}
@@ -0,0 +1,351 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// HistoryEntry is one change made by sanders, as stored in the audit log.
+type HistoryEntry struct {
+	Id             string    `json:"id,omitempty"`
+	At             time.Time `json:"at"`
+	User           string    `json:"user"`
+	Type           string    `json:"type"`
+	App            string    `json:"app"`
+	Env            string    `json:"env,omitempty"`
+	Asg            string    `json:"asg,omitempty"`
+	LC             string    `json:"lc,omitempty"`
+	PreviousLC     string    `json:"previous_lc,omitempty"`
+	CapacityBefore int64     `json:"capacity_before"`
+	CapacityAfter  int64     `json:"capacity_after"`
+	Duration       string    `json:"duration,omitempty"`
+	Health         string    `json:"health,omitempty"`
+	Outcome        string    `json:"outcome"`
+	Error          string    `json:"error,omitempty"`
+}
+
+// HistoryFilter narrows down History results. Empty fields match everything.
+type HistoryFilter struct {
+	Id    string
+	App   string
+	Env   string
+	Limit int
+}
+
+func (f *HistoryFilter) matches(entry *HistoryEntry) bool {
+	if f.Id != "" && entry.Id != f.Id {
+		return false
+	}
+	if f.App != "" && entry.App != f.App {
+		return false
+	}
+	if f.Env != "" && entry.Env != f.Env {
+		return false
+	}
+	return true
+}
+
+// apply filters entries and returns the newest first, up to Limit.
+func (f *HistoryFilter) apply(entries []HistoryEntry) []HistoryEntry {
+	filtered := make([]HistoryEntry, 0)
+	for idx := range entries {
+		if f.matches(&entries[idx]) {
+			filtered = append(filtered, entries[idx])
+		}
+	}
+
+	sort.Sort(sort.Reverse(ByEntryTime(filtered)))
+
+	if f.Limit > 0 && len(filtered) > f.Limit {
+		filtered = filtered[:f.Limit]
+	}
+	return filtered
+}
+
+type ByEntryTime []HistoryEntry
+
+func (a ByEntryTime) Len() int           { return len(a) }
+func (a ByEntryTime) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a ByEntryTime) Less(i, j int) bool { return a[i].At.Before(a[j].At) }
+
+// HistoryStore is an append-only log of HistoryEntry.
+type HistoryStore interface {
+	Append(entry *HistoryEntry) error
+	List(filter *HistoryFilter) ([]HistoryEntry, error)
+}
+
+// HistorySettings is the history section of the config file.
+type HistorySettings struct {
+	Backend string `json:"backend"`
+	Path    string `json:"path,omitempty"`
+	Bucket  string `json:"bucket,omitempty"`
+	Prefix  string `json:"prefix,omitempty"`
+	Table   string `json:"table,omitempty"`
+	// URL links notifications to a page showing the entry, {id} is
+	// replaced with its id.
+	URL string `json:"url,omitempty"`
+}
+
+// Link points to the history entry id: the url of the settings, or the
+// command showing it.
+func (s *HistorySettings) Link(id string) string {
+	if s == nil || s.URL == "" {
+		return fmt.Sprintf("sanders history -id %s", id)
+	}
+	return strings.Replace(s.URL, "{id}", id, -1)
+}
+
+// NewHistoryStore builds the store described by settings. A nil settings
+// means the local file in ~/.sanders.
+func NewHistoryStore(settings *HistorySettings, clients *Clients) (HistoryStore, error) {
+	if settings == nil {
+		settings = &HistorySettings{Backend: "file"}
+	}
+
+	switch settings.Backend {
+	case "", "file":
+		path := settings.Path
+		if path == "" {
+			path = filepath.Join(os.Getenv("HOME"), ".sanders", "history.jsonl")
+		}
+		return &FileHistoryStore{Path: path}, nil
+	case "s3":
+		return &S3HistoryStore{
+			service: clients.S3,
+			Bucket:  settings.Bucket,
+			Prefix:  settings.Prefix,
+		}, nil
+	case "dynamodb":
+		return &DynamoHistoryStore{
+			service: clients.DynamoDB,
+			Table:   settings.Table,
+		}, nil
+	}
+	return nil, errors.New(fmt.Sprintf("Unknown history backend: %s", settings.Backend))
+}
+
+// FileHistoryStore appends entries as JSON lines to a local file.
+type FileHistoryStore struct {
+	Path string
+}
+
+func (s *FileHistoryStore) Append(entry *HistoryEntry) error {
+	if err := os.MkdirAll(filepath.Dir(s.Path), 0755); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(s.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(line, '\n'))
+	return err
+}
+
+func (s *FileHistoryStore) List(filter *HistoryFilter) ([]HistoryEntry, error) {
+	f, err := os.Open(s.Path)
+	if os.IsNotExist(err) {
+		return []HistoryEntry{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	entries := make([]HistoryEntry, 0)
+	scanner := bufio.NewScanner(f)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		entry := HistoryEntry{}
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, errors.New(fmt.Sprintf("%s:%d: %s", s.Path, lineNum, err))
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return filter.apply(entries), nil
+}
+
+// S3HistoryStore writes one object per entry, so concurrent writers never
+// overwrite each other: <prefix>/<app>/<timestamp>-<user>-<type>.json
+type S3HistoryStore struct {
+	service s3iface.S3API
+	Bucket  string
+	Prefix  string
+}
+
+func (s *S3HistoryStore) appPrefix(app string) string {
+	if s.Prefix == "" {
+		return app + "/"
+	}
+	return fmt.Sprintf("%s/%s/", s.Prefix, app)
+}
+
+func (s *S3HistoryStore) Append(entry *HistoryEntry) error {
+	body, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	key := fmt.Sprintf("%s%s-%s-%s.json", s.appPrefix(entry.App), entry.At.UTC().Format(time.RFC3339Nano), entry.User, entry.Type)
+	_, err = s.service.PutObject(&s3.PutObjectInput{
+		Bucket:      aws.String(s.Bucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(body),
+		ContentType: aws.String("application/json"),
+	})
+	return err
+}
+
+func (s *S3HistoryStore) List(filter *HistoryFilter) ([]HistoryEntry, error) {
+	prefix := s.Prefix
+	if filter.App != "" {
+		prefix = s.appPrefix(filter.App)
+	}
+
+	keys := make([]string, 0)
+	err := s.service.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket: aws.String(s.Bucket),
+		Prefix: aws.String(prefix),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, obj := range page.Contents {
+			keys = append(keys, *obj.Key)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Within an app, keys start with the timestamp so the newest are last
+	// and only those need fetching.
+	sort.Strings(keys)
+	if filter.App != "" && filter.Env == "" && filter.Limit > 0 && len(keys) > filter.Limit {
+		keys = keys[len(keys)-filter.Limit:]
+	}
+
+	entries := make([]HistoryEntry, 0)
+	for _, key := range keys {
+		resp, err := s.service.GetObject(&s3.GetObjectInput{
+			Bucket: aws.String(s.Bucket),
+			Key:    aws.String(key),
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		entry := HistoryEntry{}
+		err = json.NewDecoder(resp.Body).Decode(&entry)
+		resp.Body.Close()
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("s3://%s/%s: %s", s.Bucket, key, err))
+		}
+		entries = append(entries, entry)
+	}
+	return filter.apply(entries), nil
+}
+
+// DynamoHistoryStore stores entries in a table with "app" as hash key and
+// "at" (RFC3339 with nanoseconds) as range key.
+type DynamoHistoryStore struct {
+	service dynamodbiface.DynamoDBAPI
+	Table   string
+}
+
+// dynamoHistoryItem keeps the entry as JSON next to the keys so the table
+// doesn't need to change when HistoryEntry does.
+type dynamoHistoryItem struct {
+	App   string `dynamodbav:"app"`
+	At    string `dynamodbav:"at"`
+	Entry string `dynamodbav:"entry"`
+}
+
+func (s *DynamoHistoryStore) Append(entry *HistoryEntry) error {
+	body, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	item, err := dynamodbattribute.MarshalMap(&dynamoHistoryItem{
+		App:   entry.App,
+		At:    entry.At.UTC().Format(time.RFC3339Nano),
+		Entry: string(body),
+	})
+	if err != nil {
+		return err
+	}
+
+	// Entries are never overwritten.
+	_, err = s.service.PutItem(&dynamodb.PutItemInput{
+		TableName:           aws.String(s.Table),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(app)"),
+	})
+	return err
+}
+
+func (s *DynamoHistoryStore) List(filter *HistoryFilter) ([]HistoryEntry, error) {
+	items := make([]map[string]*dynamodb.AttributeValue, 0)
+
+	if filter.App != "" {
+		err := s.service.QueryPages(&dynamodb.QueryInput{
+			TableName:              aws.String(s.Table),
+			KeyConditionExpression: aws.String("app = :app"),
+			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+				":app": {S: aws.String(filter.App)},
+			},
+		}, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+			items = append(items, page.Items...)
+			return true
+		})
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		err := s.service.ScanPages(&dynamodb.ScanInput{
+			TableName: aws.String(s.Table),
+		}, func(page *dynamodb.ScanOutput, lastPage bool) bool {
+			items = append(items, page.Items...)
+			return true
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	entries := make([]HistoryEntry, 0)
+	for _, item := range items {
+		stored := dynamoHistoryItem{}
+		if err := dynamodbattribute.UnmarshalMap(item, &stored); err != nil {
+			return nil, err
+		}
+
+		entry := HistoryEntry{}
+		if err := json.Unmarshal([]byte(stored.Entry), &entry); err != nil {
+			return nil, errors.New(fmt.Sprintf("%s %s/%s: %s", s.Table, stored.App, stored.At, err))
+		}
+		entries = append(entries, entry)
+	}
+	return filter.apply(entries), nil
+}
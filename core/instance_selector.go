@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/mitchellh/cli"
-	"strconv"
 )
 
 type InstanceSelector interface {
@@ -33,14 +32,39 @@ func (c *CliInstanceSelector) Choose(instances []*ec2.Instance) (*ec2.Instance,
 	}
 
 	appSel, err := c.Ui.Ask("Select an instance #: ")
-	appIdx, _ := strconv.Atoi(appSel)
-
 	if err != nil {
 		return nil, err
-	} else if appIdx >= len(instances) {
-		return nil, errors.New(fmt.Sprintf("Incorrect app selection: %s\n", err))
+	}
+	appIdx, err := ParseSelection(appSel, len(instances))
+	if err != nil {
+		return nil, err
 	}
 
 	selected := instances[appIdx]
 	return selected, nil
 }
+
+// FlagInstanceSelector picks the instance matching the given instance id or
+// private ip.
+type FlagInstanceSelector struct {
+	Instance string
+}
+
+func (f *FlagInstanceSelector) Choose(instances []*ec2.Instance) (*ec2.Instance, error) {
+	for _, instance := range instances {
+		if *instance.InstanceId == f.Instance {
+			return instance, nil
+		}
+		if instance.PrivateIpAddress != nil && *instance.PrivateIpAddress == f.Instance {
+			return instance, nil
+		}
+	}
+	return nil, errors.New(fmt.Sprintf("Unknown instance: %s", f.Instance))
+}
+
+func NewInstanceSelector(ui cli.ColoredUi, instance string) InstanceSelector {
+	if instance != "" {
+		return &FlagInstanceSelector{Instance: instance}
+	}
+	return NewCliInstanceSelector(ui)
+}
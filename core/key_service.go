@@ -5,22 +5,27 @@ import (
 	"fmt"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
 	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
 	"strings"
 )
 
 type KeyService interface {
 	Upload(keyName string, selectedApp SuripuApp, environment string) (*KeyUploadResult, error)
 	CleanUp(uploadResult *KeyUploadResult) error
+	// Delete removes a key pair uploaded for app in environment, and its
+	// private key.
+	Delete(keyName string, selectedApp SuripuApp, environment string) error
 }
 
 type S3KeyService struct {
-	s3Service  *s3.S3
-	ec2Service *ec2.EC2
+	s3Service  s3iface.S3API
+	ec2Service ec2iface.EC2API
 	keyBucket  string
 }
 
-func NewS3KeyService(s3srv *s3.S3, ec2srv *ec2.EC2, keyBucket string) *S3KeyService {
+func NewS3KeyService(s3srv s3iface.S3API, ec2srv ec2iface.EC2API, keyBucket string) *S3KeyService {
 	return &S3KeyService{
 		s3Service:  s3srv,
 		ec2Service: ec2srv,
@@ -46,7 +51,7 @@ func (s *S3KeyService) Upload(keyName string, selectedApp SuripuApp, environment
 	}
 
 	//Upload key to S3
-	key := fmt.Sprintf("/%s/%s/%s.pem", environment, selectedApp.Name, *keyPairResp.KeyName)
+	key := KeyObjectPath(environment, selectedApp.Name, *keyPairResp.KeyName)
 
 	uploadResult, err := s.s3Service.PutObject(&s3.PutObjectInput{
 		Body:   strings.NewReader(*keyPairResp.KeyMaterial),
@@ -67,6 +72,18 @@ func (s *S3KeyService) Upload(keyName string, selectedApp SuripuApp, environment
 	return keyUploadResult, nil
 }
 
+// KeyObjectPath is where the private key of keyName is uploaded.
+func KeyObjectPath(environment, appName, keyName string) string {
+	return fmt.Sprintf("/%s/%s/%s.pem", environment, appName, keyName)
+}
+
+func (s *S3KeyService) Delete(keyName string, selectedApp SuripuApp, environment string) error {
+	return s.CleanUp(&KeyUploadResult{
+		KeyName: keyName,
+		Key:     KeyObjectPath(environment, selectedApp.Name, keyName),
+	})
+}
+
 func (s *S3KeyService) CleanUp(uploadResult *KeyUploadResult) error {
 
 	//Delete key from EC2
@@ -0,0 +1,113 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"strconv"
+	"strings"
+)
+
+// Launch templates are referred to as <template>:<version>, e.g.
+// suripu-app-prod:12, wherever a launch configuration name is expected. The
+// version description holds the app version the template version runs.
+
+// LaunchTemplateRef is the reference to a version of a launch template.
+func LaunchTemplateRef(name string, version int64) string {
+	return fmt.Sprintf("%s:%d", name, version)
+}
+
+// ParseLaunchTemplateRef splits ref into template name and version. ok is
+// false for launch configuration names.
+func ParseLaunchTemplateRef(ref string) (name string, version string, ok bool) {
+	idx := strings.LastIndex(ref, ":")
+	if idx <= 0 || idx == len(ref)-1 {
+		return "", "", false
+	}
+	version = ref[idx+1:]
+	if _, err := strconv.ParseInt(version, 10, 64); err != nil && !strings.HasPrefix(version, "$") {
+		return "", "", false
+	}
+	return ref[:idx], version, true
+}
+
+// IsLaunchTemplateRef is true when ref names a launch template version
+// rather than a launch configuration.
+func IsLaunchTemplateRef(ref string) bool {
+	_, _, ok := ParseLaunchTemplateRef(ref)
+	return ok
+}
+
+// GroupLaunchRef returns what asg launches instances from: its launch
+// configuration, or its launch template version.
+func GroupLaunchRef(asg *autoscaling.Group) string {
+	if asg.LaunchConfigurationName != nil {
+		return *asg.LaunchConfigurationName
+	}
+	if asg.LaunchTemplate != nil {
+		return templateSpecRef(asg.LaunchTemplate)
+	}
+	return ""
+}
+
+// InstanceLaunchRef returns what an ASG instance was launched from.
+func InstanceLaunchRef(instance *autoscaling.Instance) string {
+	if instance.LaunchConfigurationName != nil {
+		return *instance.LaunchConfigurationName
+	}
+	if instance.LaunchTemplate != nil {
+		return templateSpecRef(instance.LaunchTemplate)
+	}
+	return ""
+}
+
+func templateSpecRef(spec *autoscaling.LaunchTemplateSpecification) string {
+	return fmt.Sprintf("%s:%s", aws.StringValue(spec.LaunchTemplateName), aws.StringValue(spec.Version))
+}
+
+// LaunchTemplateVersions returns the versions of the named template, or nil
+// when the template doesn't exist.
+func LaunchTemplateVersions(service ec2iface.EC2API, name string) ([]*ec2.LaunchTemplateVersion, error) {
+	versions := make([]*ec2.LaunchTemplateVersion, 0)
+	err := service.DescribeLaunchTemplateVersionsPages(&ec2.DescribeLaunchTemplateVersionsInput{
+		LaunchTemplateName: aws.String(name),
+	}, func(page *ec2.DescribeLaunchTemplateVersionsOutput, lastPage bool) bool {
+		versions = append(versions, page.LaunchTemplateVersions...)
+		return true
+	})
+	if err != nil {
+		if IsLaunchTemplateNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return versions, nil
+}
+
+// IsLaunchTemplateNotFound is true for the errors EC2 returns about a missing
+// launch template.
+func IsLaunchTemplateNotFound(err error) bool {
+	return err != nil && (strings.Contains(err.Error(), "InvalidLaunchTemplateName.NotFoundException") ||
+		strings.Contains(err.Error(), "InvalidLaunchTemplateId.NotFound"))
+}
+
+// CheckLaunchTemplateRef makes sure ref is a version of the launch template
+// of app in env.
+func CheckLaunchTemplateRef(service ec2iface.EC2API, app *SuripuApp, env *Environment, ref string) error {
+	name, version, _ := ParseLaunchTemplateRef(ref)
+	if name != env.LaunchTemplateName(app) {
+		return errors.New(fmt.Sprintf("Launch template %s does not belong to %s in %s", name, app.Name, env.Name))
+	}
+
+	resp, err := service.DescribeLaunchTemplateVersions(&ec2.DescribeLaunchTemplateVersionsInput{
+		LaunchTemplateName: aws.String(name),
+		Versions:           []*string{aws.String(version)},
+	})
+	if err != nil || len(resp.LaunchTemplateVersions) == 0 {
+		return errors.New(fmt.Sprintf("Launch template version not found: %s", ref))
+	}
+	return nil
+}
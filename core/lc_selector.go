@@ -5,46 +5,61 @@ import (
 	"fmt"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/autoscaling/autoscalingiface"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
 	"github.com/mitchellh/cli"
 	"sort"
-	"strconv"
 	"strings"
+	"time"
 )
 
 type LaunchConfigurationSelector interface {
-	Choose(app *SuripuApp) (string, error)
+	Choose(app *SuripuApp, env *Environment) (string, error)
 }
 
-func NewCliLaunchConfigurationSelector(ui cli.ColoredUi, asg *autoscaling.AutoScaling) *CliLaunchConfigurationSelector {
+func NewCliLaunchConfigurationSelector(ui cli.ColoredUi, asg autoscalingiface.AutoScalingAPI, ec2Service ec2iface.EC2API) *CliLaunchConfigurationSelector {
 	return &CliLaunchConfigurationSelector{
-		Ui:      ui,
-		service: asg,
+		Ui:         ui,
+		service:    asg,
+		ec2Service: ec2Service,
 	}
 }
 
 type CliLaunchConfigurationSelector struct {
-	Ui      cli.ColoredUi
-	service *autoscaling.AutoScaling
+	Ui         cli.ColoredUi
+	service    autoscalingiface.AutoScalingAPI
+	ec2Service ec2iface.EC2API
 }
 
-func (c *CliLaunchConfigurationSelector) Choose(app *SuripuApp) (string, error) {
+// launchChoice is a launch configuration or a launch template version the
+// app can be deployed with.
+type launchChoice struct {
+	Name        string
+	Description string
+	Created     time.Time
+}
+
+func (c *CliLaunchConfigurationSelector) Choose(app *SuripuApp, env *Environment) (string, error) {
 	lcParams := &autoscaling.DescribeLaunchConfigurationsInput{
 		MaxRecords: aws.Int64(100),
 	}
 
+	prefix := env.LaunchConfigName(app, "")
 	pageNum := 0
-	appPossibleLCs := make([]*autoscaling.LaunchConfiguration, 0)
+	choices := make([]*launchChoice, 0)
 
 	pageErr := c.service.DescribeLaunchConfigurationsPages(lcParams, func(page *autoscaling.DescribeLaunchConfigurationsOutput, lastPage bool) bool {
 		pageNum++
 		if len(page.LaunchConfigurations) == 0 {
-			c.Ui.Error(fmt.Sprintf("No launch configuration found for app: %s", app.Name))
 			return false
 		}
 
 		for _, stuff := range page.LaunchConfigurations {
-			if strings.HasPrefix(*stuff.LaunchConfigurationName, app.Name) {
-				appPossibleLCs = append(appPossibleLCs, stuff)
+			if strings.HasPrefix(*stuff.LaunchConfigurationName, prefix) {
+				choices = append(choices, &launchChoice{
+					Name:    *stuff.LaunchConfigurationName,
+					Created: aws.TimeValue(stuff.CreatedTime),
+				})
 			}
 		}
 		return pageNum <= 2 //Allow for 200 possible LCs
@@ -54,25 +69,86 @@ func (c *CliLaunchConfigurationSelector) Choose(app *SuripuApp) (string, error)
 		return "", errors.New(fmt.Sprintf("%s", pageErr))
 	}
 
-	c.Ui.Info("Latest 5 Launch Configurations")
+	versions, err := LaunchTemplateVersions(c.ec2Service, env.LaunchTemplateName(app))
+	if err != nil {
+		return "", err
+	}
+	for _, version := range versions {
+		choices = append(choices, &launchChoice{
+			Name:        LaunchTemplateRef(*version.LaunchTemplateName, *version.VersionNumber),
+			Description: aws.StringValue(version.VersionDescription),
+			Created:     aws.TimeValue(version.CreateTime),
+		})
+	}
+
+	if len(choices) == 0 {
+		return "", errors.New(fmt.Sprintf("No launch configuration or launch template found for app: %s", app.Name))
+	}
+
+	c.Ui.Info("Latest 5 Launch Configurations and launch template versions")
 	c.Ui.Info(" #\tName:                               \tCreated At:")
 	c.Ui.Info("---|---------------------------------------|---------------")
-	sort.Sort(sort.Reverse(ByLCTime(appPossibleLCs)))
+	sort.Sort(sort.Reverse(byChoiceTime(choices)))
 
-	numLCs := Min(len(appPossibleLCs), 5)
+	numLCs := Min(len(choices), 5)
 	for lcIdx := 0; lcIdx < numLCs; lcIdx++ {
-		c.Ui.Info(fmt.Sprintf("[%d]\t%-36s\t%s", lcIdx, *appPossibleLCs[lcIdx].LaunchConfigurationName, appPossibleLCs[lcIdx].CreatedTime.String()))
+		name := choices[lcIdx].Name
+		if choices[lcIdx].Description != "" {
+			name = fmt.Sprintf("%s (%s)", name, choices[lcIdx].Description)
+		}
+		c.Ui.Info(fmt.Sprintf("[%d]\t%-36s\t%s", lcIdx, name, choices[lcIdx].Created.String()))
 	}
 
 	c.Ui.Output("")
 	lc, err := c.Ui.Ask("Select a launch configuration (LC) #: ")
-	lcNum, _ := strconv.Atoi(lc)
+	if err != nil {
+		return "", err
+	}
+	lcNum, err := ParseSelection(lc, numLCs)
+	if err != nil {
+		return "", err
+	}
+
+	return choices[lcNum].Name, nil
+}
 
-	if err != nil || lcNum >= len(appPossibleLCs) {
-		return "", errors.New(fmt.Sprintf("Error reading app #: %s", err))
+// FlagLaunchConfigurationSelector checks the named launch configuration, or
+// launch template version, exists and belongs to the app instead of
+// prompting.
+type FlagLaunchConfigurationSelector struct {
+	Name       string
+	service    autoscalingiface.AutoScalingAPI
+	ec2Service ec2iface.EC2API
+}
+
+func (f *FlagLaunchConfigurationSelector) Choose(app *SuripuApp, env *Environment) (string, error) {
+	if IsLaunchTemplateRef(f.Name) {
+		if err := CheckLaunchTemplateRef(f.ec2Service, app, env, f.Name); err != nil {
+			return "", err
+		}
+		return f.Name, nil
 	}
 
-	lcName := *appPossibleLCs[lcNum].LaunchConfigurationName
+	if !strings.HasPrefix(f.Name, env.LaunchConfigName(app, "")) {
+		return "", errors.New(fmt.Sprintf("Launch configuration %s does not belong to %s in %s", f.Name, app.Name, env.Name))
+	}
 
-	return lcName, nil
+	resp, err := f.service.DescribeLaunchConfigurations(&autoscaling.DescribeLaunchConfigurationsInput{
+		LaunchConfigurationNames: []*string{aws.String(f.Name)},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if len(resp.LaunchConfigurations) == 0 {
+		return "", errors.New(fmt.Sprintf("Launch configuration not found: %s", f.Name))
+	}
+	return f.Name, nil
+}
+
+func NewLaunchConfigurationSelector(ui cli.ColoredUi, asg autoscalingiface.AutoScalingAPI, ec2Service ec2iface.EC2API, name string) LaunchConfigurationSelector {
+	if name != "" {
+		return &FlagLaunchConfigurationSelector{Name: name, service: asg, ec2Service: ec2Service}
+	}
+	return NewCliLaunchConfigurationSelector(ui, asg, ec2Service)
 }
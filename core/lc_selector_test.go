@@ -0,0 +1,37 @@
+package core_test
+
+import (
+	"fmt"
+	"github.com/hello/sanders/core"
+	"github.com/hello/sanders/fakes"
+	"github.com/mitchellh/cli"
+	"strings"
+	"testing"
+)
+
+func TestCliLaunchConfigurationSelectorOnlyOffersListedChoices(t *testing.T) {
+	cloud := fakes.NewCloud()
+	for minor := 0; minor < 7; minor++ {
+		cloud.AddLaunchConfiguration(fmt.Sprintf("suripu-app-staging-1.0.%d", minor), "ami-00000001")
+	}
+	clients := cloud.Clients()
+	app := &core.SuripuApp{Name: "suripu-app"}
+	env, err := core.DefaultEnvironments.Get("staging")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, input := range []string{"4", "5", "6"} {
+		ui := &cli.MockUi{InputReader: strings.NewReader(input + "\n")}
+		selector := core.NewCliLaunchConfigurationSelector(cli.ColoredUi{Ui: ui}, clients.AutoScaling, clients.EC2)
+
+		lcName, err := selector.Choose(app, env)
+		if input == "4" {
+			if err != nil || !strings.HasPrefix(lcName, "suripu-app-staging-1.0.") {
+				t.Errorf("choosing %s returned %q, %v", input, lcName, err)
+			}
+		} else if err == nil {
+			t.Errorf("choosing %s, which isn't listed, returned %s", input, lcName)
+		}
+	}
+}
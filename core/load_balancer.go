@@ -0,0 +1,184 @@
+package core
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/elb"
+	"github.com/aws/aws-sdk-go/service/elb/elbiface"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+	"github.com/aws/aws-sdk-go/service/elbv2/elbv2iface"
+	"strings"
+	"sync"
+)
+
+const (
+	loadBalancerClassic = "classic"
+	loadBalancerV2      = "v2"
+
+	// errLoadBalancerNotFound is returned by both elb and elbv2.
+	errLoadBalancerNotFound = "LoadBalancerNotFound"
+)
+
+// InstanceHealth is the state of an instance behind a load balancer. Target
+// group states are mapped to the classic ELB ones: healthy is InService,
+// initial is Pending and anything else OutOfService.
+type InstanceHealth struct {
+	InstanceId  string
+	State       string
+	ReasonCode  string
+	Description string
+}
+
+// LoadBalancers reads instance health from classic ELBs and from the target
+// groups of ALBs and NLBs, so callers don't need to know which kind an app
+// uses. A load balancer is referred to by name, or by target group ARN.
+type LoadBalancers struct {
+	elb   elbiface.ELBAPI
+	elbv2 elbv2iface.ELBV2API
+
+	mu    sync.Mutex
+	kinds map[string]string
+}
+
+func NewLoadBalancers(elbService elbiface.ELBAPI, elbv2Service elbv2iface.ELBV2API) *LoadBalancers {
+	return &LoadBalancers{
+		elb:   elbService,
+		elbv2: elbv2Service,
+		kinds: make(map[string]string),
+	}
+}
+
+// IsTargetGroup is true when ref is a target group ARN rather than a load
+// balancer name.
+func IsTargetGroup(ref string) bool {
+	return strings.HasPrefix(ref, "arn:") && strings.Contains(ref, ":targetgroup/")
+}
+
+// LoadBalancerLabel is the name to show for ref: the load balancer name, or
+// the name of the target group in the ARN.
+func LoadBalancerLabel(ref string) string {
+	if !IsTargetGroup(ref) {
+		return ref
+	}
+	parts := strings.Split(ref[strings.Index(ref, ":targetgroup/")+1:], "/")
+	return "target group " + parts[1]
+}
+
+// Health returns the instances behind ref. Names are looked up as classic
+// ELBs first, then as ALBs or NLBs whose target groups are all read.
+func (l *LoadBalancers) Health(ref string) ([]*InstanceHealth, error) {
+	if IsTargetGroup(ref) {
+		return l.targetGroupHealth([]*string{aws.String(ref)})
+	}
+
+	l.mu.Lock()
+	kind := l.kinds[ref]
+	l.mu.Unlock()
+
+	if kind != loadBalancerV2 {
+		health, err := l.classicHealth(ref)
+		if err == nil {
+			l.remember(ref, loadBalancerClassic)
+			return health, nil
+		}
+		if aerr, ok := err.(awserr.Error); !ok || aerr.Code() != errLoadBalancerNotFound || l.elbv2 == nil {
+			return nil, err
+		}
+	}
+
+	lbResp, err := l.elbv2.DescribeLoadBalancers(&elbv2.DescribeLoadBalancersInput{
+		Names: []*string{aws.String(ref)},
+	})
+	if err != nil {
+		return nil, err
+	}
+	l.remember(ref, loadBalancerV2)
+
+	arns := make([]*string, 0)
+	for _, lb := range lbResp.LoadBalancers {
+		tgResp, err := l.elbv2.DescribeTargetGroups(&elbv2.DescribeTargetGroupsInput{
+			LoadBalancerArn: lb.LoadBalancerArn,
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, group := range tgResp.TargetGroups {
+			arns = append(arns, group.TargetGroupArn)
+		}
+	}
+	return l.targetGroupHealth(arns)
+}
+
+func (l *LoadBalancers) remember(ref, kind string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.kinds[ref] = kind
+}
+
+func (l *LoadBalancers) classicHealth(elbName string) ([]*InstanceHealth, error) {
+	resp, err := l.elb.DescribeInstanceHealth(&elb.DescribeInstanceHealthInput{
+		LoadBalancerName: aws.String(elbName),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	health := make([]*InstanceHealth, 0)
+	for _, state := range resp.InstanceStates {
+		health = append(health, &InstanceHealth{
+			InstanceId:  *state.InstanceId,
+			State:       aws.StringValue(state.State),
+			ReasonCode:  aws.StringValue(state.ReasonCode),
+			Description: aws.StringValue(state.Description),
+		})
+	}
+	return health, nil
+}
+
+// targetGroupHealth merges the targets of every group. An instance in
+// several groups is only InService when it is healthy in all of them.
+func (l *LoadBalancers) targetGroupHealth(arns []*string) ([]*InstanceHealth, error) {
+	byInstance := make(map[string]*InstanceHealth)
+	health := make([]*InstanceHealth, 0)
+
+	for _, arn := range arns {
+		resp, err := l.elbv2.DescribeTargetHealth(&elbv2.DescribeTargetHealthInput{
+			TargetGroupArn: arn,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, description := range resp.TargetHealthDescriptions {
+			target := &InstanceHealth{
+				InstanceId:  *description.Target.Id,
+				State:       "OutOfService",
+				ReasonCode:  "N/A",
+				Description: "N/A",
+			}
+			if description.TargetHealth != nil {
+				switch aws.StringValue(description.TargetHealth.State) {
+				case elbv2.TargetHealthStateEnumHealthy:
+					target.State = "InService"
+				case elbv2.TargetHealthStateEnumInitial:
+					target.State = "Pending"
+				}
+				if reason := aws.StringValue(description.TargetHealth.Reason); reason != "" {
+					target.ReasonCode = reason
+				}
+				if text := aws.StringValue(description.TargetHealth.Description); text != "" {
+					target.Description = text
+				}
+			}
+
+			existing, found := byInstance[target.InstanceId]
+			if !found {
+				byInstance[target.InstanceId] = target
+				health = append(health, target)
+			} else if existing.State == "InService" && target.State != "InService" {
+				*existing = *target
+			}
+		}
+	}
+	return health, nil
+}
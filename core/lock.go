@@ -0,0 +1,402 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+const DefaultLockTTL = 30 * time.Minute
+
+// Lock is an advisory lock on an app in an environment, held by one
+// sanders command at a time.
+type Lock struct {
+	App        string    `json:"app"`
+	Env        string    `json:"env"`
+	Owner      string    `json:"owner"`
+	Command    string    `json:"command"`
+	AcquiredAt time.Time `json:"acquired_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+func (l *Lock) Id() string {
+	return fmt.Sprintf("%s/%s", l.App, l.Env)
+}
+
+func (l *Lock) Expired() bool {
+	return time.Now().After(l.ExpiresAt)
+}
+
+func (l *Lock) String() string {
+	return fmt.Sprintf("%s is locked by %s (%s) since %s, expires %s",
+		l.Id(), l.Owner, l.Command, l.AcquiredAt.Local().Format(time.RFC822), l.ExpiresAt.Local().Format(time.RFC822))
+}
+
+// LockHeldError is returned by Acquire when someone else holds the lock.
+type LockHeldError struct {
+	Holder *Lock
+}
+
+func (e *LockHeldError) Error() string {
+	return e.Holder.String()
+}
+
+type Locker interface {
+	// Acquire takes the lock unless it is held and not expired.
+	Acquire(lock *Lock) error
+	// Release drops the lock if lock.Owner still holds it.
+	Release(lock *Lock) error
+	// ForceRelease drops the lock whoever holds it.
+	ForceRelease(app, env string) error
+	// List returns the locks currently held, expired ones included.
+	List() ([]Lock, error)
+}
+
+// LockSettings is the lock section of the config file.
+type LockSettings struct {
+	Backend string `json:"backend"`
+	Path    string `json:"path,omitempty"`
+	Table   string `json:"table,omitempty"`
+	TTL     string `json:"ttl,omitempty"`
+}
+
+// LockTTL returns how long a lock is held before it can be taken over.
+func (s *LockSettings) LockTTL() time.Duration {
+	if s == nil || s.TTL == "" {
+		return DefaultLockTTL
+	}
+	ttl, err := time.ParseDuration(s.TTL)
+	if err != nil {
+		return DefaultLockTTL
+	}
+	return ttl
+}
+
+// NewLocker builds the locker described by settings. A nil settings means
+// lock files in ~/.sanders/locks, which only protects against concurrent
+// runs on the same machine.
+func NewLocker(settings *LockSettings, clients *Clients) (Locker, error) {
+	if settings == nil {
+		settings = &LockSettings{Backend: "file"}
+	}
+
+	switch settings.Backend {
+	case "", "file":
+		path := settings.Path
+		if path == "" {
+			path = filepath.Join(os.Getenv("HOME"), ".sanders", "locks")
+		}
+		return &FileLocker{Dir: path}, nil
+	case "dynamodb":
+		return &DynamoLocker{
+			service: clients.DynamoDB,
+			Table:   settings.Table,
+		}, nil
+	}
+	return nil, errors.New(fmt.Sprintf("Unknown lock backend: %s", settings.Backend))
+}
+
+// FileLocker keeps one JSON file per lock in Dir.
+type FileLocker struct {
+	Dir string
+}
+
+func (f *FileLocker) path(app, env string) string {
+	return filepath.Join(f.Dir, fmt.Sprintf("%s.%s.json", app, env))
+}
+
+func (f *FileLocker) read(path string) (*Lock, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	lock := &Lock{}
+	if err := json.Unmarshal(content, lock); err != nil {
+		return nil, errors.New(fmt.Sprintf("%s: %s", path, err))
+	}
+	return lock, nil
+}
+
+func (f *FileLocker) Acquire(lock *Lock) error {
+	if err := os.MkdirAll(f.Dir, 0755); err != nil {
+		return err
+	}
+
+	content, err := json.Marshal(lock)
+	if err != nil {
+		return err
+	}
+
+	// The lock is written to a temporary file and linked into place, so it
+	// never exists without its content, and Link fails when it exists.
+	tmp, err := ioutil.TempFile(f.Dir, ".lock-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(content)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	path := f.path(lock.App, lock.Env)
+	for attempt := 0; attempt < 2; attempt++ {
+		err := os.Link(tmp.Name(), path)
+		if err == nil {
+			return nil
+		}
+		if !os.IsExist(err) {
+			return err
+		}
+
+		holder, err := f.read(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if !holder.Expired() {
+			return &LockHeldError{Holder: holder}
+		}
+		if err := f.removeStale(path, holder); err != nil {
+			return err
+		}
+	}
+	return errors.New(fmt.Sprintf("Could not acquire %s", lock.Id()))
+}
+
+// removeStale deletes the expired lock at path. It is first renamed away,
+// so only one command takes it over, then checked: when another command
+// already replaced it with a new lock, that one is linked back into place.
+func (f *FileLocker) removeStale(path string, stale *Lock) error {
+	tmp, err := ioutil.TempFile(f.Dir, ".stale-")
+	if err != nil {
+		return err
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+
+	err = os.Rename(path, tmp.Name())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	taken, err := f.read(tmp.Name())
+	if err != nil {
+		return err
+	}
+	if taken.Owner != stale.Owner || !taken.AcquiredAt.Equal(stale.AcquiredAt) {
+		if err := os.Link(tmp.Name(), path); err != nil && !os.IsExist(err) {
+			return err
+		}
+		return &LockHeldError{Holder: taken}
+	}
+	return nil
+}
+
+func (f *FileLocker) Release(lock *Lock) error {
+	path := f.path(lock.App, lock.Env)
+	holder, err := f.read(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if holder.Owner != lock.Owner || !holder.AcquiredAt.Equal(lock.AcquiredAt) {
+		return &LockHeldError{Holder: holder}
+	}
+	return os.Remove(path)
+}
+
+func (f *FileLocker) ForceRelease(app, env string) error {
+	err := os.Remove(f.path(app, env))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (f *FileLocker) List() ([]Lock, error) {
+	paths, err := filepath.Glob(filepath.Join(f.Dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	locks := make([]Lock, 0)
+	for _, path := range paths {
+		lock, err := f.read(path)
+		if err != nil {
+			return nil, err
+		}
+		locks = append(locks, *lock)
+	}
+	return locks, nil
+}
+
+// DynamoLocker uses conditional writes on a table with "lock_id" as hash
+// key, so every engineer sees the same locks.
+type DynamoLocker struct {
+	service dynamodbiface.DynamoDBAPI
+	Table   string
+}
+
+type dynamoLockItem struct {
+	LockId     string `dynamodbav:"lock_id"`
+	App        string `dynamodbav:"app"`
+	Env        string `dynamodbav:"env"`
+	Owner      string `dynamodbav:"owner"`
+	Command    string `dynamodbav:"command"`
+	AcquiredAt int64  `dynamodbav:"acquired_at"`
+	ExpiresAt  int64  `dynamodbav:"expires_at"`
+}
+
+func (i *dynamoLockItem) lock() *Lock {
+	return &Lock{
+		App:        i.App,
+		Env:        i.Env,
+		Owner:      i.Owner,
+		Command:    i.Command,
+		AcquiredAt: time.Unix(0, i.AcquiredAt),
+		ExpiresAt:  time.Unix(0, i.ExpiresAt),
+	}
+}
+
+func isConditionFailed(err error) bool {
+	awsErr, ok := err.(awserr.Error)
+	return ok && awsErr.Code() == "ConditionalCheckFailedException"
+}
+
+func (d *DynamoLocker) get(id string) (*Lock, error) {
+	resp, err := d.service.GetItem(&dynamodb.GetItemInput{
+		TableName:      aws.String(d.Table),
+		ConsistentRead: aws.Bool(true),
+		Key: map[string]*dynamodb.AttributeValue{
+			"lock_id": {S: aws.String(id)},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Item) == 0 {
+		return nil, nil
+	}
+
+	item := dynamoLockItem{}
+	if err := dynamodbattribute.UnmarshalMap(resp.Item, &item); err != nil {
+		return nil, err
+	}
+	return item.lock(), nil
+}
+
+func (d *DynamoLocker) Acquire(lock *Lock) error {
+	item, err := dynamodbattribute.MarshalMap(&dynamoLockItem{
+		LockId:     lock.Id(),
+		App:        lock.App,
+		Env:        lock.Env,
+		Owner:      lock.Owner,
+		Command:    lock.Command,
+		AcquiredAt: lock.AcquiredAt.UnixNano(),
+		ExpiresAt:  lock.ExpiresAt.UnixNano(),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = d.service.PutItem(&dynamodb.PutItemInput{
+		TableName:           aws.String(d.Table),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(lock_id) OR expires_at < :now"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":now": {N: aws.String(strconv.FormatInt(time.Now().UnixNano(), 10))},
+		},
+	})
+	if isConditionFailed(err) {
+		holder, getErr := d.get(lock.Id())
+		if getErr != nil || holder == nil {
+			return errors.New(fmt.Sprintf("%s is locked", lock.Id()))
+		}
+		return &LockHeldError{Holder: holder}
+	}
+	return err
+}
+
+func (d *DynamoLocker) Release(lock *Lock) error {
+	_, err := d.service.DeleteItem(&dynamodb.DeleteItemInput{
+		TableName: aws.String(d.Table),
+		Key: map[string]*dynamodb.AttributeValue{
+			"lock_id": {S: aws.String(lock.Id())},
+		},
+		ConditionExpression: aws.String("attribute_not_exists(lock_id) OR (#owner = :owner AND acquired_at = :acquired)"),
+		ExpressionAttributeNames: map[string]*string{
+			"#owner": aws.String("owner"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":owner":    {S: aws.String(lock.Owner)},
+			":acquired": {N: aws.String(strconv.FormatInt(lock.AcquiredAt.UnixNano(), 10))},
+		},
+	})
+	if isConditionFailed(err) {
+		holder, getErr := d.get(lock.Id())
+		if getErr != nil || holder == nil {
+			return errors.New(fmt.Sprintf("%s was taken over", lock.Id()))
+		}
+		return &LockHeldError{Holder: holder}
+	}
+	return err
+}
+
+func (d *DynamoLocker) ForceRelease(app, env string) error {
+	lock := &Lock{App: app, Env: env}
+	_, err := d.service.DeleteItem(&dynamodb.DeleteItemInput{
+		TableName: aws.String(d.Table),
+		Key: map[string]*dynamodb.AttributeValue{
+			"lock_id": {S: aws.String(lock.Id())},
+		},
+	})
+	return err
+}
+
+func (d *DynamoLocker) List() ([]Lock, error) {
+	locks := make([]Lock, 0)
+	var unmarshalErr error
+	err := d.service.ScanPages(&dynamodb.ScanInput{
+		TableName:      aws.String(d.Table),
+		ConsistentRead: aws.Bool(true),
+	}, func(page *dynamodb.ScanOutput, lastPage bool) bool {
+		for _, raw := range page.Items {
+			item := dynamoLockItem{}
+			if unmarshalErr = dynamodbattribute.UnmarshalMap(raw, &item); unmarshalErr != nil {
+				return false
+			}
+			locks = append(locks, *item.lock())
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	if unmarshalErr != nil {
+		return nil, unmarshalErr
+	}
+	return locks, nil
+}
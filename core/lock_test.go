@@ -0,0 +1,81 @@
+package core
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFileLockerTakesOverStaleLockOnce(t *testing.T) {
+	for run := 0; run < 20; run++ {
+		dir, err := ioutil.TempDir("", "sanders-locks")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		locker := &FileLocker{Dir: dir}
+
+		stale := &Lock{
+			App:        "suripu-app",
+			Env:        "staging",
+			Owner:      "gone",
+			Command:    "deploy",
+			AcquiredAt: time.Now().Add(-2 * time.Hour),
+			ExpiresAt:  time.Now().Add(-time.Hour),
+		}
+		content, err := json.Marshal(stale)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(locker.path(stale.App, stale.Env), content, 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		owners := []string{"alice", "bob"}
+		errs := make([]error, len(owners))
+		var wg sync.WaitGroup
+		for i, owner := range owners {
+			wg.Add(1)
+			go func(i int, owner string) {
+				defer wg.Done()
+				errs[i] = locker.Acquire(&Lock{
+					App:        stale.App,
+					Env:        stale.Env,
+					Owner:      owner,
+					Command:    "deploy",
+					AcquiredAt: time.Now(),
+					ExpiresAt:  time.Now().Add(time.Hour),
+				})
+			}(i, owner)
+		}
+		wg.Wait()
+
+		winners := make([]string, 0)
+		for i, err := range errs {
+			if err == nil {
+				winners = append(winners, owners[i])
+			}
+		}
+		if len(winners) != 1 {
+			t.Fatalf("%v took over the stale lock, want exactly one of %v (errors: %v)", winners, owners, errs)
+		}
+
+		holder, err := locker.read(locker.path(stale.App, stale.Env))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if holder.Owner != winners[0] {
+			t.Errorf("lock is held by %s, but %s took it over", holder.Owner, winners[0])
+		}
+		if leftovers, _ := filepath.Glob(filepath.Join(dir, ".stale-*")); len(leftovers) != 0 {
+			t.Errorf("stale locks left behind: %v", leftovers)
+		}
+		if locks, err := locker.List(); err != nil || len(locks) != 1 {
+			t.Errorf("List returned %v, %v; want the lock of %s", locks, err, winners[0])
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package core
+
+// NotifierSettings is one entry of the notifiers section of the config file.
+// Which fields apply depends on Type, see the README.
+type NotifierSettings struct {
+	Type string `json:"type"`
+	// Name identifies the notifier in errors, defaults to Type.
+	Name string `json:"name,omitempty"`
+	// Envs limits the notifier to actions in these environments. Empty
+	// means every environment.
+	Envs []string `json:"envs,omitempty"`
+
+	// slack and webhook
+	URL    string            `json:"url,omitempty"`
+	URLEnv string            `json:"url_env,omitempty"`
+	Method string            `json:"method,omitempty"`
+	Header map[string]string `json:"headers,omitempty"`
+	Body   string            `json:"body,omitempty"`
+
+	// slack, to thread follow-ups under the first message of an action
+	// through the Web API instead of the webhook
+	Channel  string `json:"channel,omitempty"`
+	TokenEnv string `json:"token_env,omitempty"`
+
+	// email
+	SMTPHost    string   `json:"smtp_host,omitempty"`
+	SMTPPort    int      `json:"smtp_port,omitempty"`
+	Username    string   `json:"username,omitempty"`
+	PasswordEnv string   `json:"password_env,omitempty"`
+	From        string   `json:"from,omitempty"`
+	To          []string `json:"to,omitempty"`
+
+	// stdout and file
+	Path   string `json:"path,omitempty"`
+	Format string `json:"format,omitempty"`
+}
+
+// Routes is true when actions in env go to this notifier. Actions that are
+// not tied to an environment go to every notifier.
+func (s *NotifierSettings) Routes(env string) bool {
+	if len(s.Envs) == 0 || env == "" {
+		return true
+	}
+	for _, name := range s.Envs {
+		if name == env {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,121 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/mitchellh/cli"
+	"sort"
+)
+
+const (
+	ActionCreate    = "create"
+	ActionUpdate    = "update"
+	ActionDelete    = "delete"
+	ActionTerminate = "terminate"
+)
+
+// Change is one AWS resource a command is about to create, update or
+// delete. Before is empty for creations and After for deletions.
+type Change struct {
+	Resource string            `json:"resource"`
+	Name     string            `json:"name"`
+	Action   string            `json:"action"`
+	Before   map[string]string `json:"before,omitempty"`
+	After    map[string]string `json:"after,omitempty"`
+}
+
+// Plan is everything a command will change, computed from the current AWS
+// state before anything is touched.
+type Plan struct {
+	Command string   `json:"command"`
+	App     string   `json:"app,omitempty"`
+	Env     string   `json:"env,omitempty"`
+	Changes []Change `json:"changes"`
+}
+
+func NewPlan(command, app, env string) *Plan {
+	return &Plan{
+		Command: command,
+		App:     app,
+		Env:     env,
+		Changes: make([]Change, 0),
+	}
+}
+
+func (p *Plan) Add(change Change) {
+	p.Changes = append(p.Changes, change)
+}
+
+func (p *Plan) Empty() bool {
+	return len(p.Changes) == 0
+}
+
+func (p *Plan) JSON() ([]byte, error) {
+	return json.MarshalIndent(p, "", "  ")
+}
+
+func sortedKeys(attrs ...map[string]string) []string {
+	seen := make(map[string]bool)
+	keys := make([]string, 0)
+	for _, m := range attrs {
+		for key := range m {
+			if !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Render prints the plan as a diff: creations in green, deletions in red
+// and updates in yellow with only the attributes that change.
+func (p *Plan) Render(ui cli.ColoredUi) {
+	title := fmt.Sprintf("Plan (%s", p.Command)
+	if p.App != "" {
+		title += " " + p.App
+	}
+	if p.Env != "" {
+		title += " in " + p.Env
+	}
+	ui.Output("")
+	ui.Output(title + "):")
+
+	if p.Empty() {
+		ui.Output("    no changes")
+	}
+
+	for _, change := range p.Changes {
+		header := fmt.Sprintf("%s %s", change.Resource, change.Name)
+		switch change.Action {
+		case ActionCreate:
+			ui.Info("+++ " + header)
+			for _, key := range sortedKeys(change.After) {
+				ui.Info(fmt.Sprintf("        %s: %s", key, change.After[key]))
+			}
+		case ActionDelete, ActionTerminate:
+			ui.Error(fmt.Sprintf("--- %s (%s)", header, change.Action))
+			for _, key := range sortedKeys(change.Before) {
+				ui.Error(fmt.Sprintf("        %s: %s", key, change.Before[key]))
+			}
+		default:
+			ui.Warn("~~~ " + header)
+			for _, key := range sortedKeys(change.Before, change.After) {
+				before, after := change.Before[key], change.After[key]
+				if before == after {
+					continue
+				}
+				ui.Warn(fmt.Sprintf("        %s: %s => %s", key, valueOrNone(before), valueOrNone(after)))
+			}
+		}
+	}
+	ui.Output("")
+}
+
+func valueOrNone(value string) string {
+	if value == "" {
+		return "(none)"
+	}
+	return value
+}
@@ -32,6 +32,20 @@ func (s ByObjectLastModified) Less(i, j int) bool {
 	return s[i].LastModified.Unix() < s[j].LastModified.Unix()
 }
 
+type ByPackageVersion []*PackageVersion
+
+func (s ByPackageVersion) Len() int {
+	return len(s)
+}
+
+func (s ByPackageVersion) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
+
+func (s ByPackageVersion) Less(i, j int) bool {
+	return CompareVersions(s[i].Version, s[j].Version) < 0
+}
+
 func Min(x, y int) int {
 	if x < y {
 		return x
@@ -50,3 +64,28 @@ func (s ByLCTime) Swap(i, j int) {
 func (s ByLCTime) Less(i, j int) bool {
 	return s[i].CreatedTime.Unix() < s[j].CreatedTime.Unix()
 }
+
+type byChoiceTime []*launchChoice
+
+func (s byChoiceTime) Len() int {
+	return len(s)
+}
+func (s byChoiceTime) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
+func (s byChoiceTime) Less(i, j int) bool {
+	return s[i].Created.Before(s[j].Created)
+}
+
+// ByTemplateVersion sorts launch template versions oldest first.
+type ByTemplateVersion []*ec2.LaunchTemplateVersion
+
+func (s ByTemplateVersion) Len() int {
+	return len(s)
+}
+func (s ByTemplateVersion) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
+func (s ByTemplateVersion) Less(i, j int) bool {
+	return *s[i].VersionNumber < *s[j].VersionNumber
+}
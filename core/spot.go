@@ -5,101 +5,174 @@ import (
 	"fmt"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
 	"github.com/mitchellh/cli"
+	"time"
 )
 
 type FleetManager struct {
-	ui  cli.ColoredUi
-	srv *ec2.EC2
+	ui        cli.ColoredUi
+	srv       ec2iface.EC2API
+	accountId string
 }
 
-func NewFleetManager(ui cli.ColoredUi, srv *ec2.EC2) *FleetManager {
+func NewFleetManager(ui cli.ColoredUi, srv ec2iface.EC2API, accountId string) *FleetManager {
 	return &FleetManager{
-		ui:  ui,
-		srv: srv,
+		ui:        ui,
+		srv:       srv,
+		accountId: accountId,
 	}
 }
 
-func (f *FleetManager) Describe() error {
+// SpotFleet is an active or submitted spot fleet request.
+type SpotFleet struct {
+	RequestId          string         `json:"request_id"`
+	State              string         `json:"state"`
+	Status             string         `json:"status"`
+	AllocationStrategy string         `json:"allocation_strategy"`
+	FulfilledCapacity  float64        `json:"fulfilled_capacity"`
+	TargetCapacity     int64          `json:"target_capacity"`
+	Created            time.Time      `json:"created"`
+	Instances          []SpotInstance `json:"instances"`
+}
+
+type SpotInstance struct {
+	InstanceId string    `json:"instance_id"`
+	Price      string    `json:"price"`
+	Az         string    `json:"az"`
+	Created    time.Time `json:"created"`
+	KeyName    string    `json:"key_name"`
+}
+
+// List returns the spot fleet requests that can still be cancelled.
+func (f *FleetManager) List() ([]SpotFleet, error) {
 	describe := &ec2.DescribeSpotFleetRequestsInput{}
 
 	result, err := f.srv.DescribeSpotFleetRequests(describe)
-
 	if err != nil {
-		return err
+		return nil, err
 	}
+
+	fleets := make([]SpotFleet, 0)
 	for _, config := range result.SpotFleetRequestConfigs {
+		if *config.SpotFleetRequestState != "active" && *config.SpotFleetRequestState != "submitted" {
+			continue
+		}
+
 		status := "fullfilled"
 		if config.ActivityStatus != nil {
 			status = *config.ActivityStatus
 		}
 
-		if *config.SpotFleetRequestState == "active" || *config.SpotFleetRequestState == "submitted" {
-			f.ui.Info(fmt.Sprintf("%s", *config.SpotFleetRequestId))
-			f.ui.Info(fmt.Sprintf("\tstatus: %s", status))
-			f.ui.Info(fmt.Sprintf("\tsettings: %s: %0.f/%d", *config.SpotFleetRequestConfig.AllocationStrategy, *config.SpotFleetRequestConfig.FulfilledCapacity, *config.SpotFleetRequestConfig.TargetCapacity))
-			f.ui.Info(fmt.Sprintf("\tcreated: %s", *config.CreateTime))
-
-			input := &ec2.DescribeSpotFleetInstancesInput{
-				SpotFleetRequestId: config.SpotFleetRequestId,
-			}
+		fleet := SpotFleet{
+			RequestId:          *config.SpotFleetRequestId,
+			State:              *config.SpotFleetRequestState,
+			Status:             status,
+			AllocationStrategy: aws.StringValue(config.SpotFleetRequestConfig.AllocationStrategy),
+			FulfilledCapacity:  aws.Float64Value(config.SpotFleetRequestConfig.FulfilledCapacity),
+			TargetCapacity:     aws.Int64Value(config.SpotFleetRequestConfig.TargetCapacity),
+			Created:            aws.TimeValue(config.CreateTime),
+			Instances:          make([]SpotInstance, 0),
+		}
 
-			output, err := f.srv.DescribeSpotFleetInstances(input)
-			if err != nil {
-				f.ui.Error(err.Error())
-				return err
-			}
-			instanceIds := make([]string, 0)
-			spotInstanceReqIds := make([]string, 0)
-			for _, instance := range output.ActiveInstances {
-				instanceIds = append(instanceIds, *instance.InstanceId)
-				spotInstanceReqIds = append(spotInstanceReqIds, *instance.SpotInstanceRequestId)
-			}
+		input := &ec2.DescribeSpotFleetInstancesInput{
+			SpotFleetRequestId: config.SpotFleetRequestId,
+		}
 
-			if len(instanceIds) == 0 {
-				f.ui.Warn("\tNot ready yet: " + status)
-				f.ui.Output("")
-				continue
-			}
+		output, err := f.srv.DescribeSpotFleetInstances(input)
+		if err != nil {
+			return nil, err
+		}
+		instanceIds := make([]string, 0)
+		spotInstanceReqIds := make([]string, 0)
+		for _, instance := range output.ActiveInstances {
+			instanceIds = append(instanceIds, *instance.InstanceId)
+			spotInstanceReqIds = append(spotInstanceReqIds, *instance.SpotInstanceRequestId)
+		}
 
-			spotIds := &ec2.DescribeSpotInstanceRequestsInput{
-				SpotInstanceRequestIds: aws.StringSlice(spotInstanceReqIds),
-			}
+		if len(instanceIds) == 0 {
+			fleets = append(fleets, fleet)
+			continue
+		}
 
-			individualQueries, err := f.srv.DescribeSpotInstanceRequests(spotIds)
-			if err != nil {
-				return err
-			}
+		spotIds := &ec2.DescribeSpotInstanceRequestsInput{
+			SpotInstanceRequestIds: aws.StringSlice(spotInstanceReqIds),
+		}
 
-			for _, spotReq := range individualQueries.SpotInstanceRequests {
-				f.ui.Info(fmt.Sprintf("\tinstance-id: %s", *spotReq.InstanceId))
-				f.ui.Info(fmt.Sprintf("\t\t-price: %s", *spotReq.SpotPrice))
-				f.ui.Info(fmt.Sprintf("\t\t-az: %s", *spotReq.LaunchedAvailabilityZone))
-				f.ui.Info(fmt.Sprintf("\t\t-created: %s", *spotReq.CreateTime))
+		individualQueries, err := f.srv.DescribeSpotInstanceRequests(spotIds)
+		if err != nil {
+			return nil, err
+		}
 
-			}
+		describeInstancesInput := &ec2.DescribeInstancesInput{
+			InstanceIds: aws.StringSlice(instanceIds),
+		}
+		out, err := f.srv.DescribeInstances(describeInstancesInput)
+		if err != nil {
+			return nil, err
+		}
 
-			describeInstancesInput := &ec2.DescribeInstancesInput{
-				InstanceIds: aws.StringSlice(instanceIds),
-			}
-			out, err := f.srv.DescribeInstances(describeInstancesInput)
-			if err != nil {
-				f.ui.Error(err.Error())
-				return err
+		keyNames := make(map[string]string)
+		for _, res := range out.Reservations {
+			for _, instance := range res.Instances {
+				keyNames[*instance.InstanceId] = aws.StringValue(instance.KeyName)
 			}
+		}
 
-			for _, res := range out.Reservations {
-				for _, instance := range res.Instances {
-					f.ui.Info(fmt.Sprintf("\t%s, %s", *instance.InstanceId, *instance.KeyName))
-				}
-			}
-			f.ui.Output("")
+		for _, spotReq := range individualQueries.SpotInstanceRequests {
+			instanceId := aws.StringValue(spotReq.InstanceId)
+			fleet.Instances = append(fleet.Instances, SpotInstance{
+				InstanceId: instanceId,
+				Price:      aws.StringValue(spotReq.SpotPrice),
+				Az:         aws.StringValue(spotReq.LaunchedAvailabilityZone),
+				Created:    aws.TimeValue(spotReq.CreateTime),
+				KeyName:    keyNames[instanceId],
+			})
 		}
+		fleets = append(fleets, fleet)
 	}
 
+	return fleets, nil
+}
+
+// Describe prints the spot fleet requests that can still be cancelled.
+func (f *FleetManager) Describe() error {
+	fleets, err := f.List()
+	if err != nil {
+		return err
+	}
+	f.Print(fleets)
 	return nil
 }
 
+// Print shows fleets in color.
+func (f *FleetManager) Print(fleets []SpotFleet) {
+	for _, fleet := range fleets {
+		f.ui.Info(fmt.Sprintf("%s", fleet.RequestId))
+		f.ui.Info(fmt.Sprintf("\tstatus: %s", fleet.Status))
+		f.ui.Info(fmt.Sprintf("\tsettings: %s: %0.f/%d", fleet.AllocationStrategy, fleet.FulfilledCapacity, fleet.TargetCapacity))
+		f.ui.Info(fmt.Sprintf("\tcreated: %s", fleet.Created))
+
+		if len(fleet.Instances) == 0 {
+			f.ui.Warn("\tNot ready yet: " + fleet.Status)
+			f.ui.Output("")
+			continue
+		}
+
+		for _, instance := range fleet.Instances {
+			f.ui.Info(fmt.Sprintf("\tinstance-id: %s", instance.InstanceId))
+			f.ui.Info(fmt.Sprintf("\t\t-price: %s", instance.Price))
+			f.ui.Info(fmt.Sprintf("\t\t-az: %s", instance.Az))
+			f.ui.Info(fmt.Sprintf("\t\t-created: %s", instance.Created))
+		}
+
+		for _, instance := range fleet.Instances {
+			f.ui.Info(fmt.Sprintf("\t%s, %s", instance.InstanceId, instance.KeyName))
+		}
+		f.ui.Output("")
+	}
+}
+
 func (f *FleetManager) Execute(configData *ec2.SpotFleetRequestConfigData) (string, error) {
 	input := &ec2.RequestSpotFleetInput{
 		SpotFleetRequestConfig: configData,
@@ -128,7 +201,7 @@ func (f *FleetManager) Create(app *SuripuApp, ami *SelectedAmi, keyName string)
 		launchSpec := &ec2.SpotFleetLaunchSpecification{
 			ImageId: aws.String(ami.Id),
 			IamInstanceProfile: &ec2.IamInstanceProfileSpecification{
-				Arn: aws.String("arn:aws:iam::053216739513:instance-profile/" + app.InstanceProfile),
+				Arn: aws.String(fmt.Sprintf("arn:aws:iam::%s:instance-profile/%s", f.accountId, app.InstanceProfile)),
 			},
 			InstanceType: aws.String(app.InstanceType),
 			KeyName:      aws.String(keyName),
@@ -147,7 +220,7 @@ func (f *FleetManager) Create(app *SuripuApp, ami *SelectedAmi, keyName string)
 	configData := &ec2.SpotFleetRequestConfigData{
 		SpotPrice:            aws.String(app.Spot.Price),
 		AllocationStrategy:   aws.String("diversified"),
-		IamFleetRole:         aws.String("arn:aws:iam::053216739513:role/ec2-spot-fleet"),
+		IamFleetRole:         aws.String(fmt.Sprintf("arn:aws:iam::%s:role/ec2-spot-fleet", f.accountId)),
 		TargetCapacity:       aws.Int64(app.TargetDesiredCapacity),
 		LaunchSpecifications: specs,
 	}
@@ -5,22 +5,38 @@ type SelectedAmi struct {
 	Name     string
 	Version  string
 	UserData string
+	// Package is what the user data installs, nil for packer AMIs.
+	Package *PackageArtifact
+	// BaseName is the name of the base AMI the user data runs on, empty
+	// for packer AMIs.
+	BaseName string
 }
 
 type SpotSettings struct {
-	Price string
+	Price string `json:"price"`
 }
 type SuripuApp struct {
-	Name                  string
-	SecurityGroup         string
-	InstanceType          string
-	InstanceProfile       string
-	KeyName               string
-	TargetDesiredCapacity int64 //This is the desired capacity of the asg targeted for deployment
-	UsesPacker            bool
-	JavaVersion           int
-	PackagePath           string
-	Spot                  *SpotSettings
+	Name                  string        `json:"name"`
+	SecurityGroup         string        `json:"security_group"`
+	InstanceType          string        `json:"instance_type"`
+	InstanceProfile       string        `json:"instance_profile"`
+	KeyName               string        `json:"key_name,omitempty"`
+	TargetDesiredCapacity int64         `json:"target_desired_capacity"` //This is the desired capacity of the asg targeted for deployment
+	UsesPacker            bool          `json:"uses_packer"`
+	JavaVersion           int           `json:"java_version"`
+	PackagePath           string        `json:"package_path"`
+	Spot                  *SpotSettings `json:"spot,omitempty"`
+	// LaunchTemplate makes create add a launch template version instead of
+	// creating a launch configuration.
+	LaunchTemplate bool `json:"launch_template,omitempty"`
+	// UserData are variables for the user data template of the app.
+	UserData map[string]string `json:"user_data,omitempty"`
+	// UserDataTemplate names the user data template of the app, default
+	// when empty.
+	UserDataTemplate string `json:"user_data_template,omitempty"`
+	// BaseAmi is the AMI the instances of the app start from, for apps
+	// not using packer.
+	BaseAmi *BaseAmiSettings `json:"base_ami,omitempty"`
 }
 
 type Tag struct {
@@ -2,77 +2,255 @@ package core
 
 import (
 	"bytes"
-	"crypto/sha1"
+	"crypto/sha256"
 	"encoding/base64"
 	"errors"
 	"fmt"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/s3"
-	"io"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
 	"strings"
+	"text/template"
 )
 
+// UserDataTemplate is a user data template on S3, and the SHA-256 of the
+// content sanders accepts for it. Publishing a new version updates the pin.
+type UserDataTemplate struct {
+	Name   string `json:"name"`
+	Bucket string `json:"bucket,omitempty"`
+	Key    string `json:"key,omitempty"`
+	// Source is the local file publish uploads, relative to the config
+	// file.
+	Source string `json:"source,omitempty"`
+	Sha256 string `json:"sha256"`
+}
+
+// DefaultUserDataTemplate is the template of apps that don't name one.
+const DefaultUserDataTemplate = "default"
+
+// DefaultUserDataTemplates are available even without a config file.
+var DefaultUserDataTemplates = []UserDataTemplate{
+	{
+		Name:   DefaultUserDataTemplate,
+		Bucket: "hello-deploy",
+		Key:    "userdata/default_userdata.sh",
+		Source: "resources/default_userdata.sh",
+		Sha256: "c816005eac0877bbf3b562e0a233d6834244859af67ecadbbafb30eb8e411be1",
+	},
+}
+
+// Location is the S3 URL of the template.
+func (t *UserDataTemplate) Location() string {
+	return fmt.Sprintf("s3://%s/%s", t.Bucket, t.Key)
+}
+
+// UserDataSha256 is the hash templates are pinned with.
+func UserDataSha256(content []byte) string {
+	return fmt.Sprintf("%x", sha256.Sum256(content))
+}
+
+// FetchUserDataTemplate downloads the content of t as it is on S3, without
+// checking it against the pin. found is false when there is no such object.
+func FetchUserDataTemplate(srv s3iface.S3API, t *UserDataTemplate) (content []byte, found bool, err error) {
+	resp, err := srv.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(t.Bucket), // Required
+		Key:    aws.String(t.Key),    // Required
+	})
+	if err != nil {
+		if strings.Contains(err.Error(), "NoSuchKey") {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+	defer resp.Body.Close()
+
+	buf := new(bytes.Buffer)
+	if _, err := buf.ReadFrom(resp.Body); err != nil {
+		return nil, false, err
+	}
+	return buf.Bytes(), true, nil
+}
+
 type UserMetaDataGenerator struct {
-	expectedHash string
-	bucket       string
-	key          string
-	srv          *s3.S3
+	templates []UserDataTemplate
+	srv       s3iface.S3API
 }
 
 func (u *UserMetaDataGenerator) String() string {
-	return fmt.Sprintf("{expectedHash: %s, bucket: %s, key: %s}", u.expectedHash, u.bucket, u.key)
+	names := make([]string, 0)
+	for _, t := range u.templates {
+		names = append(names, fmt.Sprintf("%s: %s@%s", t.Name, t.Location(), t.Sha256))
+	}
+	return fmt.Sprintf("{templates: %s}", strings.Join(names, ", "))
 }
-func NewUserMetaDataGenerator(expectedHash, bucket, key string, srv *s3.S3) *UserMetaDataGenerator {
+
+func NewUserMetaDataGenerator(templates []UserDataTemplate, srv s3iface.S3API) *UserMetaDataGenerator {
 	return &UserMetaDataGenerator{
-		bucket:       bucket,
-		key:          key,
-		expectedHash: expectedHash,
-		srv:          srv,
+		templates: templates,
+		srv:       srv,
+	}
+}
+
+// FindUserDataTemplate returns the template called name.
+func FindUserDataTemplate(templates []UserDataTemplate, name string) (*UserDataTemplate, error) {
+	for idx := range templates {
+		if templates[idx].Name == name {
+			return &templates[idx], nil
+		}
 	}
+	return nil, errors.New(fmt.Sprintf("Unknown user data template %s", name))
 }
 
+// UserMetaDataInput is what user data templates are rendered with. Vars are
+// the user_data variables of the app and environment.
 type UserMetaDataInput struct {
+	Template      string
 	AmiVersion    string
 	AppName       string
+	Env           string
 	PackagePath   string
 	CanaryPath    string
 	DefaultRegion string
 	JavaVersion   int
+	Vars          map[string]string
 }
 
-func (u *UserMetaDataGenerator) Parse(input *UserMetaDataInput) (string, error) {
-	s3params := &s3.GetObjectInput{
-		Bucket: aws.String(u.bucket), // Required
-		Key:    aws.String(u.key),    // Required
+// NewUserMetaDataInput is the input to render the user data of version of
+// app in env, running in region.
+func NewUserMetaDataInput(app *SuripuApp, env *Environment, version, region string) *UserMetaDataInput {
+	template := app.UserDataTemplate
+	if template == "" {
+		template = DefaultUserDataTemplate
+	}
+	return &UserMetaDataInput{
+		Template:      template,
+		AmiVersion:    version,
+		AppName:       app.Name,
+		Env:           env.Name,
+		PackagePath:   app.PackagePath,
+		CanaryPath:    env.PackagePrefix,
+		DefaultRegion: region,
+		JavaVersion:   app.JavaVersion,
+		Vars:          env.UserDataVars(app),
+	}
+}
+
+// UserDataBuiltins are the variables sanders sets itself. The user_data
+// variables of apps and environments can't override them.
+var UserDataBuiltins = []string{"app_version", "app_name", "env", "package_path", "canary_path", "default_region", "java_version"}
+
+// legacyPlaceholderNames are the {name} placeholders of user data written
+// before templates.
+var legacyPlaceholderNames = []string{"app_version", "app_name", "package_path", "canary_path", "default_region", "java_version"}
+
+// legacyPlaceholders turns the legacy placeholders into template actions.
+var legacyPlaceholders = newLegacyPlaceholders()
+
+func newLegacyPlaceholders() *strings.Replacer {
+	pairs := make([]string, 0)
+	for _, name := range legacyPlaceholderNames {
+		pairs = append(pairs, "{"+name+"}", "{{."+name+"}}")
+	}
+	return strings.NewReplacer(pairs...)
+}
+
+// fetch downloads the named user data template and checks it is the pinned
+// one.
+func (u *UserMetaDataGenerator) fetch(name string) (*UserDataTemplate, string, error) {
+	t, err := FindUserDataTemplate(u.templates, name)
+	if err != nil {
+		return nil, "", err
+	}
+	if t.Sha256 == "" {
+		return nil, "", errors.New(fmt.Sprintf("User data template %s isn't pinned yet. Run `sanders userdata publish -name %s`.", t.Name, t.Name))
 	}
-	resp, err := u.srv.GetObject(s3params)
 
+	content, found, err := FetchUserDataTemplate(u.srv, t)
+	if err != nil {
+		return nil, "", err
+	}
+	if !found {
+		return nil, "", errors.New(fmt.Sprintf("User data template %s not found at %s. Run `sanders userdata publish -name %s`.", t.Name, t.Location(), t.Name))
+	}
+
+	if hash := UserDataSha256(content); hash != t.Sha256 {
+		return nil, "", errors.New(fmt.Sprintf("%s has SHA-256 %s but %s is pinned for template %s. Run `sanders userdata diff -name %s` to see what changed and `sanders userdata publish -name %s` to publish and pin the local version.",
+			t.Location(), hash, t.Sha256, t.Name, t.Name, t.Name))
+	}
+	return t, string(content), nil
+}
+
+// Render returns the user data for input, decoded.
+func (u *UserMetaDataGenerator) Render(input *UserMetaDataInput) (string, error) {
+	t, userData, err := u.fetch(input.Template)
 	if err != nil {
 		return "", err
 	}
+	return RenderUserData(t.Name, userData, input)
+}
 
-	// Pretty-print the response data.
-	buf := new(bytes.Buffer)
-	buf.ReadFrom(resp.Body)
-	userData := buf.String()
+// Parse returns the user data for input, base64 encoded as EC2 expects it.
+func (u *UserMetaDataGenerator) Parse(input *UserMetaDataInput) (string, error) {
+	userData, err := u.Render(input)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString([]byte(userData)), nil
+}
 
-	//Verify checksum of userData
-	hash := sha1.New()
-	io.WriteString(hash, userData)
-	userDataHash := fmt.Sprintf("%x", hash.Sum(nil))
+// RenderUserData renders a user data template with text/template. Every
+// variable is a field of the data, e.g. {{.app_version}}, and using one that
+// isn't set is an error. Variables that may be missing are read with
+// optional, e.g. {{if optional "newrelic_key"}}...{{end}}.
+func RenderUserData(name, text string, input *UserMetaDataInput) (string, error) {
+	data := make(map[string]string)
+	for key, value := range input.Vars {
+		data[key] = value
+	}
+	data["app_version"] = input.AmiVersion
+	data["app_name"] = input.AppName
+	data["env"] = input.Env
+	data["package_path"] = input.PackagePath
+	data["canary_path"] = input.CanaryPath
+	data["default_region"] = input.DefaultRegion
+	data["java_version"] = fmt.Sprintf("%d", input.JavaVersion)
 
-	if userDataHash != u.expectedHash {
-		return "", errors.New(fmt.Sprintf("hashes don't match %s = %s", userDataHash, u.expectedHash))
+	tmpl, err := parseUserData(name, text, data)
+	if err != nil {
+		return "", err
 	}
 
-	//do token replacement
-	userData = strings.Replace(userData, "{app_version}", input.AmiVersion, -1)
-	userData = strings.Replace(userData, "{app_name}", input.AppName, -1)
-	userData = strings.Replace(userData, "{package_path}", input.PackagePath, -1)
-	userData = strings.Replace(userData, "{canary_path}", input.CanaryPath, -1)
-	userData = strings.Replace(userData, "{default_region}", "us-east-1", -1)
-	userData = strings.Replace(userData, "{java_version}", fmt.Sprintf("%d", input.JavaVersion), -1)
+	out := new(bytes.Buffer)
+	if err := tmpl.Execute(out, data); err != nil {
+		return "", errors.New(fmt.Sprintf("Failed to render user data for %s: %s", input.AppName, err))
+	}
 
-	userData = base64.StdEncoding.EncodeToString([]byte(userData))
-	return userData, nil
+	leftovers := make([]string, 0)
+	for _, placeholder := range legacyPlaceholderNames {
+		if strings.Contains(out.String(), "{"+placeholder+"}") {
+			leftovers = append(leftovers, "{"+placeholder+"}")
+		}
+	}
+	if len(leftovers) > 0 {
+		return "", errors.New(fmt.Sprintf("User data for %s still has placeholders after rendering %s: %s", input.AppName, name, strings.Join(leftovers, ", ")))
+	}
+	return out.String(), nil
+}
+
+// CheckUserDataTemplate makes sure text parses as a user data template.
+func CheckUserDataTemplate(name, text string) error {
+	_, err := parseUserData(name, text, map[string]string{})
+	return err
+}
+
+func parseUserData(name, text string, data map[string]string) (*template.Template, error) {
+	tmpl, err := template.New(name).Option("missingkey=error").Funcs(template.FuncMap{
+		"optional": func(key string) string {
+			return data[key]
+		},
+	}).Parse(legacyPlaceholders.Replace(text))
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Invalid user data template: %s", err))
+	}
+	return tmpl, nil
 }
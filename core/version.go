@@ -0,0 +1,95 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// CompareVersions orders package versions semantically, so 8.10.0 comes
+// after 8.9.3: dot separated parts are compared as numbers when both are,
+// and a pre-release (8.10.0-rc1) comes before its release. It returns -1, 0
+// or 1.
+func CompareVersions(a, b string) int {
+	aMain, aPre := splitPreRelease(a)
+	bMain, bPre := splitPreRelease(b)
+
+	if c := compareDotted(aMain, bMain); c != 0 {
+		return c
+	}
+	switch {
+	case aPre == bPre:
+		return 0
+	case aPre == "":
+		return 1
+	case bPre == "":
+		return -1
+	}
+	return compareDotted(aPre, bPre)
+}
+
+func splitPreRelease(version string) (string, string) {
+	if idx := strings.Index(version, "-"); idx >= 0 {
+		return version[:idx], version[idx+1:]
+	}
+	return version, ""
+}
+
+func compareDotted(a, b string) int {
+	aParts := strings.Split(a, ".")
+	bParts := strings.Split(b, ".")
+	for idx := 0; idx < len(aParts) && idx < len(bParts); idx++ {
+		aNum, aErr := strconv.ParseInt(aParts[idx], 10, 64)
+		bNum, bErr := strconv.ParseInt(bParts[idx], 10, 64)
+		switch {
+		case aErr == nil && bErr == nil && aNum != bNum:
+			if aNum < bNum {
+				return -1
+			}
+			return 1
+		case aErr == nil && bErr != nil:
+			return -1
+		case aErr != nil && bErr == nil:
+			return 1
+		case aErr != nil && bErr != nil && aParts[idx] != bParts[idx]:
+			if aParts[idx] < bParts[idx] {
+				return -1
+			}
+			return 1
+		}
+	}
+	switch {
+	case len(aParts) < len(bParts):
+		return -1
+	case len(aParts) > len(bParts):
+		return 1
+	}
+	return 0
+}
+
+// IsVersionPattern is true when version is a pattern like 8.8.* rather than
+// a version.
+func IsVersionPattern(version string) bool {
+	return strings.ContainsAny(version, "*?[")
+}
+
+// MatchVersion is true when version matches pattern, using shell globs.
+func MatchVersion(pattern, version string) bool {
+	matched, err := path.Match(pattern, version)
+	return err == nil && matched
+}
+
+// ParseSelection reads the index typed at a prompt listing count choices,
+// numbered from 0.
+func ParseSelection(input string, count int) (int, error) {
+	if count == 0 {
+		return 0, errors.New("Nothing to select")
+	}
+	idx, err := strconv.Atoi(strings.TrimSpace(input))
+	if err != nil || idx < 0 || idx >= count {
+		return 0, errors.New(fmt.Sprintf("Invalid selection %q, expected a number between 0 and %d", input, count-1))
+	}
+	return idx, nil
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/hello/sanders/command"
+	"github.com/hello/sanders/core"
+	"github.com/mitchellh/cli"
+	"os"
+)
+
+// deps builds what the commands share on first use and keeps it, so
+// commands that don't talk to AWS (version, help, apps) work offline and
+// the IAM identity is resolved at most once.
+type deps struct {
+	ui         cli.ColoredUi
+	globals    map[string]string
+	configPath string
+
+	config       *core.Config
+	configSource string
+	configErr    error
+
+	awsContext *core.AwsContext
+	clients    *core.Clients
+	awsErr     error
+
+	identityResolved bool
+	user             string
+	accountId        string
+	identityErr      error
+
+	historyStore core.HistoryStore
+	locker       core.Locker
+}
+
+func newDeps(ui cli.ColoredUi, globals map[string]string, configPath string) *deps {
+	return &deps{
+		ui:         ui,
+		globals:    globals,
+		configPath: configPath,
+	}
+}
+
+// Config returns the config file, or the built-in apps and environments
+// when there is none.
+func (d *deps) Config() (*core.Config, string, error) {
+	if d.config == nil && d.configErr == nil {
+		d.config, d.configSource, d.configErr = loadConfig(d.configPath)
+		if d.configErr != nil {
+			d.configErr = errors.New(fmt.Sprintf("%s\nRun `sanders apps validate` for details.", d.configErr))
+		}
+	}
+	return d.config, d.configSource, d.configErr
+}
+
+func (d *deps) Clients() (*core.Clients, error) {
+	if d.clients == nil && d.awsErr == nil {
+		d.awsContext, d.awsErr = core.NewAwsContext(d.globals["region"], d.globals["key-region"], d.globals["profile"], d.globals["role-arn"])
+		if d.awsErr != nil {
+			d.awsErr = errors.New(fmt.Sprintf("Error creating AWS session: %s", d.awsErr))
+			return nil, d.awsErr
+		}
+		d.clients = core.NewClients(d.awsContext)
+	}
+	return d.clients, d.awsErr
+}
+
+func (d *deps) resolveIdentity() error {
+	if d.identityResolved {
+		return d.identityErr
+	}
+	d.identityResolved = true
+
+	if _, err := d.Clients(); err != nil {
+		d.identityErr = err
+		return err
+	}
+
+	d.user, d.identityErr = d.awsContext.UserName()
+	if d.identityErr == nil {
+		d.accountId, d.identityErr = d.awsContext.AccountId()
+	}
+	if d.identityErr != nil {
+		d.identityErr = errors.New(fmt.Sprintf("Could not resolve your AWS identity, check your credentials (-profile, -role-arn): %s", d.identityErr))
+	}
+	return d.identityErr
+}
+
+// User is the IAM user (or role session) running sanders.
+func (d *deps) User() (string, error) {
+	err := d.resolveIdentity()
+	return d.user, err
+}
+
+func (d *deps) AccountId() (string, error) {
+	err := d.resolveIdentity()
+	return d.accountId, err
+}
+
+func (d *deps) HistoryStore() (core.HistoryStore, error) {
+	if d.historyStore != nil {
+		return d.historyStore, nil
+	}
+
+	config, _, err := d.Config()
+	if err != nil {
+		return nil, err
+	}
+
+	clients, awsErr := d.Clients()
+	if config.History != nil && needsAws(config.History.Backend) && awsErr != nil {
+		return nil, awsErr
+	}
+	d.historyStore, err = core.NewHistoryStore(config.History, clients)
+	return d.historyStore, err
+}
+
+func (d *deps) Locker() (core.Locker, error) {
+	if d.locker != nil {
+		return d.locker, nil
+	}
+
+	config, _, err := d.Config()
+	if err != nil {
+		return nil, err
+	}
+
+	clients, awsErr := d.Clients()
+	if config.Lock != nil && needsAws(config.Lock.Backend) && awsErr != nil {
+		return nil, awsErr
+	}
+	d.locker, err = core.NewLocker(config.Lock, clients)
+	return d.locker, err
+}
+
+// needsAws is true for the history and lock backends stored in AWS, as
+// opposed to local files.
+func needsAws(backend string) bool {
+	return backend != "" && backend != "file"
+}
+
+func (d *deps) Locking() (*command.Locking, error) {
+	locker, err := d.Locker()
+	if err != nil {
+		return nil, err
+	}
+	config, _, _ := d.Config()
+
+	user, err := d.User()
+	if err != nil {
+		return nil, err
+	}
+
+	hostname, _ := os.Hostname()
+	return &command.Locking{
+		Locker: locker,
+		Owner:  fmt.Sprintf("%s@%s", user, hostname),
+		TTL:    config.Lock.LockTTL(),
+	}, nil
+}
+
+// Notifier records actions in the history, then sends them to the
+// notifiers of the config file. Without any, Slack is notified when its
+// webhook is in the environment.
+func (d *deps) Notifier() (command.BasicNotifier, error) {
+	config, _, err := d.Config()
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := d.User()
+	if err != nil {
+		return nil, err
+	}
+
+	store, err := d.HistoryStore()
+	if err != nil {
+		return nil, err
+	}
+
+	settings := config.Notifiers
+	if len(settings) == 0 && os.Getenv(command.SlackWebhookEnv) != "" {
+		settings = []core.NotifierSettings{{Type: "slack", Name: "slack"}}
+	}
+
+	notifiers, err := command.NewNotifiers(d.ui, settings, user)
+	if err != nil {
+		return nil, err
+	}
+	history := command.NewHistoryNotifier(d.ui, store, config.History, user)
+	return append(command.MultiNotifier{history}, notifiers...), nil
+}
+
+// UserDataGenerator renders the user data template from S3.
+func (d *deps) UserDataGenerator() (*core.UserMetaDataGenerator, error) {
+	config, _, err := d.Config()
+	if err != nil {
+		return nil, err
+	}
+	clients, err := d.Clients()
+	if err != nil {
+		return nil, err
+	}
+
+	return core.NewUserMetaDataGenerator(
+		config.UserDataTemplates,
+		clients.S3,
+	), nil
+}
+
+// Region is the region sanders works in.
+func (d *deps) Region() (string, error) {
+	if _, err := d.Clients(); err != nil {
+		return "", err
+	}
+	return d.awsContext.Region, nil
+}
+
+func (d *deps) AmiSelector() (core.AmiSelector, error) {
+	clients, err := d.Clients()
+	if err != nil {
+		return nil, err
+	}
+
+	userDataGenerator, err := d.UserDataGenerator()
+	if err != nil {
+		return nil, err
+	}
+
+	return core.NewSuripuAppAmiSelector(
+		d.ui,
+		clients.EC2,
+		clients.S3,
+		userDataGenerator,
+		d.awsContext.Region,
+	), nil
+}
+
+func (d *deps) KeyService() (core.KeyService, error) {
+	clients, err := d.Clients()
+	if err != nil {
+		return nil, err
+	}
+
+	return core.NewS3KeyService(
+		clients.KeyS3,
+		clients.EC2,
+		"hello-keys",
+	), nil
+}
+
+func (d *deps) FleetManager() (*core.FleetManager, error) {
+	clients, err := d.Clients()
+	if err != nil {
+		return nil, err
+	}
+
+	accountId, err := d.AccountId()
+	if err != nil {
+		return nil, err
+	}
+	return core.NewFleetManager(d.ui, clients.EC2, accountId), nil
+}
+
+// ForQueries is what read-only commands need.
+func (d *deps) ForQueries() (*core.Config, *core.Clients, error) {
+	config, _, err := d.Config()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	clients, err := d.Clients()
+	if err != nil {
+		return nil, nil, err
+	}
+	return config, clients, nil
+}
+
+// ForMutations is what commands changing AWS need: they are recorded with
+// the caller's identity and take the app's lock.
+func (d *deps) ForMutations() (*core.Config, *core.Clients, command.BasicNotifier, *command.Locking, error) {
+	config, clients, err := d.ForQueries()
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+
+	notifier, err := d.Notifier()
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+
+	locking, err := d.Locking()
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	return config, clients, notifier, locking, nil
+}
+
+// lazyCommand defers building a command until it runs, so listing commands
+// in help never touches AWS. Help and Synopsis come from the zero value of
+// the command.
+type lazyCommand struct {
+	cli.Command
+	ui    cli.ColoredUi
+	build func() (cli.Command, error)
+}
+
+func (l *lazyCommand) Run(args []string) int {
+	cmd, err := l.build()
+	if err != nil {
+		l.ui.Error(err.Error())
+		return 1
+	}
+	return cmd.Run(args)
+}
+
+func (d *deps) lazy(placeholder cli.Command, build func() (cli.Command, error)) cli.CommandFactory {
+	return func() (cli.Command, error) {
+		return &lazyCommand{Command: placeholder, ui: d.ui, build: build}, nil
+	}
+}
@@ -0,0 +1,262 @@
+package fakes
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/autoscaling/autoscalingiface"
+	"sort"
+	"time"
+)
+
+// AutoScaling fakes the ASG and launch configuration calls.
+type AutoScaling struct {
+	autoscalingiface.AutoScalingAPI
+	cloud *Cloud
+}
+
+// copyGroup returns a snapshot of group so callers can't change the cloud
+// behind its back.
+func copyGroup(group *autoscaling.Group) *autoscaling.Group {
+	snapshot := *group
+	snapshot.Instances = make([]*autoscaling.Instance, len(group.Instances))
+	for idx, instance := range group.Instances {
+		copied := *instance
+		snapshot.Instances[idx] = &copied
+	}
+	snapshot.Tags = append([]*autoscaling.TagDescription{}, group.Tags...)
+	snapshot.DesiredCapacity = aws.Int64(*group.DesiredCapacity)
+	snapshot.MinSize = aws.Int64(*group.MinSize)
+	snapshot.MaxSize = aws.Int64(*group.MaxSize)
+	return &snapshot
+}
+
+func (a *AutoScaling) DescribeAutoScalingGroups(input *autoscaling.DescribeAutoScalingGroupsInput) (*autoscaling.DescribeAutoScalingGroupsOutput, error) {
+	a.cloud.mu.Lock()
+	defer a.cloud.mu.Unlock()
+
+	names := aws.StringValueSlice(input.AutoScalingGroupNames)
+	if len(names) == 0 {
+		for name := range a.cloud.Groups {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+
+	groups := make([]*autoscaling.Group, 0)
+	for _, name := range names {
+		if group, ok := a.cloud.Groups[name]; ok {
+			groups = append(groups, copyGroup(group))
+		}
+	}
+	return &autoscaling.DescribeAutoScalingGroupsOutput{AutoScalingGroups: groups}, nil
+}
+
+func (a *AutoScaling) DescribeAutoScalingGroupsPages(input *autoscaling.DescribeAutoScalingGroupsInput, fn func(*autoscaling.DescribeAutoScalingGroupsOutput, bool) bool) error {
+	resp, err := a.DescribeAutoScalingGroups(input)
+	if err != nil {
+		return err
+	}
+	fn(resp, true)
+	return nil
+}
+
+func (a *AutoScaling) UpdateAutoScalingGroup(input *autoscaling.UpdateAutoScalingGroupInput) (*autoscaling.UpdateAutoScalingGroupOutput, error) {
+	a.cloud.mu.Lock()
+	defer a.cloud.mu.Unlock()
+
+	group, ok := a.cloud.Groups[*input.AutoScalingGroupName]
+	if !ok {
+		return nil, awsError("ValidationError", "AutoScalingGroup name not found - %s", *input.AutoScalingGroupName)
+	}
+
+	if input.LaunchConfigurationName != nil {
+		if _, ok := a.cloud.LaunchConfigurations[*input.LaunchConfigurationName]; !ok {
+			return nil, awsError("ValidationError", "Launch configuration name not found - %s", *input.LaunchConfigurationName)
+		}
+		group.LaunchConfigurationName = aws.String(*input.LaunchConfigurationName)
+		group.LaunchTemplate = nil
+	}
+	if input.LaunchTemplate != nil {
+		if a.cloud.templateVersion(input.LaunchTemplate) == nil {
+			return nil, awsError("ValidationError", "You must use a valid fully-formed launch template. The specified launch template version does not exist.")
+		}
+		spec := *input.LaunchTemplate
+		group.LaunchTemplate = &spec
+		group.LaunchConfigurationName = nil
+	}
+	if input.DesiredCapacity != nil {
+		group.DesiredCapacity = aws.Int64(*input.DesiredCapacity)
+	}
+	if input.MinSize != nil {
+		group.MinSize = aws.Int64(*input.MinSize)
+	}
+	if input.MaxSize != nil {
+		group.MaxSize = aws.Int64(*input.MaxSize)
+	}
+
+	a.cloud.reconcile(group)
+	return &autoscaling.UpdateAutoScalingGroupOutput{}, nil
+}
+
+func (a *AutoScaling) CreateOrUpdateTags(input *autoscaling.CreateOrUpdateTagsInput) (*autoscaling.CreateOrUpdateTagsOutput, error) {
+	a.cloud.mu.Lock()
+	defer a.cloud.mu.Unlock()
+
+	for _, tag := range input.Tags {
+		group, ok := a.cloud.Groups[aws.StringValue(tag.ResourceId)]
+		if !ok {
+			return nil, awsError("ValidationError", "AutoScalingGroup name not found - %s", aws.StringValue(tag.ResourceId))
+		}
+
+		updated := false
+		for _, existing := range group.Tags {
+			if aws.StringValue(existing.Key) == aws.StringValue(tag.Key) {
+				existing.Value = tag.Value
+				existing.PropagateAtLaunch = tag.PropagateAtLaunch
+				updated = true
+			}
+		}
+		if !updated {
+			group.Tags = append(group.Tags, &autoscaling.TagDescription{
+				ResourceId:        tag.ResourceId,
+				ResourceType:      tag.ResourceType,
+				Key:               tag.Key,
+				Value:             tag.Value,
+				PropagateAtLaunch: tag.PropagateAtLaunch,
+			})
+		}
+	}
+	return &autoscaling.CreateOrUpdateTagsOutput{}, nil
+}
+
+func (a *AutoScaling) DescribeScalingActivities(input *autoscaling.DescribeScalingActivitiesInput) (*autoscaling.DescribeScalingActivitiesOutput, error) {
+	return &autoscaling.DescribeScalingActivitiesOutput{Activities: []*autoscaling.Activity{}}, nil
+}
+
+func (a *AutoScaling) DescribeAutoScalingInstances(input *autoscaling.DescribeAutoScalingInstancesInput) (*autoscaling.DescribeAutoScalingInstancesOutput, error) {
+	a.cloud.mu.Lock()
+	defer a.cloud.mu.Unlock()
+
+	wanted := make(map[string]bool)
+	for _, instanceId := range input.InstanceIds {
+		wanted[*instanceId] = true
+	}
+
+	details := make([]*autoscaling.InstanceDetails, 0)
+	for _, group := range a.cloud.Groups {
+		for _, instance := range group.Instances {
+			if len(wanted) > 0 && !wanted[*instance.InstanceId] {
+				continue
+			}
+			details = append(details, &autoscaling.InstanceDetails{
+				AutoScalingGroupName:    group.AutoScalingGroupName,
+				InstanceId:              instance.InstanceId,
+				LaunchConfigurationName: instance.LaunchConfigurationName,
+				LaunchTemplate:          instance.LaunchTemplate,
+				LifecycleState:          instance.LifecycleState,
+				HealthStatus:            instance.HealthStatus,
+			})
+		}
+	}
+	return &autoscaling.DescribeAutoScalingInstancesOutput{AutoScalingInstances: details}, nil
+}
+
+func (a *AutoScaling) TerminateInstanceInAutoScalingGroup(input *autoscaling.TerminateInstanceInAutoScalingGroupInput) (*autoscaling.TerminateInstanceInAutoScalingGroupOutput, error) {
+	a.cloud.mu.Lock()
+	defer a.cloud.mu.Unlock()
+
+	for _, group := range a.cloud.Groups {
+		for idx, instance := range group.Instances {
+			if *instance.InstanceId != *input.InstanceId {
+				continue
+			}
+			a.cloud.terminate(group, idx)
+			if aws.BoolValue(input.ShouldDecrementDesiredCapacity) {
+				group.DesiredCapacity = aws.Int64(*group.DesiredCapacity - 1)
+			}
+			a.cloud.reconcile(group)
+			return &autoscaling.TerminateInstanceInAutoScalingGroupOutput{}, nil
+		}
+	}
+	return nil, awsError("ValidationError", "Instance Id not found - %s", *input.InstanceId)
+}
+
+func (a *AutoScaling) DescribeAccountLimits(input *autoscaling.DescribeAccountLimitsInput) (*autoscaling.DescribeAccountLimitsOutput, error) {
+	return &autoscaling.DescribeAccountLimitsOutput{
+		MaxNumberOfAutoScalingGroups:    aws.Int64(200),
+		MaxNumberOfLaunchConfigurations: aws.Int64(200),
+	}, nil
+}
+
+func (a *AutoScaling) DescribeLaunchConfigurations(input *autoscaling.DescribeLaunchConfigurationsInput) (*autoscaling.DescribeLaunchConfigurationsOutput, error) {
+	a.cloud.mu.Lock()
+	defer a.cloud.mu.Unlock()
+
+	names := aws.StringValueSlice(input.LaunchConfigurationNames)
+	if len(names) == 0 {
+		for name := range a.cloud.LaunchConfigurations {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+
+	lcs := make([]*autoscaling.LaunchConfiguration, 0)
+	for _, name := range names {
+		if lc, ok := a.cloud.LaunchConfigurations[name]; ok {
+			copied := *lc
+			lcs = append(lcs, &copied)
+		}
+	}
+	return &autoscaling.DescribeLaunchConfigurationsOutput{LaunchConfigurations: lcs}, nil
+}
+
+func (a *AutoScaling) DescribeLaunchConfigurationsPages(input *autoscaling.DescribeLaunchConfigurationsInput, fn func(*autoscaling.DescribeLaunchConfigurationsOutput, bool) bool) error {
+	resp, err := a.DescribeLaunchConfigurations(input)
+	if err != nil {
+		return err
+	}
+	fn(resp, true)
+	return nil
+}
+
+func (a *AutoScaling) CreateLaunchConfiguration(input *autoscaling.CreateLaunchConfigurationInput) (*autoscaling.CreateLaunchConfigurationOutput, error) {
+	a.cloud.mu.Lock()
+	defer a.cloud.mu.Unlock()
+
+	name := *input.LaunchConfigurationName
+	if _, ok := a.cloud.LaunchConfigurations[name]; ok {
+		return nil, awsError("AlreadyExists", "Launch Configuration by this name already exists - %s", name)
+	}
+
+	a.cloud.LaunchConfigurations[name] = &autoscaling.LaunchConfiguration{
+		LaunchConfigurationName:  input.LaunchConfigurationName,
+		ImageId:                  input.ImageId,
+		InstanceType:             input.InstanceType,
+		IamInstanceProfile:       input.IamInstanceProfile,
+		KeyName:                  input.KeyName,
+		SecurityGroups:           input.SecurityGroups,
+		UserData:                 input.UserData,
+		AssociatePublicIpAddress: input.AssociatePublicIpAddress,
+		InstanceMonitoring:       input.InstanceMonitoring,
+		CreatedTime:              aws.Time(time.Now()),
+	}
+	return &autoscaling.CreateLaunchConfigurationOutput{}, nil
+}
+
+func (a *AutoScaling) DeleteLaunchConfiguration(input *autoscaling.DeleteLaunchConfigurationInput) (*autoscaling.DeleteLaunchConfigurationOutput, error) {
+	a.cloud.mu.Lock()
+	defer a.cloud.mu.Unlock()
+
+	name := *input.LaunchConfigurationName
+	if _, ok := a.cloud.LaunchConfigurations[name]; !ok {
+		return nil, awsError("ValidationError", "Launch configuration name not found - %s", name)
+	}
+	for _, group := range a.cloud.Groups {
+		if aws.StringValue(group.LaunchConfigurationName) == name {
+			return nil, awsError("ResourceInUse", "Cannot delete launch configuration %s because it is attached to AutoScalingGroup %s", name, *group.AutoScalingGroupName)
+		}
+	}
+
+	delete(a.cloud.LaunchConfigurations, name)
+	return &autoscaling.DeleteLaunchConfigurationOutput{}, nil
+}
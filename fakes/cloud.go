@@ -0,0 +1,281 @@
+// Package fakes is an in-memory stand-in for the AWS services sanders uses.
+// Every fake client works on a shared Cloud, so scaling an ASG shows up in
+// the ELB health of its instances and DescribeInstances.
+//
+// Only the calls sanders makes are implemented; anything else panics through
+// the embedded nil SDK interface.
+package fakes
+
+import (
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/hello/sanders/core"
+	"sync"
+	"time"
+)
+
+const (
+	InService    = "InService"
+	OutOfService = "OutOfService"
+)
+
+// Object is an S3 object stored in the fake cloud.
+type Object struct {
+	Body         []byte
+	LastModified time.Time
+	Metadata     map[string]*string
+	// Version counts the uploads of the key, like a versioned bucket.
+	Version int
+}
+
+// Cloud is the state shared by the fake clients.
+type Cloud struct {
+	mu sync.Mutex
+
+	Groups               map[string]*autoscaling.Group
+	LaunchConfigurations map[string]*autoscaling.LaunchConfiguration
+	Instances            map[string]*ec2.Instance
+	// Health is the ELB state of each instance, InService by default.
+	Health map[string]string
+	// TargetGroups lists the target group ARNs of each ALB or NLB by name.
+	TargetGroups map[string][]string
+	// LaunchTemplates holds the versions of each launch template by name.
+	LaunchTemplates map[string][]*ec2.LaunchTemplateVersion
+	Images          []*ec2.Image
+	Objects         map[string]*Object
+	KeyPairs        map[string]bool
+
+	// LaunchState is the ELB state new instances start in. Set it to
+	// OutOfService to simulate instances that never get healthy.
+	LaunchState string
+
+	lastInstance int
+}
+
+func NewCloud() *Cloud {
+	return &Cloud{
+		Groups:               make(map[string]*autoscaling.Group),
+		LaunchConfigurations: make(map[string]*autoscaling.LaunchConfiguration),
+		Instances:            make(map[string]*ec2.Instance),
+		Health:               make(map[string]string),
+		TargetGroups:         make(map[string][]string),
+		LaunchTemplates:      make(map[string][]*ec2.LaunchTemplateVersion),
+		Images:               make([]*ec2.Image, 0),
+		Objects:              make(map[string]*Object),
+		KeyPairs:             make(map[string]bool),
+		LaunchState:          InService,
+	}
+}
+
+// Clients returns fake clients backed by c. S3 and KeyS3 share the same
+// buckets. DynamoDB is not faked, use the file history and lock backends.
+func (c *Cloud) Clients() *core.Clients {
+	return &core.Clients{
+		AutoScaling: &AutoScaling{cloud: c},
+		EC2:         &EC2{cloud: c},
+		ELB:         &ELB{cloud: c},
+		ELBv2:       &ELBv2{cloud: c},
+		S3:          &S3{cloud: c},
+		KeyS3:       &S3{cloud: c},
+	}
+}
+
+// AddLaunchConfiguration registers a launch configuration using imageId.
+func (c *Cloud) AddLaunchConfiguration(name, imageId string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.LaunchConfigurations[name] = &autoscaling.LaunchConfiguration{
+		LaunchConfigurationName: aws.String(name),
+		ImageId:                 aws.String(imageId),
+		CreatedTime:             aws.Time(time.Now()),
+	}
+}
+
+// AddLaunchTemplateVersion adds a version of the named launch template using
+// imageId, creating the template if needed, and returns its reference.
+func (c *Cloud) AddLaunchTemplateVersion(name, description, imageId string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	versions := c.LaunchTemplates[name]
+	version := &ec2.LaunchTemplateVersion{
+		LaunchTemplateName: aws.String(name),
+		VersionNumber:      aws.Int64(int64(len(versions) + 1)),
+		VersionDescription: aws.String(description),
+		DefaultVersion:     aws.Bool(len(versions) == 0),
+		CreateTime:         aws.Time(time.Now()),
+		LaunchTemplateData: &ec2.ResponseLaunchTemplateData{ImageId: aws.String(imageId)},
+	}
+	c.LaunchTemplates[name] = append(versions, version)
+	return core.LaunchTemplateRef(name, *version.VersionNumber)
+}
+
+// AddGroup creates an ASG attached to elbName running capacity instances of
+// lcName. An empty elbName leaves the ASG without load balancer. lcName
+// may also be a launch template reference.
+func (c *Cloud) AddGroup(name, lcName, elbName string, capacity int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elbNames := make([]*string, 0)
+	if elbName != "" {
+		elbNames = append(elbNames, aws.String(elbName))
+	}
+
+	group := &autoscaling.Group{
+		AutoScalingGroupName: aws.String(name),
+		LoadBalancerNames:    elbNames,
+		DesiredCapacity:      aws.Int64(capacity),
+		MinSize:              aws.Int64(capacity),
+		MaxSize:              aws.Int64(capacity * 2),
+		Instances:            make([]*autoscaling.Instance, 0),
+		Tags:                 make([]*autoscaling.TagDescription, 0),
+		CreatedTime:          aws.Time(time.Now()),
+	}
+	if templateName, version, ok := core.ParseLaunchTemplateRef(lcName); ok {
+		group.LaunchTemplate = &autoscaling.LaunchTemplateSpecification{
+			LaunchTemplateName: aws.String(templateName),
+			Version:            aws.String(version),
+		}
+	} else {
+		group.LaunchConfigurationName = aws.String(lcName)
+	}
+	c.Groups[name] = group
+	c.reconcile(group)
+}
+
+// AddTargetGroup creates an ALB named lbName if needed and a target group
+// forwarded to by it, and returns the ARN of the target group.
+func (c *Cloud) AddTargetGroup(lbName, name string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	arn := fmt.Sprintf("arn:aws:elasticloadbalancing:us-east-1:123456789012:targetgroup/%s/%016x", name, len(c.TargetGroups[lbName])+1)
+	c.TargetGroups[lbName] = append(c.TargetGroups[lbName], arn)
+	return arn
+}
+
+// AttachTargetGroup registers the instances of ASG name with a target group.
+func (c *Cloud) AttachTargetGroup(name, arn string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	group := c.Groups[name]
+	group.TargetGroupARNs = append(group.TargetGroupARNs, aws.String(arn))
+}
+
+// AddImage registers a private AMI.
+func (c *Cloud) AddImage(name, imageId string, created time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.Images = append(c.Images, &ec2.Image{
+		Name:         aws.String(name),
+		ImageId:      aws.String(imageId),
+		CreationDate: aws.String(created.UTC().Format(time.RFC3339)),
+		Public:       aws.Bool(false),
+	})
+}
+
+// PutObject stores body in bucket under key.
+func (c *Cloud) PutObject(bucket, key string, body []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.Objects[bucket+"/"+key] = &Object{Body: body, LastModified: time.Now()}
+}
+
+// SetHealth changes the ELB state of an instance.
+func (c *Cloud) SetHealth(instanceId, state string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.Health[instanceId] = state
+}
+
+// reconcile launches or terminates instances until group is at its desired
+// capacity, like the real ASG would. Instances not running the current
+// launch configuration or template version are terminated first. Callers
+// hold mu.
+func (c *Cloud) reconcile(group *autoscaling.Group) {
+	lcName := core.GroupLaunchRef(group)
+
+	for int64(len(group.Instances)) > *group.DesiredCapacity {
+		victim := len(group.Instances) - 1
+		for idx, instance := range group.Instances {
+			if core.InstanceLaunchRef(instance) != lcName {
+				victim = idx
+				break
+			}
+		}
+		c.terminate(group, victim)
+	}
+
+	for int64(len(group.Instances)) < *group.DesiredCapacity {
+		c.lastInstance++
+		instanceId := fmt.Sprintf("i-%08x", c.lastInstance)
+
+		instance := &autoscaling.Instance{
+			InstanceId:     aws.String(instanceId),
+			LifecycleState: aws.String(autoscaling.LifecycleStateInService),
+			HealthStatus:   aws.String("Healthy"),
+		}
+
+		imageId := ""
+		if group.LaunchTemplate != nil {
+			spec := *group.LaunchTemplate
+			instance.LaunchTemplate = &spec
+			if version := c.templateVersion(group.LaunchTemplate); version != nil {
+				imageId = aws.StringValue(version.LaunchTemplateData.ImageId)
+			}
+		} else {
+			instance.LaunchConfigurationName = aws.String(lcName)
+			if lc, ok := c.LaunchConfigurations[lcName]; ok {
+				imageId = aws.StringValue(lc.ImageId)
+			}
+		}
+		group.Instances = append(group.Instances, instance)
+		c.Instances[instanceId] = &ec2.Instance{
+			InstanceId:       aws.String(instanceId),
+			ImageId:          aws.String(imageId),
+			PrivateIpAddress: aws.String(fmt.Sprintf("10.0.%d.%d", c.lastInstance/256, c.lastInstance%256)),
+			PrivateDnsName:   aws.String(fmt.Sprintf("ip-10-0-%d-%d.ec2.internal", c.lastInstance/256, c.lastInstance%256)),
+			LaunchTime:       aws.Time(time.Now()),
+			State:            &ec2.InstanceState{Name: aws.String(ec2.InstanceStateNameRunning)},
+			Tags: []*ec2.Tag{
+				{Key: aws.String("aws:autoscaling:groupName"), Value: group.AutoScalingGroupName},
+			},
+		}
+		c.Health[instanceId] = c.LaunchState
+	}
+}
+
+// templateVersion returns the launch template version spec refers to, nil
+// when it doesn't exist. Callers hold mu.
+func (c *Cloud) templateVersion(spec *autoscaling.LaunchTemplateSpecification) *ec2.LaunchTemplateVersion {
+	for _, version := range c.LaunchTemplates[aws.StringValue(spec.LaunchTemplateName)] {
+		wanted := aws.StringValue(spec.Version)
+		if fmt.Sprintf("%d", *version.VersionNumber) == wanted || wanted == "$Default" && *version.DefaultVersion {
+			return version
+		}
+	}
+	return nil
+}
+
+// terminate removes the instance at idx from group. Callers hold mu.
+func (c *Cloud) terminate(group *autoscaling.Group, idx int) {
+	instanceId := *group.Instances[idx].InstanceId
+	group.Instances = append(group.Instances[:idx], group.Instances[idx+1:]...)
+	delete(c.Health, instanceId)
+	if instance, ok := c.Instances[instanceId]; ok {
+		instance.State = &ec2.InstanceState{Name: aws.String(ec2.InstanceStateNameTerminated)}
+	}
+}
+
+func awsError(code, format string, args ...interface{}) error {
+	return awserr.New(code, fmt.Sprintf(format, args...), nil)
+}
@@ -0,0 +1,104 @@
+package fakes
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/elb"
+	"github.com/aws/aws-sdk-go/service/elb/elbiface"
+	"sort"
+)
+
+// ELB fakes classic load balancers. A load balancer exists as soon as an
+// ASG names it, and its instances are the instances of those ASGs.
+type ELB struct {
+	elbiface.ELBAPI
+	cloud *Cloud
+}
+
+// registered returns the instances behind elbName, sorted. Callers hold mu.
+func (e *ELB) registered(elbName string) ([]string, bool) {
+	found := false
+	instanceIds := make([]string, 0)
+	for _, group := range e.cloud.Groups {
+		for _, name := range group.LoadBalancerNames {
+			if *name != elbName {
+				continue
+			}
+			found = true
+			for _, instance := range group.Instances {
+				instanceIds = append(instanceIds, *instance.InstanceId)
+			}
+		}
+	}
+	sort.Strings(instanceIds)
+	return instanceIds, found
+}
+
+func (e *ELB) DescribeInstanceHealth(input *elb.DescribeInstanceHealthInput) (*elb.DescribeInstanceHealthOutput, error) {
+	e.cloud.mu.Lock()
+	defer e.cloud.mu.Unlock()
+
+	elbName := aws.StringValue(input.LoadBalancerName)
+	instanceIds, found := e.registered(elbName)
+	if !found {
+		return nil, awsError("LoadBalancerNotFound", "There is no ACTIVE Load Balancer named '%s'", elbName)
+	}
+
+	wanted := make(map[string]bool)
+	for _, instance := range input.Instances {
+		wanted[aws.StringValue(instance.InstanceId)] = true
+	}
+
+	states := make([]*elb.InstanceState, 0)
+	for _, instanceId := range instanceIds {
+		if len(wanted) > 0 && !wanted[instanceId] {
+			continue
+		}
+		state := e.cloud.Health[instanceId]
+		if state == "" {
+			state = InService
+		}
+		states = append(states, &elb.InstanceState{
+			InstanceId: aws.String(instanceId),
+			State:      aws.String(state),
+		})
+	}
+	return &elb.DescribeInstanceHealthOutput{InstanceStates: states}, nil
+}
+
+func (e *ELB) DescribeLoadBalancers(input *elb.DescribeLoadBalancersInput) (*elb.DescribeLoadBalancersOutput, error) {
+	e.cloud.mu.Lock()
+	defer e.cloud.mu.Unlock()
+
+	names := aws.StringValueSlice(input.LoadBalancerNames)
+	if len(names) == 0 {
+		seen := make(map[string]bool)
+		for _, group := range e.cloud.Groups {
+			for _, name := range group.LoadBalancerNames {
+				if !seen[*name] {
+					seen[*name] = true
+					names = append(names, *name)
+				}
+			}
+		}
+		sort.Strings(names)
+	}
+
+	descriptions := make([]*elb.LoadBalancerDescription, 0)
+	for _, name := range names {
+		instanceIds, found := e.registered(name)
+		if !found {
+			return nil, awsError("LoadBalancerNotFound", "Cannot find Load Balancer %s", name)
+		}
+
+		instances := make([]*elb.Instance, 0)
+		for _, instanceId := range instanceIds {
+			instances = append(instances, &elb.Instance{InstanceId: aws.String(instanceId)})
+		}
+		descriptions = append(descriptions, &elb.LoadBalancerDescription{
+			LoadBalancerName: aws.String(name),
+			DNSName:          aws.String(name + ".elb.amazonaws.com"),
+			Instances:        instances,
+		})
+	}
+	return &elb.DescribeLoadBalancersOutput{LoadBalancerDescriptions: descriptions}, nil
+}
@@ -0,0 +1,105 @@
+package fakes
+
+import (
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+	"github.com/aws/aws-sdk-go/service/elbv2/elbv2iface"
+	"sort"
+)
+
+// ELBv2 fakes application and network load balancers. Their target groups
+// are added with Cloud.AddTargetGroup and hold the instances of the ASGs
+// attached to them.
+type ELBv2 struct {
+	elbv2iface.ELBV2API
+	cloud *Cloud
+}
+
+func loadBalancerArn(name string) string {
+	return fmt.Sprintf("arn:aws:elasticloadbalancing:us-east-1:123456789012:loadbalancer/app/%s/0000000000000001", name)
+}
+
+func (e *ELBv2) DescribeLoadBalancers(input *elbv2.DescribeLoadBalancersInput) (*elbv2.DescribeLoadBalancersOutput, error) {
+	e.cloud.mu.Lock()
+	defer e.cloud.mu.Unlock()
+
+	names := aws.StringValueSlice(input.Names)
+	if len(names) == 0 {
+		for name := range e.cloud.TargetGroups {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+
+	lbs := make([]*elbv2.LoadBalancer, 0)
+	for _, name := range names {
+		if _, found := e.cloud.TargetGroups[name]; !found {
+			return nil, awsError("LoadBalancerNotFound", "Load balancers '[%s]' not found", name)
+		}
+		lbs = append(lbs, &elbv2.LoadBalancer{
+			LoadBalancerName: aws.String(name),
+			LoadBalancerArn:  aws.String(loadBalancerArn(name)),
+			DNSName:          aws.String(name + ".elb.amazonaws.com"),
+			Type:             aws.String(elbv2.LoadBalancerTypeEnumApplication),
+		})
+	}
+	return &elbv2.DescribeLoadBalancersOutput{LoadBalancers: lbs}, nil
+}
+
+func (e *ELBv2) DescribeTargetGroups(input *elbv2.DescribeTargetGroupsInput) (*elbv2.DescribeTargetGroupsOutput, error) {
+	e.cloud.mu.Lock()
+	defer e.cloud.mu.Unlock()
+
+	groups := make([]*elbv2.TargetGroup, 0)
+	for name, arns := range e.cloud.TargetGroups {
+		if input.LoadBalancerArn != nil && loadBalancerArn(name) != *input.LoadBalancerArn {
+			continue
+		}
+		for _, arn := range arns {
+			groups = append(groups, &elbv2.TargetGroup{
+				TargetGroupArn:   aws.String(arn),
+				LoadBalancerArns: []*string{aws.String(loadBalancerArn(name))},
+			})
+		}
+	}
+	return &elbv2.DescribeTargetGroupsOutput{TargetGroups: groups}, nil
+}
+
+// DescribeTargetHealth maps the ELB state of each instance: InService is
+// healthy, OutOfService unhealthy and anything else initial.
+func (e *ELBv2) DescribeTargetHealth(input *elbv2.DescribeTargetHealthInput) (*elbv2.DescribeTargetHealthOutput, error) {
+	e.cloud.mu.Lock()
+	defer e.cloud.mu.Unlock()
+
+	arn := aws.StringValue(input.TargetGroupArn)
+	instanceIds := make([]string, 0)
+	for _, group := range e.cloud.Groups {
+		for _, groupArn := range group.TargetGroupARNs {
+			if *groupArn != arn {
+				continue
+			}
+			for _, instance := range group.Instances {
+				instanceIds = append(instanceIds, *instance.InstanceId)
+			}
+		}
+	}
+	sort.Strings(instanceIds)
+
+	descriptions := make([]*elbv2.TargetHealthDescription, 0)
+	for _, instanceId := range instanceIds {
+		health := &elbv2.TargetHealth{State: aws.String(elbv2.TargetHealthStateEnumInitial)}
+		switch e.cloud.Health[instanceId] {
+		case InService, "":
+			health.State = aws.String(elbv2.TargetHealthStateEnumHealthy)
+		case OutOfService:
+			health.State = aws.String(elbv2.TargetHealthStateEnumUnhealthy)
+			health.Reason = aws.String(elbv2.TargetHealthReasonEnumTargetFailedHealthChecks)
+		}
+		descriptions = append(descriptions, &elbv2.TargetHealthDescription{
+			Target:       &elbv2.TargetDescription{Id: aws.String(instanceId)},
+			TargetHealth: health,
+		})
+	}
+	return &elbv2.DescribeTargetHealthOutput{TargetHealthDescriptions: descriptions}, nil
+}
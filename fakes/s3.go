@@ -0,0 +1,141 @@
+package fakes
+
+import (
+	"bytes"
+	"crypto/md5"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"time"
+)
+
+// S3 fakes buckets. Objects are keyed by "<bucket>/<key>" in the cloud.
+type S3 struct {
+	s3iface.S3API
+	cloud *Cloud
+}
+
+func etag(body []byte) *string {
+	return aws.String(fmt.Sprintf("\"%x\"", md5.Sum(body)))
+}
+
+func (s *S3) GetObject(input *s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+	s.cloud.mu.Lock()
+	defer s.cloud.mu.Unlock()
+
+	object, ok := s.cloud.Objects[*input.Bucket+"/"+*input.Key]
+	if !ok {
+		return nil, awsError("NoSuchKey", "The specified key does not exist: %s", *input.Key)
+	}
+
+	return &s3.GetObjectOutput{
+		Body:          ioutil.NopCloser(bytes.NewReader(object.Body)),
+		ContentLength: aws.Int64(int64(len(object.Body))),
+		ETag:          etag(object.Body),
+		LastModified:  aws.Time(object.LastModified),
+		Metadata:      object.Metadata,
+	}, nil
+}
+
+func (s *S3) HeadObject(input *s3.HeadObjectInput) (*s3.HeadObjectOutput, error) {
+	s.cloud.mu.Lock()
+	defer s.cloud.mu.Unlock()
+
+	object, ok := s.cloud.Objects[*input.Bucket+"/"+*input.Key]
+	if !ok {
+		return nil, awsError("NotFound", "Not Found")
+	}
+
+	return &s3.HeadObjectOutput{
+		ContentLength: aws.Int64(int64(len(object.Body))),
+		ETag:          etag(object.Body),
+		LastModified:  aws.Time(object.LastModified),
+		Metadata:      object.Metadata,
+	}, nil
+}
+
+func (s *S3) PutObject(input *s3.PutObjectInput) (*s3.PutObjectOutput, error) {
+	var body []byte
+	if input.Body != nil {
+		read, err := ioutil.ReadAll(input.Body)
+		if err != nil {
+			return nil, err
+		}
+		body = read
+	}
+
+	s.cloud.mu.Lock()
+	defer s.cloud.mu.Unlock()
+
+	version := 1
+	if previous, ok := s.cloud.Objects[*input.Bucket+"/"+*input.Key]; ok {
+		version = previous.Version + 1
+	}
+	s.cloud.Objects[*input.Bucket+"/"+*input.Key] = &Object{Body: body, LastModified: time.Now(), Metadata: input.Metadata, Version: version}
+	return &s3.PutObjectOutput{ETag: etag(body), VersionId: aws.String(fmt.Sprintf("%d", version))}, nil
+}
+
+// GetBucketVersioning reports every bucket as versioned.
+func (s *S3) GetBucketVersioning(input *s3.GetBucketVersioningInput) (*s3.GetBucketVersioningOutput, error) {
+	return &s3.GetBucketVersioningOutput{Status: aws.String(s3.BucketVersioningStatusEnabled)}, nil
+}
+
+func (s *S3) DeleteObject(input *s3.DeleteObjectInput) (*s3.DeleteObjectOutput, error) {
+	s.cloud.mu.Lock()
+	defer s.cloud.mu.Unlock()
+
+	delete(s.cloud.Objects, *input.Bucket+"/"+*input.Key)
+	return &s3.DeleteObjectOutput{}, nil
+}
+
+func (s *S3) ListObjectsV2(input *s3.ListObjectsV2Input) (*s3.ListObjectsV2Output, error) {
+	s.cloud.mu.Lock()
+	defer s.cloud.mu.Unlock()
+
+	bucketPrefix := *input.Bucket + "/"
+	prefix := aws.StringValue(input.Prefix)
+
+	keys := make([]string, 0)
+	for path := range s.cloud.Objects {
+		if !strings.HasPrefix(path, bucketPrefix) {
+			continue
+		}
+		key := strings.TrimPrefix(path, bucketPrefix)
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	contents := make([]*s3.Object, 0)
+	for _, key := range keys {
+		object := s.cloud.Objects[bucketPrefix+key]
+		contents = append(contents, &s3.Object{
+			Key:          aws.String(key),
+			Size:         aws.Int64(int64(len(object.Body))),
+			ETag:         etag(object.Body),
+			LastModified: aws.Time(object.LastModified),
+		})
+	}
+
+	return &s3.ListObjectsV2Output{
+		Name:        input.Bucket,
+		Prefix:      input.Prefix,
+		Contents:    contents,
+		KeyCount:    aws.Int64(int64(len(contents))),
+		IsTruncated: aws.Bool(false),
+	}, nil
+}
+
+func (s *S3) ListObjectsV2Pages(input *s3.ListObjectsV2Input, fn func(*s3.ListObjectsV2Output, bool) bool) error {
+	resp, err := s.ListObjectsV2(input)
+	if err != nil {
+		return err
+	}
+	fn(resp, true)
+	return nil
+}
@@ -5,6 +5,7 @@ import (
 	"io/ioutil"
 	"log"
 	"os"
+	"strings"
 
 	"github.com/mitchellh/cli"
 )
@@ -19,9 +20,12 @@ func main() {
 func realMain() int {
 	// log.SetOutput(ioutil.Discard)
 
+	// Global flags select the region and account and can be passed
+	// anywhere on the command line.
+	args, globals := extractGlobalFlags(os.Args[1:])
+
 	// Get the command line args. We shortcut "--version" and "-v" to
 	// just show the version.
-	args := os.Args[1:]
 	debug := false
 	for _, arg := range args {
 		if arg == "-v" || arg == "--version" {
@@ -39,6 +43,8 @@ func realMain() int {
 		log.SetOutput(ioutil.Discard)
 	}
 
+	initCommands(globals)
+
 	cli := &cli.CLI{
 		Name:     "sanders",
 		Args:     args,
@@ -53,3 +59,50 @@ func realMain() int {
 
 	return exitCode
 }
+
+// globalFlagNames are accepted by every command:
+//
+//	-region		region to manage (default us-east-1)
+//	-key-region	region of the key pair bucket (default us-west-1)
+//	-profile	shared credentials profile
+//	-role-arn	role to assume, e.g. in a staging account
+var globalFlagNames = []string{"region", "key-region", "profile", "role-arn"}
+
+// extractGlobalFlags removes the global flags from args, in either the
+// "-flag value" or "-flag=value" form.
+func extractGlobalFlags(args []string) ([]string, map[string]string) {
+	globals := make(map[string]string)
+	rest := make([]string, 0, len(args))
+
+	for idx := 0; idx < len(args); idx++ {
+		arg := args[idx]
+		name := strings.TrimLeft(arg, "-")
+		value := ""
+		hasValue := false
+		if eq := strings.Index(name, "="); eq >= 0 {
+			name, value, hasValue = name[:eq], name[eq+1:], true
+		}
+
+		if !strings.HasPrefix(arg, "-") || !isGlobalFlag(name) {
+			rest = append(rest, arg)
+			continue
+		}
+
+		if !hasValue && idx+1 < len(args) {
+			idx++
+			value = args[idx]
+		}
+		globals[name] = value
+	}
+
+	return rest, globals
+}
+
+func isGlobalFlag(name string) bool {
+	for _, global := range globalFlagNames {
+		if name == global {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,121 @@
+package setup
+
+import (
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+	"github.com/aws/aws-sdk-go/service/elbv2/elbv2iface"
+	"github.com/mitchellh/cli"
+	"github.com/mitchellh/multistep"
+)
+
+// StepCreateALB creates an application load balancer with an HTTPS listener
+// forwarding to a target group. The ASG step attaches both ASGs to the
+// target group.
+type StepCreateALB struct {
+	Name            string
+	VpcId           string
+	Subnets         []string
+	Port            int64
+	TargetPort      int64
+	CertificateArn  string
+	HealthCheckPath string
+}
+
+func (s *StepCreateALB) Run(state multistep.StateBag) multistep.StepAction {
+
+	ui := state.Get("ui").(cli.ColoredUi)
+	srv := state.Get("elbv2").(elbv2iface.ELBV2API)
+
+	elbSg := state.Get("elb_sg").(string)
+
+	lbOut, err := srv.CreateLoadBalancer(&elbv2.CreateLoadBalancerInput{
+		Name:           aws.String(s.Name),
+		Subnets:        aws.StringSlice(s.Subnets),
+		SecurityGroups: aws.StringSlice([]string{elbSg}),
+		Scheme:         aws.String("internet-facing"),
+		Tags: []*elbv2.Tag{{
+			Key:   aws.String("Name"),
+			Value: aws.String(s.Name),
+		}},
+	})
+	if err != nil {
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+	lb := lbOut.LoadBalancers[0]
+	state.Put("alb_arn", *lb.LoadBalancerArn)
+	ui.Info(fmt.Sprintf("ALB %s[%s] created", s.Name, *lb.DNSName))
+
+	tgOut, err := srv.CreateTargetGroup(&elbv2.CreateTargetGroupInput{
+		Name:            aws.String(s.Name),
+		Port:            aws.Int64(s.TargetPort),
+		Protocol:        aws.String(elbv2.ProtocolEnumHttp),
+		VpcId:           aws.String(s.VpcId),
+		HealthCheckPath: aws.String(s.HealthCheckPath),
+	})
+	if err != nil {
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+	targetGroupArn := *tgOut.TargetGroups[0].TargetGroupArn
+	state.Put("target_group_arns", []string{targetGroupArn})
+	ui.Info(fmt.Sprintf("Target group %s created", s.Name))
+
+	_, err = srv.CreateListener(&elbv2.CreateListenerInput{
+		LoadBalancerArn: lb.LoadBalancerArn,
+		Port:            aws.Int64(s.Port),
+		Protocol:        aws.String(elbv2.ProtocolEnumHttps),
+		Certificates: []*elbv2.Certificate{{
+			CertificateArn: aws.String(s.CertificateArn),
+		}},
+		DefaultActions: []*elbv2.Action{{
+			Type:           aws.String(elbv2.ActionTypeEnumForward),
+			TargetGroupArn: aws.String(targetGroupArn),
+		}},
+	})
+	if err != nil {
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+	ui.Info(fmt.Sprintf("HTTPS listener on port %d created", s.Port))
+
+	return multistep.ActionContinue
+}
+
+// Cleanup deletes the ALB, and with it its listener, then the target group
+// when a later step failed.
+func (s *StepCreateALB) Cleanup(state multistep.StateBag) {
+	_, cancelled := state.GetOk(multistep.StateCancelled)
+	_, halted := state.GetOk(multistep.StateHalted)
+	if !cancelled && !halted {
+		return
+	}
+
+	ui := state.Get("ui").(cli.ColoredUi)
+	srv := state.Get("elbv2").(elbv2iface.ELBV2API)
+
+	if arn, ok := state.GetOk("alb_arn"); ok {
+		_, err := srv.DeleteLoadBalancer(&elbv2.DeleteLoadBalancerInput{
+			LoadBalancerArn: aws.String(arn.(string)),
+		})
+		if err != nil {
+			ui.Error(err.Error())
+		}
+	}
+
+	if arns, ok := state.GetOk("target_group_arns"); ok {
+		for _, arn := range arns.([]string) {
+			_, err := srv.DeleteTargetGroup(&elbv2.DeleteTargetGroupInput{
+				TargetGroupArn: aws.String(arn),
+			})
+			if err != nil {
+				ui.Error(err.Error())
+			}
+		}
+	}
+	ui.Output("Cleaning up ALBStep")
+}
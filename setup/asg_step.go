@@ -1,44 +1,56 @@
 package setup
 
 import (
-	"fmt"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/autoscaling/autoscalingiface"
 	"github.com/mitchellh/cli"
 	"github.com/mitchellh/multistep"
 	"strings"
 )
 
 type StepCreateAutoScalingGroups struct {
-	AppName string
-	Subnets []string
-	Azs     []string
+	AsgNames []string
+	Subnets  []string
+	Azs      []string
 }
 
 func (s *StepCreateAutoScalingGroups) Run(state multistep.StateBag) multistep.StepAction {
 
-	srv := state.Get("asg").(*autoscaling.AutoScaling)
-	lcName := state.Get("lc_name").(string)
+	srv := state.Get("asg").(autoscalingiface.AutoScalingAPI)
 
 	ui := state.Get("ui").(cli.ColoredUi)
 
-	blue := fmt.Sprintf("%s-prod", s.AppName)
-	green := fmt.Sprintf("%s-prod-green", s.AppName)
-	state.Put("asg_blue", blue)
-	state.Put("asg_green", green)
+	state.Put("asg_names", s.AsgNames)
 
-	asgNames := []string{blue, green}
-	elbName := state.Get("elb_name").(string)
-	for _, asgName := range asgNames {
+	// Apps get either a classic ELB or the target group of an ALB.
+	elbNames := make([]string, 0)
+	if elbName, ok := state.GetOk("elb_name"); ok {
+		elbNames = append(elbNames, elbName.(string))
+	}
+	targetGroupArns := make([]string, 0)
+	if arns, ok := state.GetOk("target_group_arns"); ok {
+		targetGroupArns = arns.([]string)
+	}
+
+	for _, asgName := range s.AsgNames {
 		createAsgInput := &autoscaling.CreateAutoScalingGroupInput{
-			AutoScalingGroupName:    aws.String(asgName),
-			LaunchConfigurationName: aws.String(lcName),
-			VPCZoneIdentifier:       aws.String(strings.Join(s.Subnets, ",")), // so jank
-			AvailabilityZones:       aws.StringSlice(s.Azs),
-			DesiredCapacity:         aws.Int64(0),
-			MaxSize:                 aws.Int64(0),
-			MinSize:                 aws.Int64(0),
-			LoadBalancerNames:       aws.StringSlice([]string{elbName}),
+			AutoScalingGroupName: aws.String(asgName),
+			VPCZoneIdentifier:    aws.String(strings.Join(s.Subnets, ",")), // so jank
+			AvailabilityZones:    aws.StringSlice(s.Azs),
+			DesiredCapacity:      aws.Int64(0),
+			MaxSize:              aws.Int64(0),
+			MinSize:              aws.Int64(0),
+			LoadBalancerNames:    aws.StringSlice(elbNames),
+			TargetGroupARNs:      aws.StringSlice(targetGroupArns),
+		}
+		if templateName, ok := state.GetOk("launch_template_name"); ok {
+			createAsgInput.LaunchTemplate = &autoscaling.LaunchTemplateSpecification{
+				LaunchTemplateName: aws.String(templateName.(string)),
+				Version:            aws.String(state.Get("launch_template_version").(string)),
+			}
+		} else {
+			createAsgInput.LaunchConfigurationName = aws.String(state.Get("lc_name").(string))
 		}
 
 		_, err := srv.CreateAutoScalingGroup(createAsgInput)
@@ -59,11 +71,12 @@ func (s *StepCreateAutoScalingGroups) Cleanup(state multistep.StateBag) {
 	}
 
 	ui := state.Get("ui").(cli.ColoredUi)
-	blue := state.Get("asg_blue").(string)
-	green := state.Get("asg_green").(string)
-	srv := state.Get("asg").(*autoscaling.AutoScaling)
-	asgs := []string{blue, green}
-	for _, asg := range asgs {
+	asgs, ok := state.GetOk("asg_names")
+	if !ok {
+		return
+	}
+	srv := state.Get("asg").(autoscalingiface.AutoScalingAPI)
+	for _, asg := range asgs.([]string) {
 		_, err := srv.DeleteAutoScalingGroup(&autoscaling.DeleteAutoScalingGroupInput{
 			AutoScalingGroupName: aws.String(asg),
 		})
@@ -4,12 +4,13 @@ import (
 	"fmt"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/elb"
+	"github.com/aws/aws-sdk-go/service/elb/elbiface"
 	"github.com/mitchellh/cli"
 	"github.com/mitchellh/multistep"
 )
 
 type StepCreateELB struct {
-	AppName    string
+	ElbName    string
 	ElbOutPort int64
 	ElbInPort  int64
 	Subnets    []string
@@ -18,9 +19,9 @@ type StepCreateELB struct {
 func (s *StepCreateELB) Run(state multistep.StateBag) multistep.StepAction {
 
 	ui := state.Get("ui").(cli.ColoredUi)
-	srv := state.Get("elb").(*elb.ELB)
+	srv := state.Get("elb").(elbiface.ELBAPI)
 
-	elbName := fmt.Sprintf("%s-prod", s.AppName)
+	elbName := s.ElbName
 
 	elbSg := state.Get("elb_sg").(string)
 
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/autoscaling/autoscalingiface"
 	"github.com/mitchellh/cli"
 	"github.com/mitchellh/multistep"
 )
@@ -18,7 +19,7 @@ type StepLaunchConfiguration struct {
 
 func (s *StepLaunchConfiguration) Run(state multistep.StateBag) multistep.StepAction {
 
-	srv := state.Get("asg").(*autoscaling.AutoScaling)
+	srv := state.Get("asg").(autoscalingiface.AutoScalingAPI)
 
 	ui := state.Get("ui").(cli.ColoredUi)
 
@@ -52,7 +53,7 @@ func (s *StepLaunchConfiguration) Cleanup(state multistep.StateBag) {
 
 	ui := state.Get("ui").(cli.ColoredUi)
 	ui.Output("Cleaning up StepLaunchConfiguration")
-	srv := state.Get("asg").(*autoscaling.AutoScaling)
+	srv := state.Get("asg").(autoscalingiface.AutoScalingAPI)
 	lcName := state.Get("lc_name").(string)
 	_, err := srv.DeleteLaunchConfiguration(&autoscaling.DeleteLaunchConfigurationInput{
 		LaunchConfigurationName: aws.String(lcName),
@@ -0,0 +1,73 @@
+package setup
+
+import (
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/mitchellh/cli"
+	"github.com/mitchellh/multistep"
+)
+
+// StepLaunchTemplate creates the launch template of the app, with a
+// placeholder first version the ASGs are created with.
+type StepLaunchTemplate struct {
+	Name           string
+	ImageId        string
+	SecurityGroups []string
+	KeyName        string
+	InstanceType   string
+}
+
+func (s *StepLaunchTemplate) Run(state multistep.StateBag) multistep.StepAction {
+
+	srv := state.Get("ec2").(ec2iface.EC2API)
+
+	ui := state.Get("ui").(cli.ColoredUi)
+
+	resp, err := srv.CreateLaunchTemplate(&ec2.CreateLaunchTemplateInput{
+		LaunchTemplateName: aws.String(s.Name),
+		VersionDescription: aws.String("0.0.0"),
+		LaunchTemplateData: &ec2.RequestLaunchTemplateData{
+			ImageId:          aws.String(s.ImageId),
+			SecurityGroupIds: aws.StringSlice(s.SecurityGroups),
+			KeyName:          aws.String(s.KeyName),
+			InstanceType:     aws.String(s.InstanceType),
+		},
+	})
+	if err != nil {
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+
+	state.Put("launch_template_name", s.Name)
+	state.Put("launch_template_version", fmt.Sprintf("%d", *resp.LaunchTemplate.LatestVersionNumber))
+	ui.Info(fmt.Sprintf("Launch template %s created", s.Name))
+
+	return multistep.ActionContinue
+}
+
+func (s *StepLaunchTemplate) Cleanup(state multistep.StateBag) {
+	_, cancelled := state.GetOk(multistep.StateCancelled)
+	_, halted := state.GetOk(multistep.StateHalted)
+	if !cancelled && !halted {
+		return
+	}
+
+	if _, ok := state.GetOk("launch_template_name"); !ok {
+		return
+	}
+
+	ui := state.Get("ui").(cli.ColoredUi)
+	ui.Output("Cleaning up StepLaunchTemplate")
+	srv := state.Get("ec2").(ec2iface.EC2API)
+	_, err := srv.DeleteLaunchTemplate(&ec2.DeleteLaunchTemplateInput{
+		LaunchTemplateName: aws.String(s.Name),
+	})
+	if err != nil {
+		ui.Error(err.Error())
+		return
+	}
+	ui.Info("Successfully deleted launch template")
+}
@@ -4,23 +4,26 @@ import (
 	"fmt"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
 	"github.com/mitchellh/cli"
 	"github.com/mitchellh/multistep"
 )
 
 type StepCreateSecurityGroups struct {
 	AppName   string
+	Env       string
 	VpcId     string
 	AppInPort int64
+	AccountId string
 }
 
 func (s *StepCreateSecurityGroups) Run(state multistep.StateBag) multistep.StepAction {
 
 	ui := state.Get("ui").(cli.ColoredUi)
 
-	srv := state.Get("ec2").(*ec2.EC2)
+	srv := state.Get("ec2").(ec2iface.EC2API)
 
-	elbSgName := fmt.Sprintf("elb-%s-prod", s.AppName)
+	elbSgName := fmt.Sprintf("elb-%s-%s", s.AppName, s.Env)
 
 	input := &ec2.CreateSecurityGroupInput{
 		VpcId:       aws.String(s.VpcId),
@@ -85,7 +88,7 @@ func (s *StepCreateSecurityGroups) Run(state multistep.StateBag) multistep.StepA
 	ui.Info(fmt.Sprintf("%s[%s] ingress rule created", elbSgName, *elbSgOut.GroupId))
 	// APP SG
 
-	appSgName := fmt.Sprintf("%s-prod", s.AppName)
+	appSgName := fmt.Sprintf("%s-%s", s.AppName, s.Env)
 
 	input = &ec2.CreateSecurityGroupInput{
 		VpcId:       aws.String(s.VpcId),
@@ -131,7 +134,7 @@ func (s *StepCreateSecurityGroups) Run(state multistep.StateBag) multistep.StepA
 				UserIdGroupPairs: []*ec2.UserIdGroupPair{
 					{
 						GroupId: elbSgOut.GroupId,
-						UserId:  aws.String("053216739513"),
+						UserId:  aws.String(s.AccountId),
 					},
 				},
 			},